@@ -0,0 +1,197 @@
+// Package applet implements the host side of the protocols spoken by the PROG05 applets running in the HC05 RAM
+package applet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sonikku2k/PROG05/bootloader"
+)
+
+// S-record files of the shipped applets
+const (
+	MEMREAD  = "memread.s19"     // Read any address: host sends address hi/lo, applet answers with the byte
+	MEMBLOCK = "memblock.s19"    // Read a block: host sends address hi/lo and count, applet answers with the bytes and a checksum
+	MEMWRITE = "memwrite.s19"    // Write any address: host sends address hi/lo and data
+	MEMPROG  = "memprog.s19"     // Program an EPROM byte: host sends address hi/lo and data, applet answers with the read back
+	GOTEST   = "hc05_gotest.s19" // Sends the "HC05" banner once running
+	DEMO     = "hc05demo.s19"    // Toggles the PORT A pins
+)
+
+// ErrTimeout matches the error returned when the applet does not answer in time (a *bootloader.TimeoutError)
+var ErrTimeout = bootloader.ErrTimeout
+
+// ErrChecksum is returned when a block read by memblock does not match the checksum sent with it
+var ErrChecksum = errors.New("block checksum mismatch")
+
+// Largest block memblock sends for one request (a count byte of 0)
+const MAX_BLOCK = 256
+
+// Longest wait for the answer of memread (a byte, a few mS at 4800 baud)
+const READ_TIMEOUT = 100 * time.Millisecond
+
+// Longest wait for the answer of memprog, the programming pulse alone takes 5-10mS
+const PROGRAM_TIMEOUT = 200 * time.Millisecond
+
+// Longest wait for the first byte of a block, each further byte adds BLOCK_BYTE_TIME
+const BLOCK_BYTE_TIMEOUT = 100 * time.Millisecond
+const BLOCK_BYTE_TIME = 5 * time.Millisecond // A byte takes 4.2mS at 2400 baud
+
+// Time allowed for the gotest applet to send its banner
+const BANNER_TIMEOUT = 800 * time.Millisecond
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: sendBytes
+// Function: Transmit bytes to the applet with 1mS in between so the SCI polling loop keeps up
+// Parameters: Transport, bytes to send
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func sendBytes(port bootloader.Transport, data ...byte) error {
+
+	for i, b := range data {
+		if i > 0 {
+			time.Sleep(1 * time.Millisecond)
+		}
+		err := port.WriteByte(b)
+		if err != nil {
+			return fmt.Errorf("error sending byte on serial port...(%d): %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: waitResponse
+// Function: Wait for the one byte answer of the applet
+// Parameters: Transport, timeout
+// Returns: Byte received, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func waitResponse(port bootloader.Transport, timeout time.Duration) (byte, error) {
+
+	data, err := port.ReadN(1, timeout)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// blockTimeout is the longest time a block of count bytes and its checksum can take to arrive
+func blockTimeout(count int) time.Duration {
+	return BLOCK_BYTE_TIMEOUT + time.Duration(count)*BLOCK_BYTE_TIME
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ReadByte
+// Function: Reads byte from specified address in the HC05 (memread applet)
+// Parameters: Transport, Address
+// Returns: Byte read, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func ReadByte(port bootloader.Transport, address uint16) (byte, error) {
+
+	port.Flush()
+	err := sendBytes(port, uint8(address>>8), uint8(address))
+	if err != nil {
+		return 0, err
+	}
+	return waitResponse(port, READ_TIMEOUT)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ReadBlock
+// Function: Reads a block of bytes from the specified address in the HC05 (memblock applet), the block ends with the
+// 8 bit sum of the request bytes and of the bytes sent, which must match
+// Parameters: Transport, Address, number of bytes (1 - MAX_BLOCK)
+// Returns: Bytes read, error if any (ErrTimeout, ErrChecksum)
+// -------------------------------------------------------------------------------------------------------------------
+func ReadBlock(port bootloader.Transport, address uint16, count int) ([]byte, error) {
+
+	if count < 1 || count > MAX_BLOCK {
+		return nil, fmt.Errorf("invalid block size %d", count)
+	}
+	port.Flush()
+	request := []byte{uint8(address >> 8), uint8(address), uint8(count)} // A count of 256 is sent as 0
+	err := sendBytes(port, request...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := port.ReadN(count+1, blockTimeout(count))
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := request[0] + request[1] + request[2]
+	for _, b := range data[:count] {
+		checksum += b
+	}
+	if data[count] != checksum {
+		return nil, ErrChecksum
+	}
+	return data[:count], nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ResyncBlock
+// Function: Bring the memblock applet back to the start of a request after a timeout: a request byte may have been
+// lost, so the applet could still be waiting for one or two bytes. Bytes are sent one at a time until the applet
+// answers, its answer is then drained
+// Parameters: Transport
+// -------------------------------------------------------------------------------------------------------------------
+func ResyncBlock(port bootloader.Transport) {
+
+	for n := 0; n < 3; n++ {
+		port.Flush()
+		if sendBytes(port, 0) != nil {
+			return
+		}
+		if _, err := port.ReadN(1, BLOCK_BYTE_TIMEOUT); err == nil {
+			// The longest answer is a whole block, let it finish before dropping it
+			time.Sleep(blockTimeout(MAX_BLOCK))
+			port.Flush()
+			return
+		}
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: WriteByte
+// Function: Writes byte at specified address in the HC05 (memwrite applet)
+// Parameters: Transport, Address, Data
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func WriteByte(port bootloader.Transport, address uint16, data byte) error {
+
+	port.Flush()
+	return sendBytes(port, uint8(address>>8), uint8(address), data)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ProgramByte
+// Function: Programs a byte at the specified EPROM address (memprog applet) and reads it back
+// Parameters: Transport, Address, Data
+// Returns: Byte read back after programming, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func ProgramByte(port bootloader.Transport, address uint16, data byte) (byte, error) {
+
+	port.Flush()
+	err := sendBytes(port, uint8(address>>8), uint8(address), data)
+	if err != nil {
+		return 0, err
+	}
+	return waitResponse(port, PROGRAM_TIMEOUT)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CheckBanner
+// Function: Wait for the gotest applet to send its banner
+// Parameters: Transport
+// Returns: true if the "HC05" banner was received
+// -------------------------------------------------------------------------------------------------------------------
+func CheckBanner(port bootloader.Transport) bool {
+
+	port.Flush()
+	// Allow time for the HC05 to have sent its string to the host, whatever arrived by then is searched
+	banner, _ := port.ReadN(bootloader.RX_BUFFER_SIZE, BANNER_TIMEOUT)
+	return bytes.Contains(banner, []byte("HC05"))
+}
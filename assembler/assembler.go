@@ -0,0 +1,562 @@
+// Package assembler translates HC05 assembly source written for CASM05 into S-records
+//
+// The dialect is the one used by the PROG05 applets: labels (with or without a colon) in the first column,
+// '*' comment lines, ';' comments, EQU, ORG, DS/RMB, FCB, FDB, FCC and END directives, $hex, %binary, @octal,
+// decimal and 'c' character literals, '*' for the location counter and + - in expressions.
+package assembler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sonikku2k/PROG05/hc05"
+	"github.com/sonikku2k/PROG05/srec"
+)
+
+// Program is the result of an assembly
+type Program struct {
+	Segments []srec.Segment    // Code and data, by ascending address
+	Symbols  map[string]uint16 // Labels and EQU values (upper case)
+	Start    uint16            // Operand of END, 0 if none
+}
+
+// Mnemonics accepted as synonyms of the ones in the opcode map
+var aliases = map[string]string{
+	"ASL": "LSL", "ASLA": "LSLA", "ASLX": "LSLX", "BHS": "BCC", "BLO": "BCS",
+}
+
+// line is a source line split into its fields
+type line struct {
+	number   int
+	label    string
+	mnemonic string
+	operand  string
+}
+
+// assembly holds the state shared by both passes
+type assembly struct {
+	name     string
+	lines    []line
+	symbols  map[string]uint16
+	modes    map[int]int // Addressing mode chosen in pass 1, by line number
+	location int
+	memory   map[uint16]byte
+	start    uint16
+	pass     int
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: AssembleFile
+// Function: Assemble a source file
+// Parameters: Path to the source file
+// Returns: Assembled program, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func AssembleFile(path string) (*Program, error) {
+
+	source, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+	defer source.Close()
+	return Assemble(source, path)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Assemble
+// Function: Assemble source code in two passes, the first one sizes every instruction and collects the symbols
+// Parameters: Reader supplying the source, name used in error messages
+// Returns: Assembled program, error (name:line: message) if any
+// -------------------------------------------------------------------------------------------------------------------
+func Assemble(source io.Reader, name string) (*Program, error) {
+
+	a := &assembly{
+		name:    name,
+		symbols: make(map[string]uint16),
+		modes:   make(map[int]int),
+	}
+	scanner := bufio.NewScanner(source)
+	number := 0
+	for scanner.Scan() {
+		number++
+		l, ok := split(scanner.Text())
+		if ok {
+			l.number = number
+			a.lines = append(a.lines, l)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.location = 0
+		a.memory = make(map[uint16]byte)
+		for _, l := range a.lines {
+			done, err := a.statement(l)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", a.name, l.number, err)
+			}
+			if done {
+				break
+			}
+		}
+	}
+
+	return &Program{Segments: a.segments(), Symbols: a.symbols, Start: a.start}, nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: WriteSrec
+// Function: Write the program as S-records
+// Parameters: Writer
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Program) WriteSrec(w io.Writer) error {
+	return srec.Write(w, p.Segments, p.Start)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Srec
+// Function: Return the program as S-records, ready for srec.Read
+// Returns: S-records
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Program) Srec() []byte {
+	var buffer bytes.Buffer
+	p.WriteSrec(&buffer)
+	return buffer.Bytes()
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: split
+// Function: Cut a source line into label, mnemonic and operand, comments are dropped
+// Parameters: Source line
+// Returns: Fields, false if the line holds nothing
+// -------------------------------------------------------------------------------------------------------------------
+func split(text string) (line, bool) {
+
+	var l line
+	if strings.HasPrefix(text, "*") {
+		return l, false
+	}
+	// A ';' outside a quoted string starts a comment
+	quote := rune(0)
+	for i, c := range text {
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if c == '\'' || c == '"' {
+			quote = c
+		}
+		if c == ';' {
+			text = text[:i]
+			break
+		}
+	}
+	if strings.TrimSpace(text) == "" {
+		return l, false
+	}
+
+	fields := strings.Fields(text)
+	// A label starts in the first column, or ends with a colon
+	if text[0] != ' ' && text[0] != '\t' || strings.HasSuffix(fields[0], ":") {
+		l.label = strings.TrimSuffix(fields[0], ":")
+		fields = fields[1:]
+	}
+	if len(fields) > 0 {
+		l.mnemonic = strings.ToUpper(fields[0])
+	}
+	if len(fields) > 1 {
+		l.operand = fields[1]
+		// Strings may hold spaces, so the operand of FCC is everything after the mnemonic
+		if l.mnemonic == "FCC" {
+			rest := strings.TrimSpace(text)
+			if l.label != "" {
+				rest = strings.TrimSpace(rest[strings.IndexAny(rest, " \t"):])
+			}
+			l.operand = strings.TrimSpace(rest[len(fields[0]):])
+		}
+	}
+	return l, true
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: statement
+// Function: Process one line in the current pass
+// Parameters: Line
+// Returns: true once END is met, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (a *assembly) statement(l line) (bool, error) {
+
+	if l.mnemonic == "EQU" {
+		if l.label == "" {
+			return false, fmt.Errorf("EQU without a label")
+		}
+		value, err := a.expression(l.operand, true)
+		if err != nil {
+			return false, err
+		}
+		a.define(l.label, value)
+		return false, nil
+	}
+	if l.label != "" {
+		a.define(l.label, a.location)
+	}
+
+	switch l.mnemonic {
+	case "":
+		return false, nil
+	case "ORG":
+		value, err := a.expression(l.operand, true)
+		if err != nil {
+			return false, err
+		}
+		a.location = value
+	case "DS", "RMB":
+		value, err := a.expression(l.operand, true)
+		if err != nil {
+			return false, err
+		}
+		a.location += value
+	case "FCB", "DB":
+		for _, item := range strings.Split(l.operand, ",") {
+			value, err := a.expression(item, a.pass == 2)
+			if err != nil {
+				return false, err
+			}
+			a.emit(byte(value))
+		}
+	case "FDB", "DW":
+		for _, item := range strings.Split(l.operand, ",") {
+			value, err := a.expression(item, a.pass == 2)
+			if err != nil {
+				return false, err
+			}
+			a.emit(byte(value>>8), byte(value))
+		}
+	case "FCC":
+		if len(l.operand) < 2 || l.operand[len(l.operand)-1] != l.operand[0] {
+			return false, fmt.Errorf("badly delimited string %s", l.operand)
+		}
+		a.emit([]byte(l.operand[1 : len(l.operand)-1])...)
+	case "END":
+		if l.operand != "" {
+			value, err := a.expression(l.operand, a.pass == 2)
+			if err != nil {
+				return false, err
+			}
+			a.start = uint16(value)
+		}
+		return true, nil
+	default:
+		return false, a.instruction(l)
+	}
+	if a.location > 0xFFFF {
+		return false, fmt.Errorf("location counter overflow")
+	}
+	return false, nil
+}
+
+// define records a symbol, symbols are not case sensitive
+func (a *assembly) define(label string, value int) {
+	a.symbols[strings.ToUpper(label)] = uint16(value)
+}
+
+// emit stores bytes at the location counter (pass 2 only) and advances it
+func (a *assembly) emit(data ...byte) {
+	for _, b := range data {
+		if a.pass == 2 {
+			a.memory[uint16(a.location)] = b
+		}
+		a.location++
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: expression
+// Function: Evaluate an expression made of terms added or subtracted
+// Parameters: Expression, true if every symbol has to be known
+// Returns: Value (0 for unknown symbols in pass 1), error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (a *assembly) expression(text string, required bool) (int, error) {
+
+	value, _, err := a.evaluate(text, required)
+	return value, err
+}
+
+// evaluate also reports whether every symbol of the expression was known
+func (a *assembly) evaluate(text string, required bool) (int, bool, error) {
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, false, fmt.Errorf("missing operand")
+	}
+	total := 0
+	known := true
+	sign := 1
+	for len(text) > 0 {
+		// Operators between terms, a leading '-' negates the first term
+		if text[0] == '+' || text[0] == '-' {
+			if text[0] == '-' {
+				sign = -sign
+			}
+			text = text[1:]
+			continue
+		}
+		end := termLength(text)
+		value, ok, err := a.term(text[:end], required)
+		if err != nil {
+			return 0, false, err
+		}
+		known = known && ok
+		total += sign * value
+		sign = 1
+		text = text[end:]
+	}
+	return total, known, nil
+}
+
+// termLength returns the length of the term at the start of text
+func termLength(text string) int {
+	if text[0] == '\'' && len(text) >= 2 {
+		if len(text) >= 3 && text[2] == '\'' {
+			return 3
+		}
+		return 2
+	}
+	if text[0] == '*' {
+		return 1
+	}
+	end := strings.IndexAny(text[1:], "+-")
+	if end < 0 {
+		return len(text)
+	}
+	return end + 1
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: term
+// Function: Evaluate a number, character, symbol or the location counter
+// Parameters: Term, true if a symbol has to be known
+// Returns: Value, true if known, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (a *assembly) term(text string, required bool) (int, bool, error) {
+
+	var value uint64
+	var err error
+	switch {
+	case text == "*":
+		return a.location, true, nil
+	case text[0] == '\'':
+		return int(text[1]), true, nil
+	case text[0] == '$':
+		value, err = strconv.ParseUint(text[1:], 16, 16)
+	case text[0] == '%':
+		value, err = strconv.ParseUint(text[1:], 2, 16)
+	case text[0] == '@':
+		value, err = strconv.ParseUint(text[1:], 8, 16)
+	case text[0] >= '0' && text[0] <= '9':
+		value, err = strconv.ParseUint(text, 10, 16)
+	default:
+		symbol, ok := a.symbols[strings.ToUpper(text)]
+		if !ok && (required || a.pass == 2) {
+			return 0, false, fmt.Errorf("undefined symbol %s", text)
+		}
+		return int(symbol), ok, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid number %s", text)
+	}
+	return int(value), true, nil
+}
+
+// opcode finds the opcode of a mnemonic in a given addressing mode
+func opcode(mnemonic string, mode int) (byte, bool) {
+	for n, entry := range hc05.OPCODES {
+		if entry.Mnemonic == mnemonic && entry.Mode == mode {
+			return byte(n), true
+		}
+	}
+	return 0, false
+}
+
+// hasMode tells whether a mnemonic exists in a given addressing mode
+func hasMode(mnemonic string, mode int) bool {
+	_, ok := opcode(mnemonic, mode)
+	return ok
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: instruction
+// Function: Size (pass 1) or encode (pass 2) an instruction
+// Parameters: Line
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (a *assembly) instruction(l line) error {
+
+	mnemonic := l.mnemonic
+	if alias, ok := aliases[mnemonic]; ok {
+		mnemonic = alias
+	}
+	operand := l.operand
+
+	// Bit manipulation: the bit number is the first operand
+	if mnemonic == "BRSET" || mnemonic == "BRCLR" || mnemonic == "BSET" || mnemonic == "BCLR" {
+		return a.bitInstruction(mnemonic, operand)
+	}
+	if hasMode(mnemonic, hc05.REL) {
+		code, _ := opcode(mnemonic, hc05.REL)
+		target, err := a.expression(operand, a.pass == 2)
+		if err != nil {
+			return err
+		}
+		offset := target - (a.location + 2)
+		if a.pass == 2 && (offset < -128 || offset > 127) {
+			return fmt.Errorf("branch out of range")
+		}
+		a.emit(code, byte(offset))
+		return nil
+	}
+
+	mode, value, err := a.addressingMode(l.number, mnemonic, operand)
+	if err != nil {
+		return err
+	}
+	code, ok := opcode(mnemonic, mode)
+	if !ok {
+		return fmt.Errorf("invalid instruction or addressing mode: %s %s", l.mnemonic, operand)
+	}
+	switch hc05.OPCODES[code].Length() {
+	case 1:
+		a.emit(code)
+	case 2:
+		if a.pass == 2 && (value < -128 || value > 0xFF) {
+			return fmt.Errorf("operand out of range: %s", operand)
+		}
+		a.emit(code, byte(value))
+	case 3:
+		a.emit(code, byte(value>>8), byte(value))
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: addressingMode
+// Function: Work out the addressing mode from the operand, short forms are chosen in pass 1 and kept in pass 2
+// Parameters: Line number, mnemonic, operand
+// Returns: Addressing mode, operand value, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (a *assembly) addressingMode(number int, mnemonic string, operand string) (int, int, error) {
+
+	upper := strings.ToUpper(operand)
+	switch {
+	case operand == "":
+		return hc05.INH, 0, nil
+	case operand[0] == '#':
+		value, err := a.expression(operand[1:], a.pass == 2)
+		return hc05.IMM, value, err
+	case upper == ",X" || upper == "X":
+		return hc05.IX, 0, nil
+	case strings.HasSuffix(upper, ",X"):
+		value, known, err := a.evaluate(operand[:len(operand)-2], a.pass == 2)
+		if err != nil {
+			return 0, 0, err
+		}
+		if a.pass == 1 {
+			mode := hc05.IX2
+			if known && value == 0 && hasMode(mnemonic, hc05.IX) {
+				mode = hc05.IX
+			} else if known && value >= 0 && value <= 0xFF || !hasMode(mnemonic, hc05.IX2) {
+				mode = hc05.IX1
+			}
+			a.modes[number] = mode
+		}
+		return a.modes[number], value, nil
+	default:
+		value, known, err := a.evaluate(operand, a.pass == 2)
+		if err != nil {
+			return 0, 0, err
+		}
+		if a.pass == 1 {
+			mode := hc05.EXT
+			if known && value >= 0 && value <= 0xFF || !hasMode(mnemonic, hc05.EXT) {
+				mode = hc05.DIR
+			}
+			a.modes[number] = mode
+		}
+		return a.modes[number], value, nil
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: bitInstruction
+// Function: Encode BRSET/BRCLR (bit,address,target) and BSET/BCLR (bit,address)
+// Parameters: Mnemonic, operand
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (a *assembly) bitInstruction(mnemonic string, operand string) error {
+
+	fields := strings.Split(operand, ",")
+	branch := mnemonic == "BRSET" || mnemonic == "BRCLR"
+	if branch && len(fields) != 3 || !branch && len(fields) != 2 {
+		return fmt.Errorf("invalid operand for %s: %s", mnemonic, operand)
+	}
+	bit, err := a.expression(fields[0], true)
+	if err != nil {
+		return err
+	}
+	if bit < 0 || bit > 7 {
+		return fmt.Errorf("bit number out of range: %s", fields[0])
+	}
+	address, err := a.expression(fields[1], a.pass == 2)
+	if err != nil {
+		return err
+	}
+	if a.pass == 2 && (address < 0 || address > 0xFF) {
+		return fmt.Errorf("address out of direct page: %s", fields[1])
+	}
+	code, _ := opcode(fmt.Sprintf("%s%d", mnemonic, bit), map[bool]int{true: hc05.BTB, false: hc05.BSC}[branch])
+	if !branch {
+		a.emit(code, byte(address))
+		return nil
+	}
+	target, err := a.expression(fields[2], a.pass == 2)
+	if err != nil {
+		return err
+	}
+	offset := target - (a.location + 3)
+	if a.pass == 2 && (offset < -128 || offset > 127) {
+		return fmt.Errorf("branch out of range")
+	}
+	a.emit(code, byte(address), byte(offset))
+	return nil
+}
+
+// segments gathers the bytes emitted into runs of contiguous addresses
+func (a *assembly) segments() []srec.Segment {
+
+	addresses := make([]int, 0, len(a.memory))
+	for address := range a.memory {
+		addresses = append(addresses, int(address))
+	}
+	sort.Ints(addresses)
+
+	var segments []srec.Segment
+	for _, address := range addresses {
+		n := len(segments)
+		if n > 0 && int(segments[n-1].Address)+len(segments[n-1].Data) == address {
+			segments[n-1].Data = append(segments[n-1].Data, a.memory[uint16(address)])
+			continue
+		}
+		segments = append(segments, srec.Segment{Address: uint16(address), Data: []byte{a.memory[uint16(address)]}})
+	}
+	return segments
+}
@@ -0,0 +1,29 @@
+package assembler
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// The applet sources must assemble to the S-records PROG05 ships and uploads
+func TestShippedApplets(t *testing.T) {
+	for _, name := range []string{"memread", "memwrite", "memprog", "memblock"} {
+		program, err := AssembleFile("../hc05_applet_src/" + name + ".asm")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		want, err := os.ReadFile("../srec/" + name + ".s19")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		if err := program.WriteSrec(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(bytes.TrimSpace(got.Bytes()), bytes.TrimSpace(want)) {
+			t.Errorf("%s.asm assembles to\n%s\nthe shipped %s.s19 holds\n%s", name, got.Bytes(), name, want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package board drives the RESET, IRQ, Vpp and mode lines of a programmer board, so the HC05 is put into its
+// bootloader and programmed without anyone moving jumpers or pressing switches
+//
+// Each board signal is wired to a line of a Driver: the DTR and RTS outputs of the serial port talking to the HC05,
+// or the outputs of a small controller on a second serial port (see Gpio). Signals left out of the pin map are set by
+// the operator as before.
+package board
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signals of a programmer board
+const RESET = "RESET" // Holds the HC05 in reset while on
+const IRQ = "IRQ"     // Applies 9.4V to IRQ while on, the mask ROM then starts the bootloader when reset is released
+const VPP = "VPP"     // Applies the programming voltage to VPP while on
+const PD5 = "PD5"     // PD5-PD2 select the bootloader routine when reset is released, on is a high level
+const PD4 = "PD4"
+const PD3 = "PD3"
+const PD2 = "PD2"
+
+// Every signal, in the order they are listed and checked
+var SIGNALS = []string{RESET, IRQ, VPP, PD5, PD4, PD3, PD2}
+
+// Mode lines, most significant first
+var MODE_LINES = []string{PD5, PD4, PD3, PD2}
+
+// Levels of PD5-PD2 selecting the routine that loads code into RAM through the SCI and runs it: MC68HC05PGMR switches
+// S3-S5 OFF, S6 ON (a closed switch pulls its line to 0)
+const MODE_SCI_LOADER = 0x0E
+
+// Modem lines of a serial port, Modem drives them
+var MODEM_LINES = []string{"DTR", "RTS"}
+
+// Outputs of the GPIO controller, numbered from 0
+const GPIO_OUTPUTS = 8
+
+// Timings used when the configuration gives none
+const RESET_PULSE = 20 * time.Millisecond  // RESET held on
+const RESET_SETTLE = 50 * time.Millisecond // Release of RESET to the first byte sent, the HC05 waits 4064 cycles first
+const VPP_SETTLE = 50 * time.Millisecond   // Vpp switched to the first byte programmed
+
+// Driver sets the output lines the board signals are wired to
+type Driver interface {
+	SetLine(line string, level bool) error
+}
+
+// Pin is the line a signal is wired to
+type Pin struct {
+	Line     string // DTR, RTS or a GPIO output number
+	Inverted bool   // The line is low when the signal is on
+}
+
+// Control drives the signals of a board through a Driver
+type Control struct {
+	Driver Driver
+	Pins   map[string]Pin // By signal, signals left out are set by the operator
+	Pulse  time.Duration  // RESET held on
+	Settle time.Duration  // Release of RESET to the first byte sent to the bootloader
+	Vpp    time.Duration  // Vpp switched to the first byte programmed
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ParsePins
+// Function: Read a pin map, e.g. {"RESET": "DTR", "IRQ": "!RTS"}, or {"RESET": "0", "VPP": "!1"} for GPIO outputs
+// Parameters: Line of each signal ("!" in front inverts it), true if the lines are GPIO outputs rather than modem lines
+// Returns: Pins by signal, problems found (one line each)
+// -------------------------------------------------------------------------------------------------------------------
+func ParsePins(lines map[string]string, gpio bool) (map[string]Pin, []string) {
+
+	var problems []string
+	pins := make(map[string]Pin)
+	used := make(map[string]string)
+	var signals []string
+	for signal := range lines {
+		signals = append(signals, signal)
+	}
+	sort.Strings(signals)
+	for _, signal := range signals {
+		name := strings.ToUpper(signal)
+		if !isSignal(name) {
+			problems = append(problems, fmt.Sprintf("\"%s\" is not a board signal (%s)", signal, strings.Join(SIGNALS, ", ")))
+			continue
+		}
+		if _, found := pins[name]; found {
+			problems = append(problems, fmt.Sprintf("%s given twice", name))
+			continue
+		}
+		pin, err := ParsePin(lines[signal], gpio)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		if other, found := used[pin.Line]; found {
+			problems = append(problems, fmt.Sprintf("%s: line %s already drives %s", name, pin.Line, other))
+			continue
+		}
+		used[pin.Line] = name
+		pins[name] = pin
+	}
+	return pins, problems
+}
+
+// ParsePin reads the line of a signal: DTR or RTS, or a GPIO output number, "!" in front for a line low when on
+func ParsePin(text string, gpio bool) (Pin, error) {
+
+	var pin Pin
+	line := strings.ToUpper(strings.TrimSpace(text))
+	if strings.HasPrefix(line, "!") {
+		pin.Inverted = true
+		line = strings.TrimSpace(line[1:])
+	}
+	if gpio {
+		output, err := strconv.Atoi(line)
+		if err != nil || output < 0 || output >= GPIO_OUTPUTS {
+			return pin, fmt.Errorf("\"%s\" is not a GPIO output (0 - %d)", text, GPIO_OUTPUTS-1)
+		}
+		pin.Line = strconv.Itoa(output)
+		return pin, nil
+	}
+	for _, modem := range MODEM_LINES {
+		if line == modem {
+			pin.Line = line
+			return pin, nil
+		}
+	}
+	return pin, fmt.Errorf("\"%s\" is not a modem line (%s, or a GPIO output when \"gpio\" is given)", text, strings.Join(MODEM_LINES, " or "))
+}
+
+// Direct wires every signal to the driver line of the same name, the simulated HC05 is driven this way
+func Direct(driver Driver) *Control {
+	pins := make(map[string]Pin)
+	for _, signal := range SIGNALS {
+		pins[signal] = Pin{Line: signal}
+	}
+	return &Control{Driver: driver, Pins: pins, Pulse: RESET_PULSE, Settle: RESET_SETTLE, Vpp: VPP_SETTLE}
+}
+
+// Drives tells whether PROG05 sets a signal, false for a nil Control
+func (c *Control) Drives(signal string) bool {
+	if c == nil {
+		return false
+	}
+	_, found := c.Pins[signal]
+	return found
+}
+
+// HandsFree tells whether the bootloader is started without the operator: RESET and IRQ are both driven (the mode
+// lines may be strapped on the board)
+func (c *Control) HandsFree() bool {
+	return c.Drives(RESET) && c.Drives(IRQ)
+}
+
+// Set switches a signal on or off, nothing happens when it is not driven
+func (c *Control) Set(signal string, on bool) error {
+
+	pin, found := c.Pins[signal]
+	if !found {
+		return nil
+	}
+	err := c.Driver.SetLine(pin.Line, on != pin.Inverted)
+	if err != nil {
+		return fmt.Errorf("cannot switch %s %s: %w", signal, onOff(on), err)
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: EnterLoader
+// Function: Start the bootloader: Vpp off, RESET on, 9.4V on IRQ and PD5-PD2 set to the SCI loader routine, then RESET
+// released once Pulse has elapsed. Returns after Settle, when the bootloader waits for its length byte.
+// Returns: error if a line cannot be set
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Control) EnterLoader() error {
+
+	if !c.Drives(RESET) {
+		return errors.New("RESET is not in the pin map, the HC05 cannot be reset")
+	}
+	err := c.Set(VPP, false)
+	if err == nil {
+		err = c.Set(RESET, true)
+	}
+	if err == nil {
+		err = c.Set(IRQ, true)
+	}
+	for n, signal := range MODE_LINES {
+		if err == nil {
+			err = c.Set(signal, MODE_SCI_LOADER&(0x08>>n) != 0)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	time.Sleep(c.Pulse)
+	err = c.Set(RESET, false)
+	if err != nil {
+		return err
+	}
+	time.Sleep(c.Settle)
+	return nil
+}
+
+// SetVpp switches the programming voltage, and once it is on waits for it to settle
+func (c *Control) SetVpp(on bool) error {
+
+	err := c.Set(VPP, on)
+	if err != nil {
+		return err
+	}
+	if on {
+		time.Sleep(c.Vpp)
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Idle
+// Function: Leave the board safe: Vpp off, 9.4V off IRQ, RESET released and the mode lines high. The code running in
+// the HC05 carries on.
+// Returns: error if a line cannot be set
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Control) Idle() error {
+
+	if c == nil {
+		return nil
+	}
+	err := c.Set(VPP, false)
+	if err == nil {
+		err = c.Set(IRQ, false)
+	}
+	if err == nil {
+		err = c.Set(RESET, false)
+	}
+	for _, signal := range MODE_LINES {
+		if err == nil {
+			err = c.Set(signal, true)
+		}
+	}
+	return err
+}
+
+// String lists the signals driven and their lines, e.g. "RESET=DTR IRQ=!RTS"
+func (c *Control) String() string {
+
+	var wiring []string
+	for _, signal := range SIGNALS {
+		if pin, found := c.Pins[signal]; found {
+			line := pin.Line
+			if pin.Inverted {
+				line = "!" + line
+			}
+			wiring = append(wiring, signal+"="+line)
+		}
+	}
+	return strings.Join(wiring, " ")
+}
+
+// isSignal tells whether a name (upper case) is one of SIGNALS
+func isSignal(name string) bool {
+	for _, signal := range SIGNALS {
+		if name == signal {
+			return true
+		}
+	}
+	return false
+}
+
+// onOff names a signal state in error messages
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,151 @@
+package board
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sonikku2k/PROG05/bootloader"
+)
+
+// recorder is a Driver logging every line set, e.g. "DTR=true"
+type recorder struct {
+	log []string
+}
+
+func (r *recorder) SetLine(line string, level bool) error {
+	r.log = append(r.log, fmt.Sprintf("%s=%t", line, level))
+	return nil
+}
+
+func TestParsePins(t *testing.T) {
+	pins, problems := ParsePins(map[string]string{"reset": "!DTR", "IRQ": " rts "}, false)
+	if len(problems) != 0 {
+		t.Fatal(problems)
+	}
+	if pins[RESET] != (Pin{"DTR", true}) || pins[IRQ] != (Pin{"RTS", false}) {
+		t.Fatalf("pins %v", pins)
+	}
+
+	cases := []struct {
+		name    string
+		lines   map[string]string
+		gpio    bool
+		problem string
+	}{
+		{"unknown signal", map[string]string{"CLOCK": "DTR"}, false, "\"CLOCK\" is not a board signal"},
+		{"unknown modem line", map[string]string{RESET: "CTS"}, false, "RESET: \"CTS\" is not a modem line"},
+		{"line used twice", map[string]string{RESET: "DTR", IRQ: "!DTR"}, false, "RESET: line DTR already drives IRQ"},
+		{"GPIO output out of range", map[string]string{VPP: "8"}, true, "VPP: \"8\" is not a GPIO output (0 - 7)"},
+		{"modem line on a GPIO controller", map[string]string{VPP: "DTR"}, true, "\"DTR\" is not a GPIO output"},
+		{"signal given twice", map[string]string{"reset": "DTR", "RESET": "RTS"}, false, "RESET given twice"},
+	}
+	for _, c := range cases {
+		_, problems := ParsePins(c.lines, c.gpio)
+		if len(problems) != 1 || !strings.Contains(problems[0], c.problem) {
+			t.Errorf("%s: problems %q, want %q", c.name, problems, c.problem)
+		}
+	}
+}
+
+func TestEnterLoader(t *testing.T) {
+	pins, _ := ParsePins(map[string]string{RESET: "!DTR", IRQ: "RTS"}, false)
+	r := &recorder{}
+	c := &Control{Driver: r, Pins: pins, Pulse: time.Millisecond, Settle: time.Millisecond}
+	if !c.HandsFree() || c.Drives(VPP) {
+		t.Fatalf("%s: hands-free %t, drives VPP %t", c, c.HandsFree(), c.Drives(VPP))
+	}
+	if err := c.EnterLoader(); err != nil {
+		t.Fatal(err)
+	}
+	// RESET is inverted: DTR low holds the HC05 in reset
+	if got := strings.Join(r.log, " "); got != "DTR=false RTS=true DTR=true" {
+		t.Fatalf("lines set %s", got)
+	}
+
+	// Every signal driven, PD5-PD2 select the SCI loader
+	r = &recorder{}
+	c = Direct(r)
+	c.Pulse, c.Settle = 0, 0
+	if err := c.EnterLoader(); err != nil {
+		t.Fatal(err)
+	}
+	want := "VPP=false RESET=true IRQ=true PD5=true PD4=true PD3=true PD2=false RESET=false"
+	if got := strings.Join(r.log, " "); got != want {
+		t.Fatalf("lines set %s, want %s", got, want)
+	}
+
+	c = &Control{Driver: r, Pins: map[string]Pin{IRQ: {Line: "RTS"}}}
+	if err := c.EnterLoader(); err == nil {
+		t.Fatal("bootloader entered without RESET in the pin map")
+	}
+	var none *Control
+	if none.HandsFree() || none.Idle() != nil {
+		t.Fatal("a nil Control must drive nothing")
+	}
+}
+
+// controller is a GPIO controller answering each command with the reply given, its echo when reply is nil
+type controller struct {
+	rx      *bootloader.Receiver
+	written []byte
+	reply   []byte
+	mute    bool
+}
+
+func (c *controller) WriteByte(b byte) error {
+	c.written = append(c.written, b)
+	if b == '\n' && !c.mute {
+		if c.reply != nil {
+			c.rx.Put(c.reply)
+		} else {
+			c.rx.Put(c.written[len(c.written)-4:])
+		}
+	}
+	return nil
+}
+
+func (c *controller) ReadN(n int, timeout time.Duration) ([]byte, error) {
+	return c.rx.ReadN(n, timeout)
+}
+
+func (c *controller) Flush() {
+	c.rx.Flush()
+}
+
+func (c *controller) Close() error {
+	return nil
+}
+
+func TestGpio(t *testing.T) {
+	port := &controller{rx: bootloader.NewReceiver()}
+	g := Gpio{Port: port}
+	if err := g.SetLine("3", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.SetLine("0", false); err != nil {
+		t.Fatal(err)
+	}
+	if string(port.written) != "S31\nS00\n" {
+		t.Fatalf("commands sent %q", port.written)
+	}
+	if err := g.SetLine("8", true); err == nil {
+		t.Fatal("output 8 accepted")
+	}
+
+	// An echo that differs from the command
+	port.reply = []byte("S30\n")
+	err := g.SetLine("3", true)
+	if err == nil || !strings.Contains(err.Error(), `answered "S30\n" to "S31\n"`) {
+		t.Fatalf("error %v, want the wrong echo reported", err)
+	}
+
+	// No echo at all
+	port.reply, port.mute = nil, true
+	err = g.SetLine("1", true)
+	if !errors.Is(err, bootloader.ErrTimeout) {
+		t.Fatalf("error %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,74 @@
+package board
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/sonikku2k/PROG05/bootloader"
+)
+
+// ModemLines is implemented by serial ports whose DTR and RTS outputs can be set (bootloader.SerialPort)
+type ModemLines interface {
+	SetDTR(level bool) error
+	SetRTS(level bool) error
+}
+
+// Modem drives signals from the DTR and RTS lines of the serial port talking to the HC05
+type Modem struct {
+	Port ModemLines
+}
+
+// SetLine implements Driver, a line is "DTR" or "RTS"
+func (m Modem) SetLine(line string, level bool) error {
+	switch line {
+	case "DTR":
+		return m.Port.SetDTR(level)
+	case "RTS":
+		return m.Port.SetRTS(level)
+	}
+	return fmt.Errorf("%s is not a modem line", line)
+}
+
+// Serial settings and answer time of the GPIO controller
+const GPIO_BAUD_RATE = 9600
+const GPIO_TIMEOUT = 100 * time.Millisecond
+
+// Gpio drives signals from the outputs of a small controller on a second serial port (GPIO_BAUD_RATE, 8N1).
+// Each command is four ASCII characters, 'S', the output ('0'-'7'), the level ('0' or '1') and '\n', e.g. "S31\n"
+// sets output 3 high. The controller echoes the command once the output is set.
+type Gpio struct {
+	Port bootloader.Transport
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: SetLine
+// Function: Set an output of the GPIO controller and wait for its echo (implements Driver)
+// Parameters: Output number ("0"-"7"), level
+// Returns: error if the controller did not echo the command
+// -------------------------------------------------------------------------------------------------------------------
+func (g Gpio) SetLine(line string, level bool) error {
+
+	if len(line) != 1 || line[0] < '0' || line[0] >= '0'+GPIO_OUTPUTS {
+		return fmt.Errorf("%s is not a GPIO output", line)
+	}
+	command := []byte{'S', line[0], '0', '\n'}
+	if level {
+		command[2] = '1'
+	}
+	g.Port.Flush()
+	for _, b := range command {
+		err := g.Port.WriteByte(b)
+		if err != nil {
+			return err
+		}
+	}
+	echo, err := g.Port.ReadN(len(command), GPIO_TIMEOUT)
+	if err != nil {
+		return fmt.Errorf("GPIO controller did not answer: %w", err)
+	}
+	if !bytes.Equal(echo, command) {
+		return fmt.Errorf("GPIO controller answered %q to %q", echo, command)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Package bootloader talks to the mask ROM loader of the MC68HC705C8 over a serial port
+package bootloader
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sonikku2k/PROG05/hc05"
+	"go.bug.st/serial"
+)
+
+// Delay between two bytes sent to the loader, it has to store each byte before the next one arrives
+const BYTE_PACING = 5 * time.Millisecond
+
+// Largest value of the length byte, it counts itself
+const MAX_LENGTH = 255
+
+// UploadOptions tunes UploadToBootloader, the zero value uploads with BYTE_PACING and no callbacks
+type UploadOptions struct {
+	Pacing    time.Duration         // Delay between two bytes sent (BYTE_PACING when 0)
+	Handshake func() error          // Called before the target is reset, e.g. to have the operator enable the loader (may be nil)
+	Progress  func(sent, total int) // Called after every byte sent, the length byte included (may be nil)
+}
+
+// Transport is the byte link to the HC05 SCI, bytes sent by the target are kept until read by ReadN or dropped by Flush
+type Transport interface {
+	WriteByte(b byte) error
+	ReadN(n int, timeout time.Duration) ([]byte, error) // Returns a *TimeoutError with what did arrive on timeout
+	Flush()
+	Close() error
+}
+
+// Resetter is implemented by transports able to put the target back into bootloader mode by themselves,
+// UploadToBootloader calls it first so no one has to press RESET
+type Resetter interface {
+	ResetTarget() error
+}
+
+// BaudRateSetter is implemented by transports whose speed can be changed, the clock probe needs it
+type BaudRateSetter interface {
+	SetBaudRate(baudrate int) error
+}
+
+// SerialPort is the link to the HC05 SCI, bytes sent by the target are collected by a reception goroutine
+type SerialPort struct {
+	port serial.Port
+	rx   *Receiver // Main serial reception buffer
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Open
+// Function: Open the serial port connected to the HC05 and start the reception goroutine
+// Parameters: Port name (COMx, /dev/ttyUSBx...), baud rate
+// Returns: Pointer to the opened port, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func Open(name string, baudrate int) (*SerialPort, error) {
+
+	mode := &serial.Mode{
+		BaudRate: baudrate,
+		Parity:   serial.NoParity,
+		DataBits: 8,
+		StopBits: serial.OneStopBit,
+	}
+	port, err := serial.Open(name, mode)
+	if err != nil {
+		return nil, err
+	}
+	s := &SerialPort{
+		port: port,
+		rx:   NewReceiver(),
+	}
+	go s.serialRx()
+	return s, nil
+}
+
+// Close releases the serial port
+func (s *SerialPort) Close() error {
+	return s.port.Close()
+}
+
+// WriteByte transmits a single byte to the HC05
+func (s *SerialPort) WriteByte(b byte) error {
+	_, err := s.port.Write([]byte{b})
+	return err
+}
+
+// SetBaudRate changes the speed of the serial port, the data format stays 8N1
+func (s *SerialPort) SetBaudRate(baudrate int) error {
+	return s.port.SetMode(&serial.Mode{
+		BaudRate: baudrate,
+		Parity:   serial.NoParity,
+		DataBits: 8,
+		StopBits: serial.OneStopBit,
+	})
+}
+
+// SetDTR sets the DTR output of the serial port, a board may have a signal wired to it (see package board)
+func (s *SerialPort) SetDTR(level bool) error {
+	return s.port.SetDTR(level)
+}
+
+// SetRTS sets the RTS output of the serial port
+func (s *SerialPort) SetRTS(level bool) error {
+	return s.port.SetRTS(level)
+}
+
+// ReadN waits for n bytes from the HC05
+func (s *SerialPort) ReadN(n int, timeout time.Duration) ([]byte, error) {
+	return s.rx.ReadN(n, timeout)
+}
+
+// Flush empties the reception buffer
+func (s *SerialPort) Flush() {
+	s.rx.Flush()
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CheckUpload
+// Function: Check code can be uploaded: the loader stores it from $0051 up to the top of RAM ($00FF), and its length
+// plus the length byte must fit the length byte
+// Parameters: Address of the first byte of the code, code
+// Returns: error if the code cannot be uploaded
+// -------------------------------------------------------------------------------------------------------------------
+func CheckUpload(start uint16, code []byte) error {
+
+	if len(code) == 0 {
+		return errors.New("nothing to upload, the RAM image is empty")
+	}
+	if start != hc05.LOADER_START {
+		return fmt.Errorf("code starts at %04X, the bootloader stores it from %04X", start, hc05.LOADER_START)
+	}
+	if len(code)+1 > MAX_LENGTH {
+		return fmt.Errorf("%d bytes do not fit the bootloader length byte (%d at most)", len(code), MAX_LENGTH-1)
+	}
+	end := int(start) + len(code) - 1
+	if end > hc05.RAM_END {
+		return fmt.Errorf("code ends at %04X, beyond the top of RAM (%04X)", end, hc05.RAM_END)
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: UploadToBootloader
+// Function: Send code to the HC05 bootloader (length byte first, it counts itself, then the code from $0051), the
+// upload stops at the first byte that cannot be written
+// Parameters: Transport, address of the first byte of the code, code, options (pacing and callbacks)
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func UploadToBootloader(s Transport, start uint16, code []byte, options UploadOptions) error {
+
+	err := CheckUpload(start, code)
+	if err != nil {
+		return err
+	}
+	pacing := options.Pacing
+	if pacing == 0 {
+		pacing = BYTE_PACING
+	}
+	if options.Handshake != nil {
+		err = options.Handshake()
+		if err != nil {
+			return err
+		}
+	}
+	if r, ok := s.(Resetter); ok {
+		err = r.ResetTarget()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Send length to the bootloader, then the code
+	total := len(code) + 1
+	data := append([]byte{byte(total)}, code...)
+	for n, b := range data {
+		if n > 0 {
+			time.Sleep(pacing)
+		}
+		err = s.WriteByte(b)
+		if err != nil {
+			return fmt.Errorf("upload stopped at byte %d of %d: %w", n+1, total, err)
+		}
+		if options.Progress != nil {
+			options.Progress(n+1, total)
+		}
+	}
+	return nil
+}
+
+// Serial Port Reception goroutine
+// This thread will sit and block on the serial port receive callback in the OS
+// If a byte is received, it is stored in the ring buffer (which does its own locking)
+// ----------------------------------------------------------------------------
+func (s *SerialPort) serialRx() {
+
+	tmpbuf := make([]byte, 100)
+	for {
+		n, err := s.port.Read(tmpbuf)
+		if err != nil {
+			return // Port was closed
+		}
+		if n > 0 {
+			s.rx.Put(tmpbuf[:n])
+		}
+	}
+}
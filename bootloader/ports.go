@@ -0,0 +1,156 @@
+package bootloader
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// Port name asking PROG05 to find the USB-to-serial adapter by itself
+const AUTO_PORT = "auto"
+
+// Adapter is a USB-to-serial converter known to work with the HC05 bootloader
+type Adapter struct {
+	VID  string
+	PID  string
+	Name string
+}
+
+// Adapters picked by the auto port, the converters the README recommends (CP2102, FT232 series)
+var KNOWN_ADAPTERS = []Adapter{
+	{VID: "10C4", PID: "EA60", Name: "Silicon Labs CP2102/CP2104"},
+	{VID: "0403", PID: "6001", Name: "FTDI FT232R"},
+	{VID: "0403", PID: "6014", Name: "FTDI FT232H"},
+	{VID: "0403", PID: "6015", Name: "FTDI FT231X/FT230X"},
+}
+
+// PortInfo describes a serial port of the computer
+type PortInfo struct {
+	Name         string
+	USB          bool
+	VID          string
+	PID          string
+	SerialNumber string
+	Product      string // Description given by the operating system, may be empty
+	Adapter      string // Name of the known adapter, empty for any other port
+}
+
+// String writes the port on one line: name, USB IDs, serial number and product
+func (p PortInfo) String() string {
+	if !p.USB {
+		return p.Name
+	}
+	text := fmt.Sprintf("%s  USB %s:%s", p.Name, p.VID, p.PID)
+	if p.SerialNumber != "" {
+		text += "  S/N " + p.SerialNumber
+	}
+	if p.Product != "" {
+		text += "  " + p.Product
+	}
+	if p.Adapter != "" {
+		text += "  (" + p.Adapter + ")"
+	}
+	return text
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ListPorts
+// Function: List the serial ports of the computer with the USB details of each adapter, only the names are given where
+// the operating system does not report the details
+// Returns: Ports sorted by name, error if they cannot be listed
+// -------------------------------------------------------------------------------------------------------------------
+func ListPorts() ([]PortInfo, error) {
+
+	var ports []PortInfo
+	details, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		names, err := serial.GetPortsList()
+		if err != nil {
+			return nil, fmt.Errorf("cannot list the serial ports: %w", err)
+		}
+		for _, name := range names {
+			ports = append(ports, PortInfo{Name: name})
+		}
+	}
+	for _, d := range details {
+		port := PortInfo{
+			Name:         d.Name,
+			USB:          d.IsUSB,
+			VID:          strings.ToUpper(d.VID),
+			PID:          strings.ToUpper(d.PID),
+			SerialNumber: d.SerialNumber,
+			Product:      d.Product,
+		}
+		if port.USB {
+			port.Adapter = AdapterName(port.VID, port.PID)
+		}
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+	return ports, nil
+}
+
+// AdapterName returns the name of the known adapter with these USB IDs, empty when it is not one of KNOWN_ADAPTERS
+func AdapterName(vid string, pid string) string {
+	for _, adapter := range KNOWN_ADAPTERS {
+		if strings.EqualFold(adapter.VID, vid) && strings.EqualFold(adapter.PID, pid) {
+			return adapter.Name
+		}
+	}
+	return ""
+}
+
+// KnownAdapters keeps the ports that are one of KNOWN_ADAPTERS
+func KnownAdapters(ports []PortInfo) []PortInfo {
+	var adapters []PortInfo
+	for _, port := range ports {
+		if port.Adapter != "" {
+			adapters = append(adapters, port)
+		}
+	}
+	return adapters
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: PickAdapter
+// Function: Pick the port of the USB-to-serial adapter among the ports of the computer: the only known adapter, or
+// the one chosen when there are several
+// Parameters: Ports (see ListPorts), function picking one of several adapters (nil when there is no one to ask,
+// several adapters are then an error)
+// Returns: Port name, error if no adapter or more than one was found and none was picked
+// -------------------------------------------------------------------------------------------------------------------
+func PickAdapter(ports []PortInfo, choose func(adapters []PortInfo) (int, error)) (string, error) {
+
+	adapters := KnownAdapters(ports)
+	switch {
+	case len(adapters) == 1:
+		return adapters[0].Name, nil
+	case len(adapters) == 0:
+		var names []string
+		for _, port := range ports {
+			names = append(names, port.Name)
+		}
+		if len(names) == 0 {
+			return "", errors.New("no USB-to-serial adapter found, is it plugged in?")
+		}
+		return "", fmt.Errorf("no CP2102 or FT232 adapter found (serial ports: %s), give the port by name", strings.Join(names, ", "))
+	case choose == nil:
+		var names []string
+		for _, adapter := range adapters {
+			names = append(names, adapter.Name)
+		}
+		return "", fmt.Errorf("%d adapters found (%s), give the port by name", len(adapters), strings.Join(names, ", "))
+	}
+	n, err := choose(adapters)
+	if err != nil {
+		return "", err
+	}
+	if n < 0 || n >= len(adapters) {
+		return "", fmt.Errorf("adapter %d picked, %d were found", n+1, len(adapters))
+	}
+	return adapters[n].Name, nil
+}
@@ -0,0 +1,84 @@
+package bootloader
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAdapterName(t *testing.T) {
+	cases := []struct {
+		vid, pid string
+		want     string
+	}{
+		{"10C4", "EA60", "Silicon Labs CP2102/CP2104"},
+		{"10c4", "ea60", "Silicon Labs CP2102/CP2104"}, // Some systems report the IDs in lower case
+		{"0403", "6001", "FTDI FT232R"},
+		{"0403", "6015", "FTDI FT231X/FT230X"},
+		{"0403", "6010", ""}, // FT2232, not one of the known adapters
+		{"067B", "2303", ""}, // Prolific PL2303
+	}
+	for _, c := range cases {
+		if name := AdapterName(c.vid, c.pid); name != c.want {
+			t.Errorf("%s:%s: %q, want %q", c.vid, c.pid, name, c.want)
+		}
+	}
+}
+
+// port describes a serial port as ListPorts does
+func port(name string, vid string, pid string) PortInfo {
+	if vid == "" {
+		return PortInfo{Name: name}
+	}
+	return PortInfo{Name: name, USB: true, VID: vid, PID: pid, Adapter: AdapterName(vid, pid)}
+}
+
+func TestPickAdapter(t *testing.T) {
+	cp2102 := port("COM4", "10C4", "EA60")
+	ft232 := port("COM7", "0403", "6001")
+	pl2303 := port("COM5", "067B", "2303")
+	builtin := port("COM1", "", "")
+
+	cases := []struct {
+		name    string
+		ports   []PortInfo
+		choice  int // Adapter picked by the operator, -1 when there is no one to ask
+		want    string
+		message string
+	}{
+		{"one CP2102", []PortInfo{builtin, cp2102, pl2303}, -1, "COM4", ""},
+		{"one FT232", []PortInfo{ft232}, -1, "COM7", ""},
+		{"several, the operator picks", []PortInfo{cp2102, builtin, ft232}, 1, "COM7", ""},
+		{"several, no one to ask", []PortInfo{cp2102, ft232}, -1, "", "2 adapters found (COM4, COM7), give the port by name"},
+		{"unknown adapters only", []PortInfo{builtin, pl2303}, -1, "", "no CP2102 or FT232 adapter found (serial ports: COM1, COM5)"},
+		{"no port at all", nil, -1, "", "no USB-to-serial adapter found"},
+	}
+	for _, c := range cases {
+		var choose func(adapters []PortInfo) (int, error)
+		if c.choice >= 0 {
+			choose = func(adapters []PortInfo) (int, error) {
+				if len(adapters) != 2 || adapters[0].Name != "COM4" {
+					t.Errorf("%s: asked to choose among %v", c.name, adapters)
+				}
+				return c.choice, nil
+			}
+		}
+		name, err := PickAdapter(c.ports, choose)
+		if c.message != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), c.message) {
+				t.Errorf("%s: %q, error %v, want %q", c.name, name, err, c.message)
+			}
+			continue
+		}
+		if err != nil || name != c.want {
+			t.Errorf("%s: %q, %v, want %q", c.name, name, err, c.want)
+		}
+	}
+
+	// The console closed while asking
+	closed := errors.New("closed")
+	_, err := PickAdapter([]PortInfo{cp2102, ft232}, func([]PortInfo) (int, error) { return 0, closed })
+	if !errors.Is(err, closed) {
+		t.Errorf("error %v, want the error of the choice", err)
+	}
+}
@@ -0,0 +1,132 @@
+package bootloader
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Size of the reception ring buffer, bytes arriving while it is full are dropped
+const RX_BUFFER_SIZE = 4096
+
+// ErrTimeout matches every TimeoutError (errors.Is)
+var ErrTimeout = errors.New("response timeout")
+
+// TimeoutError is returned by ReadN when fewer bytes than asked for arrived in time
+type TimeoutError struct {
+	Wanted   int
+	Received int
+	Timeout  time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("response timeout (%d of %d bytes received in %s)", e.Received, e.Wanted, e.Timeout)
+}
+
+// Is makes errors.Is(err, ErrTimeout) true for a TimeoutError
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// Receiver is a ring buffer filled by a reception goroutine and emptied by ReadN, safe for concurrent use
+type Receiver struct {
+	mu      sync.Mutex
+	buffer  [RX_BUFFER_SIZE]byte
+	head    int           // Index of the oldest byte
+	count   int           // Bytes waiting
+	dropped int           // Bytes lost because the buffer was full
+	arrived chan struct{} // Signalled when bytes are stored, holds one pending signal at most
+}
+
+// NewReceiver creates an empty reception buffer
+func NewReceiver() *Receiver {
+	return &Receiver{arrived: make(chan struct{}, 1)}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Put
+// Function: Store received bytes and wake up a waiting ReadN
+// Parameters: Bytes received
+// -------------------------------------------------------------------------------------------------------------------
+func (r *Receiver) Put(data []byte) {
+
+	r.mu.Lock()
+	for _, b := range data {
+		if r.count == RX_BUFFER_SIZE {
+			r.dropped++
+			continue
+		}
+		r.buffer[(r.head+r.count)%RX_BUFFER_SIZE] = b
+		r.count++
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.arrived <- struct{}{}:
+	default:
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ReadN
+// Function: Wait until n bytes have been received and remove them from the buffer
+// Parameters: Number of bytes, longest time to wait for all of them
+// Returns: Bytes, or on timeout the bytes that did arrive with a *TimeoutError
+// -------------------------------------------------------------------------------------------------------------------
+func (r *Receiver) ReadN(n int, timeout time.Duration) ([]byte, error) {
+
+	if n > RX_BUFFER_SIZE {
+		return nil, fmt.Errorf("cannot wait for %d bytes, the reception buffer holds %d", n, RX_BUFFER_SIZE)
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		r.mu.Lock()
+		if r.count >= n {
+			data := r.take(n)
+			r.mu.Unlock()
+			return data, nil
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.arrived:
+		case <-deadline.C:
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			// Bytes may have arrived since the buffer was last looked at
+			if r.count >= n {
+				return r.take(n), nil
+			}
+			data := r.take(r.count)
+			return data, &TimeoutError{Wanted: n, Received: len(data), Timeout: timeout}
+		}
+	}
+}
+
+// Flush discards every byte received so far
+func (r *Receiver) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.head = 0
+	r.count = 0
+}
+
+// Dropped returns the number of bytes lost since the receiver was created because the buffer was full
+func (r *Receiver) Dropped() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dropped
+}
+
+// take removes n bytes from the buffer, the lock must be held
+func (r *Receiver) take(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = r.buffer[(r.head+i)%RX_BUFFER_SIZE]
+	}
+	r.head = (r.head + n) % RX_BUFFER_SIZE
+	r.count -= n
+	return data
+}
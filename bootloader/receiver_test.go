@@ -0,0 +1,161 @@
+package bootloader
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sequence returns n bytes counting up from first
+func sequence(first int, n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(first + i)
+	}
+	return data
+}
+
+// The reception goroutine fills the buffer while the reader empties it, run with go test -race
+func TestReceiverConcurrent(t *testing.T) {
+	r := NewReceiver()
+	const TOTAL = 3000 // Less than RX_BUFFER_SIZE, nothing is dropped however the goroutines are scheduled
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for sent := 0; sent < TOTAL; sent += 3 {
+			r.Put(sequence(sent, 3))
+		}
+	}()
+	for received := 0; received < TOTAL; {
+		data, err := r.ReadN(7, time.Second)
+		if err != nil && !errors.Is(err, ErrTimeout) {
+			t.Fatal(err)
+		}
+		for _, b := range data {
+			if b != byte(received) {
+				t.Fatalf("byte %d is %02X, want %02X", received, b, byte(received))
+			}
+			received++
+		}
+		if err != nil && received < TOTAL {
+			t.Fatalf("%v after %d bytes", err, received)
+		}
+	}
+	wg.Wait()
+	if r.Dropped() != 0 {
+		t.Fatalf("%d bytes dropped", r.Dropped())
+	}
+}
+
+// Put, ReadN and Flush from several goroutines at once must leave the buffer consistent
+func TestReceiverFlushWhileReading(t *testing.T) {
+	r := NewReceiver()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				r.Put(sequence(0, 100))
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				r.Flush()
+			}
+		}
+	}()
+	for n := 0; n < 200; n++ {
+		data, err := r.ReadN(50, 10*time.Millisecond)
+		if err == nil && len(data) != 50 || len(data) > 50 {
+			t.Fatalf("%d bytes returned for 50, error %v", len(data), err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	r.Flush()
+	if data, err := r.ReadN(1, time.Millisecond); len(data) != 0 || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("%d bytes left after Flush, error %v", len(data), err)
+	}
+}
+
+func TestReceiverTimeout(t *testing.T) {
+	r := NewReceiver()
+	r.Put([]byte{1, 2})
+
+	began := time.Now()
+	data, err := r.ReadN(5, 20*time.Millisecond)
+	if elapsed := time.Since(began); elapsed < 20*time.Millisecond {
+		t.Fatalf("ReadN returned after %s, before its timeout", elapsed)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("error %v does not match ErrTimeout", err)
+	}
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) || timeout.Wanted != 5 || timeout.Received != 2 || timeout.Timeout != 20*time.Millisecond {
+		t.Fatalf("error %#v, want a *TimeoutError for 2 of 5 bytes in 20ms", err)
+	}
+	if string(data) != "\x01\x02" {
+		t.Fatalf("partial data %v, want [1 2]", data)
+	}
+
+	// Bytes arriving while ReadN waits end the wait early
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		r.Put([]byte{3})
+		r.Put([]byte{4})
+	}()
+	data, err = r.ReadN(2, time.Second)
+	if err != nil || string(data) != "\x03\x04" {
+		t.Fatalf("read %v, error %v, want [3 4]", data, err)
+	}
+}
+
+func TestReceiverWrap(t *testing.T) {
+	r := NewReceiver()
+
+	// Move the oldest byte near the end of the ring, then store across its end
+	r.Put(sequence(0, 4000))
+	if _, err := r.ReadN(4000, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	r.Put(sequence(10, 200))
+	data, err := r.ReadN(200, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(sequence(10, 200)) {
+		t.Fatal("bytes stored across the end of the ring came back out of order")
+	}
+
+	// A full ring drops what comes next
+	r.Put(sequence(0, RX_BUFFER_SIZE+3))
+	if r.Dropped() != 3 {
+		t.Fatalf("%d bytes dropped, want 3", r.Dropped())
+	}
+	data, err = r.ReadN(RX_BUFFER_SIZE, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(sequence(0, RX_BUFFER_SIZE)) {
+		t.Fatal("a full ring came back out of order")
+	}
+
+	if _, err := r.ReadN(RX_BUFFER_SIZE+1, time.Millisecond); err == nil || errors.Is(err, ErrTimeout) {
+		t.Fatalf("error %v, want a refusal to wait for more than the buffer holds", err)
+	}
+}
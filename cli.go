@@ -0,0 +1,525 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sonikku2k/PROG05/applet"
+	"github.com/sonikku2k/PROG05/board"
+	"github.com/sonikku2k/PROG05/bootloader"
+	"github.com/sonikku2k/PROG05/config"
+	"github.com/sonikku2k/PROG05/hc05"
+	"github.com/sonikku2k/PROG05/programmer"
+)
+
+// Exit codes of the non-interactive mode
+const EXIT_OK = 0      // Command completed
+const EXIT_FAILED = 1  // Target answered but the check failed (no banner, verify mismatch, not blank, program failure)
+const EXIT_USAGE = 2   // Bad command line
+const EXIT_ERROR = 3   // Configuration, file or communication error
+const EXIT_SECURED = 4 // The HC05 is secured, its memory cannot be read out
+
+// Options of the non-interactive mode, given as flags anywhere on the command line
+type CommandLineOptions struct {
+	Config  string
+	Profile string
+	Board   string
+	Port    string
+	Clock   string
+	Baud    int
+	Pacing  int
+	Wait    time.Duration
+	Verify  bool
+	Out     string
+	Format  string
+	Regions string
+	Json    bool
+	Base    string
+	Secure  bool
+	Options string
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CommandLineUsage
+// Function: Print the subcommands and flags of the non-interactive mode
+// Parameters: Flag set holding the flags
+// -------------------------------------------------------------------------------------------------------------------
+func CommandLineUsage(flags *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "Usage: prog05 [command] [arguments] [flags]   (no command starts the interactive mode, which takes")
+	fmt.Fprintln(os.Stderr, "       --config, --profile, --board, --port, --clock, --baud and --pacing)")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  test                      Upload the test applet and check the HC05 answers")
+	fmt.Fprintln(os.Stderr, "  read ADDR [ADDR...]       Read HC05 addresses (hexadecimal), one 'ADDR DATA' line each")
+	fmt.Fprintln(os.Stderr, "  write ADDR DATA [...]     Write HC05 addresses (hexadecimal address/data pairs)")
+	fmt.Fprintln(os.Stderr, "  dump [--out FILE]         Read the entire HC05 address space, to the console or a file")
+	fmt.Fprintln(os.Stderr, "  blankcheck                Confirm every EPROM/OTP area is erased")
+	fmt.Fprintln(os.Stderr, "  options                   Read and decode the OPTION and MASK OPTION registers")
+	fmt.Fprintln(os.Stderr, "  program FILE [--verify]   Program the EPROM/OTP from an S-record, Intel HEX, binary or .asm file")
+	fmt.Fprintln(os.Stderr, "  verify FILE               Compare an S-record, Intel HEX, binary or .asm file against the HC05")
+	fmt.Fprintln(os.Stderr, "  loadram FILE              Upload a program into the HC05 RAM and run it")
+	fmt.Fprintln(os.Stderr, "  asm FILE                  Assemble an HC05 source file into an S-record file (no target needed)")
+	fmt.Fprintln(os.Stderr, "  ports                     List the serial ports, with the USB details of each adapter (no target needed)")
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flags.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "Exit status: 0 success, 1 check failed, 2 bad command line, 3 configuration/file/communication error,")
+	fmt.Fprintln(os.Stderr, "             4 HC05 secured")
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: AddConfigFlags
+// Function: Define the flags overriding the configuration file, taken by both the command line and the interactive mode
+// Parameters: Flag set, options receiving the values
+// -------------------------------------------------------------------------------------------------------------------
+func AddConfigFlags(flags *flag.FlagSet, options *CommandLineOptions) {
+	flags.StringVar(&options.Config, "config", "", "configuration file (default: $PROG05_CONFIG, ./config.json, the user configuration directory, then next to prog05)")
+	flags.StringVar(&options.Profile, "profile", "", "board profile of the configuration file (default: $PROG05_PROFILE, then the \"profile\" entry)")
+	flags.StringVar(&options.Board, "board", "", "board: MC68HC05PGMR, \"MIDON PROG05\" or custom (overrides the configuration file)")
+	flags.StringVar(&options.Port, "port", "", "serial port, auto (the CP2102/FT232 adapter plugged in), SIM or EMU (overrides the configuration file)")
+	flags.StringVar(&options.Clock, "clock", "", "target clock: crystal frequency (2MHz, 4MHz, 3.6864MHz...) or auto to probe it (overrides the configuration file)")
+	flags.IntVar(&options.Baud, "baud", 0, "serial port rate, 0 for the rate worked out from the clock (overrides the configuration file)")
+	flags.IntVar(&options.Pacing, "pacing", 0, "mS between two bytes sent to the bootloader, 0 for the default (overrides the configuration file)")
+}
+
+// Overrides returns the settings given by the configuration flags. --baud and --pacing count only when given, so an
+// explicit 0 replaces the value of the file or the environment.
+func (o *CommandLineOptions) Overrides(flags *flag.FlagSet) config.Profile {
+
+	overrides := config.Profile{Board: o.Board, Port: o.Port, Targetclock: o.Clock}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "baud":
+			overrides.Baud = &o.Baud
+		case "pacing":
+			overrides.Pacing = &o.Pacing
+		}
+	})
+	return overrides
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: InteractiveFlags
+// Function: Read arguments made of configuration flags only, the interactive mode then starts with them
+// Parameters: Command line arguments (program name excluded)
+// Returns: Options, settings given by the flags, false if the arguments hold a command or any other flag
+// -------------------------------------------------------------------------------------------------------------------
+func InteractiveFlags(args []string) (CommandLineOptions, config.Profile, bool) {
+
+	var options CommandLineOptions
+	flags := flag.NewFlagSet("prog05", flag.ContinueOnError)
+	flags.SetOutput(io.Discard) // RunCommandLine reports what is wrong with the arguments
+	AddConfigFlags(flags, &options)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return options, config.Profile{}, false
+	}
+	return options, options.Overrides(flags), true
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ParseInterspersed
+// Function: Parse flags placed before, between or after the positional arguments
+// Parameters: Flag set, arguments
+// Returns: Positional arguments, error if a flag is not valid
+// -------------------------------------------------------------------------------------------------------------------
+func ParseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+
+	var positional []string
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ParseHex
+// Function: Convert a hexadecimal argument ($ prefix allowed)
+// Parameters: Argument, size of the value in bits
+// Returns: Value, error if the argument is not valid
+// -------------------------------------------------------------------------------------------------------------------
+func ParseHex(argument string, bits int) (uint16, error) {
+
+	value, err := strconv.ParseUint(strings.TrimPrefix(argument, "$"), 16, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hexadecimal value %s", argument)
+	}
+	return uint16(value), nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: StartApplet
+// Function: Load an applet and upload it to the HC05 (the handshake gives the operator --wait to enable the loader)
+// Parameters: Applet file name
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func StartApplet(name string) error {
+
+	err := prog.LoadApplet(name)
+	if err != nil {
+		return err
+	}
+	return prog.UploadRamBuffer("Initialising target")
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: RunCommandLine
+// Function: Run a single command given on the command line, without prompts
+// Parameters: Command line arguments (program name excluded)
+// Returns: Exit code
+// -------------------------------------------------------------------------------------------------------------------
+func RunCommandLine(args []string) int {
+
+	var options CommandLineOptions
+	flags := flag.NewFlagSet("prog05", flag.ContinueOnError)
+	AddConfigFlags(flags, &options)
+	flags.DurationVar(&options.Wait, "wait", 0, "time given to the operator to enable the loader (or Vpp) before each step")
+	flags.BoolVar(&options.Verify, "verify", false, "program: verify the EPROM/OTP after programming")
+	flags.StringVar(&options.Out, "out", "", "dump: write the HC05 memory to this file (.bin, .s19 or .hex)")
+	flags.StringVar(&options.Format, "format", "", "file format s19, hex, bin (or asm for input), default: from the file")
+	flags.StringVar(&options.Base, "base", "", "program/verify/loadram: address of the first byte of a .bin file (hexadecimal)")
+	flags.StringVar(&options.Regions, "regions", "", "dump: address ranges to save, e.g. 0020-004F,0100-1FFF (default: all)")
+	flags.BoolVar(&options.Json, "json", false, "dump: also write the option registers to a JSON file next to --out")
+	flags.BoolVar(&options.Secure, "secure", false, "program: allow the file to set the security bit (SEC) of the OPTION register")
+	flags.StringVar(&options.Options, "options", "", "program: option register bits to program over the file, e.g. RAM1=1,NCOPE=0")
+	flags.Usage = func() { CommandLineUsage(flags) }
+
+	positional, err := ParseInterspersed(flags, args)
+	if err == flag.ErrHelp {
+		return EXIT_OK
+	}
+	if err != nil {
+		return EXIT_USAGE
+	}
+	if len(positional) == 0 {
+		CommandLineUsage(flags)
+		return EXIT_USAGE
+	}
+	command := strings.ToLower(positional[0])
+	operands := positional[1:]
+
+	// The command line is checked before the target is touched
+	var arguments = map[string][2]int{ // Minimum and maximum number of operands
+		"test": {0, 0}, "read": {1, 1 << 16}, "write": {2, 1 << 16}, "dump": {0, 0}, "blankcheck": {0, 0},
+		"options": {0, 0}, "program": {1, 1}, "verify": {1, 1}, "loadram": {1, 1}, "asm": {1, 1}, "ports": {0, 0}, "help": {0, 0},
+	}
+	limits, ok := arguments[command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %s\r\n", positional[0])
+		CommandLineUsage(flags)
+		return EXIT_USAGE
+	}
+	if len(operands) < limits[0] || len(operands) > limits[1] || command == "write" && len(operands)%2 != 0 {
+		fmt.Fprintf(os.Stderr, "Wrong number of arguments for %s\r\n", command)
+		CommandLineUsage(flags)
+		return EXIT_USAGE
+	}
+	var addresses []uint16
+	if command == "read" || command == "write" {
+		for n, operand := range operands {
+			bits := 16
+			if command == "write" && n%2 == 1 {
+				bits = 8
+			}
+			value, err := ParseHex(operand, bits)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				return EXIT_USAGE
+			}
+			addresses = append(addresses, value)
+		}
+	}
+
+	var assignments []string
+	if options.Options != "" {
+		assignments = strings.Split(options.Options, ",")
+		if _, err := hc05.ComposeOptions(make(map[uint16]byte), assignments, 0); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_USAGE
+		}
+	}
+
+	var format string
+	var spans []programmer.Span
+	if command == "dump" && options.Out != "" {
+		format, spans, err = ExportOptions(options.Out, options.Format, options.Regions)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_USAGE
+		}
+	}
+
+	// Commands that do not need a target
+	switch command {
+	case "help":
+		CommandLineUsage(flags)
+		return EXIT_OK
+	case "asm":
+		if err := AssembleSource(operands[0]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		return EXIT_OK
+	case "ports":
+		if err := PrintPorts(os.Stdout, ""); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		return EXIT_OK
+	}
+
+	// Configuration file, then the flags
+	workingset, err := config.Load(options.Config, options.Profile, options.Overrides(flags))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Configuration error:", err)
+		return EXIT_ERROR
+	}
+	if strings.EqualFold(workingset.Port, bootloader.AUTO_PORT) {
+		// No one to ask when several adapters are plugged in
+		workingset.Port, err = FindAdapter(nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error finding the serial port:", err)
+			return EXIT_ERROR
+		}
+		fmt.Fprintf(os.Stderr, "Using adapter on %s\r\n", workingset.Port)
+	}
+	clock, _ := workingset.Clock()
+	port, err := OpenTarget(workingset, clock)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening serial port:", err)
+		fmt.Fprintln(os.Stderr, "Serial ports found:")
+		PrintPorts(os.Stderr, "")
+		return EXIT_ERROR
+	}
+	portname = workingset.Port
+	prog = programmer.New(port, workingset.Erased)
+	prog.Out = os.Stderr // Progress goes to stderr, stdout only carries results
+	prog.Board, err = OpenBoard(workingset, port)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error setting up the board signals:", err)
+		return EXIT_ERROR
+	}
+	prog.UploadPacing = time.Duration(workingset.Pacing) * time.Millisecond
+	if options.Wait > 0 {
+		prog.Handshake = func() error {
+			fmt.Fprintf(os.Stderr, "Enable the loader and release reset, upload starts in %s\r\n", options.Wait)
+			time.Sleep(options.Wait)
+			return nil
+		}
+	}
+	if clock != 0 {
+		// The port already runs at this rate, only the clock is recorded
+		prog.Clock = clock
+		prog.BaudRate = workingset.BaudRate(clock)
+	} else if err := ProbeTargetClock(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return EXIT_ERROR
+	}
+	for name, path := range workingset.Applets {
+		prog.AppletFiles[name] = path
+	}
+	hc05.ComposeOptions(prog.Options, assignments, prog.Erased)
+	defer prog.Port.Close()
+	defer prog.Board.Idle()
+	defer ReportViolations(os.Stderr)
+
+	switch command {
+	case "test":
+		if err := StartApplet(applet.GOTEST); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		if !prog.TestTarget() {
+			fmt.Println("Target test [FAILED]")
+			return EXIT_FAILED
+		}
+		fmt.Println("Target test [OK]")
+
+	case "read":
+		if err := StartApplet(applet.MEMREAD); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		for _, address := range addresses {
+			data, err := prog.ReadByteFromMCU(address)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading %04X: %v\r\n", address, err)
+				return EXIT_ERROR
+			}
+			fmt.Printf("%04X %02X\n", address, data)
+		}
+
+	case "write":
+		if err := StartApplet(applet.MEMWRITE); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		for n := 0; n < len(addresses); n += 2 {
+			err := prog.WriteByteToMCU(addresses[n], byte(addresses[n+1]))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing %04X: %v\r\n", addresses[n], err)
+				return EXIT_ERROR
+			}
+		}
+
+	case "dump":
+		if err := StartApplet(applet.MEMBLOCK); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		if err := prog.DumpMCU(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return ErrorExitCode(err)
+		}
+		fmt.Fprintf(os.Stderr, "Read %s\r\n", prog.Transfer)
+		if options.Out == "" {
+			DumpMemory(prog.McuDump, len(prog.McuDump), 0)
+			break
+		}
+		err := prog.ExportDump(options.Out, format, spans, options.Json)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		fmt.Fprintf(os.Stderr, "HC05 memory written to %s\r\n", options.Out)
+
+	case "options":
+		if err := StartApplet(applet.MEMBLOCK); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		registers, err := prog.ReadOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		PrintOptions(registers)
+
+	case "blankcheck":
+		if err := StartApplet(applet.MEMBLOCK); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		spans, err := prog.BlankCheck()
+		for _, span := range spans {
+			fmt.Printf("Not blank: $%04X-$%04X\n", span.Start, span.End)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		fmt.Fprintf(os.Stderr, "Read %s\r\n", prog.Transfer)
+		if len(spans) != 0 {
+			fmt.Println("Blank check [FAILED]")
+			return EXIT_FAILED
+		}
+		fmt.Println("Blank check [OK]")
+
+	case "verify", "program":
+		prog.Images.ClearPromImages(prog.Erased)
+		if err := LoadUserFile(operands[0], options.Format, options.Base, programmer.EPROM_ALL); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		if command == "program" {
+			prog.ApplyOptions()
+		}
+		if command == "program" && prog.LoadsSecurityBit() && !options.Secure {
+			fmt.Fprintf(os.Stderr, "Error: %s sets the security bit of the OPTION register, add --secure to program it\r\n", operands[0])
+			return EXIT_USAGE
+		}
+		if command == "program" {
+			if err := StartApplet(applet.MEMPROG); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				return EXIT_ERROR
+			}
+			if prog.Board.Drives(board.VPP) {
+				if err := prog.Board.SetVpp(true); err != nil {
+					fmt.Fprintln(os.Stderr, "Error:", err)
+					return EXIT_ERROR
+				}
+			} else if options.Wait > 0 {
+				fmt.Fprintf(os.Stderr, "Switch Vpp ON, programming starts in %s\r\n", options.Wait)
+				time.Sleep(options.Wait)
+			}
+			programmed, failures, err := prog.ProgramPromImages()
+			if prog.Board.Drives(board.VPP) {
+				if err := prog.Board.SetVpp(false); err != nil {
+					fmt.Fprintln(os.Stderr, "Error:", err)
+					return EXIT_ERROR
+				}
+			}
+			for _, f := range failures {
+				fmt.Printf("Program failure at %04X: wrote %02X read %02X\n", f.Address, f.Expected, f.Actual)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				return EXIT_ERROR
+			}
+			fmt.Printf("%d bytes programmed, %d failures\n", programmed, len(failures))
+			if len(failures) != 0 {
+				return EXIT_FAILED
+			}
+			if !options.Verify {
+				break
+			}
+			if prog.LoadsSecurityBit() {
+				fmt.Println("Verify skipped - the HC05 is now secured and cannot be read back")
+				break
+			}
+			if options.Wait > 0 && !prog.Board.Drives(board.VPP) {
+				fmt.Fprintln(os.Stderr, "Switch Vpp OFF and hold the target in RESET for verification")
+			}
+		}
+		if err := StartApplet(applet.MEMBLOCK); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		failures, err := prog.VerifyPromImages()
+		for _, f := range failures {
+			fmt.Printf("Mismatch at %04X: expected %02X actual %02X\n", f.Address, f.Expected, f.Actual)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return ErrorExitCode(err)
+		}
+		fmt.Fprintf(os.Stderr, "Read %s\r\n", prog.Transfer)
+		if len(failures) != 0 {
+			fmt.Printf("Verify [FAILED] - %d of %d bytes differ\n", len(failures), prog.PromSizeLoaded)
+			return EXIT_FAILED
+		}
+		fmt.Printf("Verify [OK] - %d bytes match\n", prog.PromSizeLoaded)
+
+	case "loadram":
+		prog.Images.ClearRam()
+		if err := LoadUserFile(operands[0], options.Format, options.Base, programmer.RAM_0050); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		if err := prog.UploadRamBuffer("Upload to target"); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return EXIT_ERROR
+		}
+		fmt.Println("Program running")
+	}
+	return EXIT_OK
+}
+
+// ErrorExitCode picks the exit code of an error returned while talking to the target
+func ErrorExitCode(err error) int {
+	if errors.Is(err, programmer.ErrSecured) {
+		return EXIT_SECURED
+	}
+	return EXIT_ERROR
+}
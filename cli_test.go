@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sonikku2k/PROG05/srec"
+)
+
+func TestVerifyExitCode(t *testing.T) {
+	dir := t.TempDir()
+	configuration := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configuration, []byte(`{"version": 1, "port": "SIM", "targetclock": "4MHz"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The simulated HC05 comes up erased
+	cases := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"blank.s19", []byte{0x00, 0x00}, EXIT_OK},
+		{"program.s19", []byte{0xA6, 0x55}, EXIT_FAILED},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, c.name)
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = srec.Write(file, []srec.Segment{{Address: 0x0160, Data: c.data}}, 0)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := RunCommandLine([]string{"verify", path, "--config", configuration}); code != c.want {
+			t.Errorf("%s: exit code %d, want %d", c.name, code, c.want)
+		}
+	}
+}
@@ -0,0 +1,922 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sonikku2k/PROG05/applet"
+	"github.com/sonikku2k/PROG05/board"
+	"github.com/sonikku2k/PROG05/hc05"
+	"github.com/sonikku2k/PROG05/programmer"
+)
+
+// Command of the interactive mode
+type Command struct {
+	Name    string
+	Usage   string          // Arguments, [optional]
+	Help    string          // One line description
+	Options map[string]bool // Flags accepted as --name, true if the flag takes a value
+	Run     func(reader *bufio.Reader, args []string, flags map[string]string)
+}
+
+// Flags of the commands loading a file: format (detected when missing) and address of the first byte of a binary file
+var LOAD_OPTIONS = map[string]bool{"format": true, "base": true}
+
+// COMMANDS is filled in init() as the HELP command lists the table itself
+var COMMANDS []Command
+
+func init() {
+	COMMANDS = []Command{
+		{Name: "HELP", Usage: "[COMMAND]", Help: "List the commands, or show the help of one command", Run: CmdHelp},
+		{Name: "TEST", Help: "Load test program into HC05 and check response (supports official boards and MIDON PROG05 programmer)", Run: CmdTest},
+		{Name: "DEMO", Help: "Load simple demonstration program into HC05 that toggles PORT A pins (use this to confirm your MCU is OK)", Run: CmdDemo},
+		{Name: "DUMP", Usage: "[A|B|C|D|V|O|M]", Help: "Dump internal buffer by area (A: RAM, B: PROM, C: USER PROM, D: PAGE 0 PROM, V: VECTORS, O: OPTION registers, M: memory map)", Run: CmdDump},
+		{Name: "LOADRAM", Usage: "[FILE] [--format s19|hex|bin|asm] [--base ADDR]", Help: "Load user application into HC05 RAM and execute (specify a .S19, .HEX, .BIN or .ASM file)", Options: LOAD_OPTIONS, Run: CmdLoadRam},
+		{Name: "ASM", Usage: "[FILE]", Help: "Assemble an HC05 source file (.asm, CASM05 syntax) into a .S19 file", Run: CmdAsm},
+		{Name: "DISASM", Usage: "[M|P|R [START [END]]]", Help: "Disassemble an address range of the MCU dump, the PROM images or the RAM buffer", Run: CmdDisasm},
+		{Name: "SIM", Usage: "[FILE] [--format s19|hex|bin|asm] [--base ADDR]", Help: "Run a .S19 file on the built-in HC05 emulator with step/trace output (no hardware needed)", Options: LOAD_OPTIONS, Run: CmdSim},
+		{Name: "LOAD", Usage: "[FILE] [--format s19|hex|bin|asm] [--base ADDR] [--noverify] [--secure]", Help: "Load user application into memory for EPROM programming, then program and verify (specify a .S19, .HEX or .BIN file)", Options: map[string]bool{"format": true, "base": true, "noverify": false, "secure": false}, Run: CmdLoad},
+		{Name: "READ", Usage: "[ADDR...]", Help: "Read specified memory addresses in the HC05 memory map (no address: access mode until Q)", Run: CmdRead},
+		{Name: "WRITE", Usage: "[ADDR DATA...]", Help: "Write specified memory addresses in the HC05 memory map (no address: access mode until Q)", Run: CmdWrite},
+		{Name: "BLANKCHECK", Help: "Confirm every EPROM/OTP area of the HC05 is still erased before programming", Run: CmdBlankCheck},
+		{Name: "VERIFY", Usage: "[FILE] [--format s19|hex|bin|asm] [--base ADDR]", Help: "Compare a .S19/.HEX/.BIN file against the HC05 contents (exits with status 1 on mismatch when scripted)", Options: LOAD_OPTIONS, Run: CmdVerify},
+		{Name: "DUMPMCU", Usage: "[--out FILE [--format bin|s19|hex] [--regions nnnn-nnnn,...] [--json]]", Help: "Read entire HC05 address space and display as hexdump, or save it to a file (only works if device is unsecured)", Options: map[string]bool{"out": true, "format": true, "regions": true, "json": false}, Run: CmdDumpMcu},
+		{Name: "OPTIONS", Usage: "[NAME=0|1 ...] [--clear]", Help: "Read and decode the OPTION and MASK OPTION registers of the HC05, or compose their bits by name for the next LOAD (OPTIONS RAM1=1 NCOPE=0)", Options: map[string]bool{"clear": false}, Run: CmdOptions},
+		{Name: "CLOCK", Usage: "[AUTO|FREQUENCY]", Help: "Show the target clock and the bootloader baud rate, set the crystal frequency (CLOCK 3.6864MHz) or probe it (CLOCK AUTO)", Run: CmdClock},
+		{Name: "PORTS", Help: "List the serial ports with the VID/PID, serial number and product of each USB adapter (* marks the port in use)", Run: CmdPorts},
+		{Name: "QUIT", Help: "Quit this program", Run: CmdQuit},
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Tokenize
+// Function: Split a command line into words, double quotes keep spaces inside a word (paths)
+// Parameters: Command line, with or without the line ending
+// Returns: Words
+// -------------------------------------------------------------------------------------------------------------------
+func Tokenize(line string) []string {
+
+	var tokens []string
+	var word strings.Builder
+	var inword, quoted bool
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inword = true
+		case !quoted && (c == ' ' || c == '\t' || c == '\r' || c == '\n'):
+			if inword {
+				tokens = append(tokens, word.String())
+				word.Reset()
+				inword = false
+			}
+		default:
+			word.WriteRune(c)
+			inword = true
+		}
+	}
+	if inword {
+		tokens = append(tokens, word.String())
+	}
+	return tokens
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: FindCommand
+// Function: Look a command up by name, case-insensitive, any unambiguous abbreviation is accepted
+// Parameters: Name typed by the user
+// Returns: Command, error if unknown or ambiguous
+// -------------------------------------------------------------------------------------------------------------------
+func FindCommand(name string) (*Command, error) {
+
+	name = strings.ToUpper(name)
+	if name == "?" {
+		name = "HELP"
+	}
+	var matches []*Command
+	for n := range COMMANDS {
+		if COMMANDS[n].Name == name {
+			return &COMMANDS[n], nil
+		}
+		if strings.HasPrefix(COMMANDS[n].Name, name) {
+			matches = append(matches, &COMMANDS[n])
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("unknown command %s", name)
+	case 1:
+		return matches[0], nil
+	}
+	var names []string
+	for _, match := range matches {
+		names = append(names, match.Name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("ambiguous command %s (%s)", name, strings.Join(names, ", "))
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ParseArguments
+// Function: Separate the flags (--name, --name value or --name=value) of a command from its positional arguments
+// Parameters: Words following the command name, flags accepted by the command
+// Returns: Positional arguments, flags given (value "true" for flags without value), error if any
+// -------------------------------------------------------------------------------------------------------------------
+func ParseArguments(words []string, options map[string]bool) ([]string, map[string]string, error) {
+
+	var positional []string
+	flags := make(map[string]string)
+	for n := 0; n < len(words); n++ {
+		if !strings.HasPrefix(words[n], "-") || len(words[n]) < 2 {
+			positional = append(positional, words[n])
+			continue
+		}
+		name := strings.TrimLeft(words[n], "-")
+		value := ""
+		if equal := strings.IndexByte(name, '='); equal >= 0 {
+			name, value = name[:equal], name[equal+1:]
+		}
+		name = strings.ToLower(name)
+		takesvalue, ok := options[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown flag --%s", name)
+		}
+		if takesvalue && value == "" {
+			if n+1 >= len(words) {
+				return nil, nil, fmt.Errorf("flag --%s needs a value", name)
+			}
+			n++
+			value = words[n]
+		}
+		if !takesvalue {
+			value = "true"
+		}
+		flags[name] = value
+	}
+	return positional, flags, nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ReadLine
+// Function: Read a line from the console, the line ending (CR LF or LF) and surrounding spaces are removed
+// Parameters: Console reader
+// Returns: Line, error at the end of the input
+// -------------------------------------------------------------------------------------------------------------------
+func ReadLine(reader *bufio.Reader) (string, error) {
+
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Argument
+// Function: Return an argument given on the command line, or prompt the user for it when it is missing
+// Parameters: Console reader, positional arguments, index of the argument, prompt
+// Returns: Argument
+// -------------------------------------------------------------------------------------------------------------------
+func Argument(reader *bufio.Reader, args []string, n int, prompt string) string {
+
+	if n < len(args) {
+		return args[n]
+	}
+	fmt.Print(prompt)
+	line, _ := ReadLine(reader)
+	return line
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: StartAppletInteractive
+// Function: Load an applet and upload it, the upload handshake tells the user how to start the loader and waits for
+// ENTER
+// Parameters: Console reader, applet file name, message printed before the loader instructions
+// Returns: true if the applet is running
+// -------------------------------------------------------------------------------------------------------------------
+func StartAppletInteractive(reader *bufio.Reader, name string, message string) bool {
+
+	err := prog.LoadApplet(name)
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return false
+	}
+	fmt.Println(message)
+	err = prog.UploadRamBuffer("Initialising target")
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return false
+	}
+	return true
+}
+
+// ExitIfBatch ends the program with status 1 when a check failed in batch mode
+func ExitIfBatch(failed bool) {
+	if failed && IsBatchMode() {
+		prog.Port.Close()
+		os.Exit(1)
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ExportOptions
+// Function: Check the options of a dump export, done before the target is accessed
+// Parameters: Path, format (bin, s19 or hex, taken from the extension when empty), regions (nnnn-nnnn,... empty for
+// the entire address space)
+// Returns: Format, regions, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func ExportOptions(path string, format string, regions string) (string, []programmer.Span, error) {
+
+	var err error
+	format = strings.ToLower(format)
+	if format == "" {
+		format, err = programmer.FormatFromPath(path)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if format != programmer.FORMAT_BINARY && format != programmer.FORMAT_SREC && format != programmer.FORMAT_IHEX {
+		return "", nil, fmt.Errorf("unknown format %s (bin, s19 or hex)", format)
+	}
+	spans, err := programmer.ParseSpans(regions)
+	return format, spans, err
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: LoadUserFile
+// Function: Load a file into the images of the target area, binary files are placed at the base address given, or
+// at $0051 in RAM
+// Parameters: Path, format (s19, hex, bin or asm, detected when empty), base address (hexadecimal), Target Area in MCU
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func LoadUserFile(path string, format string, base string, targetarea uint8) error {
+
+	var err error
+	format = strings.ToLower(format)
+	if format == "" {
+		format, err = programmer.DetectFormat(path)
+		if err != nil {
+			return err
+		}
+	}
+	var address uint16 = hc05.LOADER_START
+	if base != "" {
+		address, err = ParseHex(base, 16)
+		if err != nil {
+			return err
+		}
+	} else if format == programmer.FORMAT_BINARY && targetarea != programmer.RAM_0050 {
+		return fmt.Errorf("%s: a binary file needs the address of its first byte (--base nnnn)", path)
+	}
+	err = prog.LoadFile(path, format, address, targetarea)
+	if err != nil || targetarea != programmer.EPROM_ALL {
+		return err
+	}
+
+	// Data under RAM0/RAM1 is programmed all the same, but the user is told it will be hidden at run time
+	for _, region := range prog.HiddenPromRegions() {
+		fmt.Fprintf(prog.Out, " Warning: $%04X - $%04X is %s with OPTION = %02X, the data loaded there is hidden at run time\r\n",
+			region.Start, region.End, region.Name, prog.Images.OPTION_REGISTER)
+	}
+	return nil
+}
+
+// PrintSrecInfo shows the S0 header and the start address of the S-record file just loaded
+func PrintSrecInfo() {
+	if prog.Srec.Header != "" {
+		fmt.Printf(" Header: %s\r\n", prog.Srec.Header)
+	}
+	if prog.Srec.HasStart {
+		fmt.Printf(" Start address: $%04X\r\n", prog.Srec.Start)
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ShowCommands
+// Function: Print out all the available commands to the console
+// -------------------------------------------------------------------------------------------------------------------
+func ShowCommands() {
+	fmt.Println("***************** PROG05 COMMAND OPTIONS *********************")
+	fmt.Println("Available Commands (case-insensitive, may be abbreviated, HELP <command> for details):")
+	for _, command := range COMMANDS {
+		fmt.Printf(" * %-10s - %s\r\n", command.Name, command.Help)
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdHelp
+// Function: HELP command - list the commands, or show the usage of one command
+// -------------------------------------------------------------------------------------------------------------------
+func CmdHelp(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	if len(args) == 0 {
+		ShowCommands()
+		return
+	}
+	command, err := FindCommand(args[0])
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	fmt.Printf(" %s %s\r\n", command.Name, command.Usage)
+	fmt.Printf("   %s\r\n", command.Help)
+	if command.Usage != "" {
+		fmt.Println("   Arguments left out are asked for")
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdTest
+// Function: TEST command - Load small app into HC05 and process its response
+// -------------------------------------------------------------------------------------------------------------------
+func CmdTest(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	prog.Port.Flush()
+	fmt.Println("Loading test program compatible with MC68HC05PGMR and MIDON PROG05")
+	err := prog.LoadApplet(applet.GOTEST)
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	fmt.Printf("S-Record loaded Successfully. %d bytes written to buffer\r\n", prog.RamSizeLoaded)
+	if prog.UploadRamBuffer("Upload to target") == nil {
+		fmt.Printf("Checking target.... ")
+		if prog.TestTarget() {
+			fmt.Printf(" [OK]\r\n")
+			fmt.Println("Target (68HC705C8) access is Successful")
+		} else {
+			fmt.Printf(" [FAILED]\r\n")
+			fmt.Println("  Check your hardware, clock speed, and confirm HC05 did go into bootloader mode")
+		}
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdDemo
+// Function: DEMO command - Load small app into HC05 to allow user to play with it
+// -------------------------------------------------------------------------------------------------------------------
+func CmdDemo(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	prog.Port.Flush()
+	fmt.Println("Loading DEMO program compatible with MC68HC05PGMR and MIDON PROG05")
+	err := prog.LoadApplet(applet.DEMO)
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	fmt.Printf("S-Record loaded Successfully. %d bytes written to buffer\r\n", prog.RamSizeLoaded)
+	if prog.UploadRamBuffer("Upload to target") == nil {
+		fmt.Printf("Demo program should be running - Check PORT A pins for toggling\r\n")
+		prog.Port.Flush()
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdDump
+// Function: DUMP command - each area is shown at its HC05 address
+// -------------------------------------------------------------------------------------------------------------------
+func CmdDump(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	area := Argument(reader, args, 0, "Area (A: RAM, B: PROM, C: USER PROM, D: PAGE 0 PROM, V: VECTORS, O: OPTION, M: MEMORY MAP):")
+	switch strings.ToUpper(area) {
+	case "A":
+		fmt.Println("HEX Dump of RAM buffer ($0050 - $00FF in the HC05 memory map)")
+		DumpImages(0x0050, 0x00FF)
+	case "B":
+		fmt.Println("HEX Dump of PROM buffer ($0160 - $1EFF in the HC05 memory map)")
+		DumpImages(0x0160, 0x1EFF)
+	case "C":
+		fmt.Println("HEX Dump of USER PROM buffer ($0100 - $015F in the HC05 memory map)")
+		DumpImages(0x0100, 0x015F)
+	case "D":
+		fmt.Println("HEX Dump of PAGE 0 PROM buffer ($0020 - $004F in the HC05 memory map)")
+		DumpImages(0x0020, 0x004F)
+	case "V":
+		fmt.Println("HEX Dump of PROM VECTORS buffer ($1FF4 - $1FFF in the HC05 memory map)")
+		DumpImages(0x1FF4, 0x1FFF)
+	case "O":
+		fmt.Println("HEX Dump of option register buffers ($1FDF, $1FF0 - $1FF1 in the HC05 memory map)")
+		DumpImages(hc05.OPTION_ADDRESS, hc05.OPTION_ADDRESS)
+		DumpImages(0x1FF0, 0x1FF1)
+		PrintOptions(map[uint16]byte{
+			hc05.OPTION_ADDRESS: prog.Images.OPTION_REGISTER,
+			hc05.MOR1_ADDRESS:   prog.Images.MASK_OPTION_REGISTER1,
+			hc05.MOR2_ADDRESS:   prog.Images.MASK_OPTION_REGISTER2,
+		})
+	case "M":
+		PrintLayout(prog.Images.OPTION_REGISTER)
+	default:
+		fmt.Println(" Invalid user input- area must be A, B, C, D, V, O or M")
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: DumpImages
+// Function: Hex dump of the memory area images between two HC05 addresses, as seen while programming
+// Parameters: First and last address
+// -------------------------------------------------------------------------------------------------------------------
+func DumpImages(start uint16, end uint16) {
+
+	buffer := make([]byte, 0, int(end)-int(start)+1)
+	for address := int(start); address <= int(end); address++ {
+		data, err := prog.Memory.Read(uint16(address))
+		if err != nil {
+			data = prog.Erased
+		}
+		buffer = append(buffer, data)
+	}
+	DumpMemory(buffer, len(buffer), start)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: PrintLayout
+// Function: Show the regions of the HC05 memory map selected by the RAM0 and RAM1 bits of the OPTION register
+// Parameters: Value of the OPTION register
+// -------------------------------------------------------------------------------------------------------------------
+func PrintLayout(option byte) {
+
+	fmt.Printf(" Memory map with OPTION = %02X (RAM0 = %d, RAM1 = %d)\r\n", option, option>>7&1, option>>6&1)
+	for _, region := range hc05.Layout(option) {
+		fmt.Printf("  $%04X - $%04X  %s\r\n", region.Start, region.End, region.Name)
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdLoadRam
+// Function: LOADRAM command - Load a program into the HC05 RAM and run it
+// -------------------------------------------------------------------------------------------------------------------
+func CmdLoadRam(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	path := Argument(reader, args, 0, " Enter path and file name (.s19, .hex, .bin or .asm): ")
+
+	// Clear buffer prior to loading
+	prog.Images.ClearRam()
+	err := LoadUserFile(path, flags["format"], flags["base"], programmer.RAM_0050)
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	fmt.Printf("File loaded Successfully. %d bytes written to buffer\r\n", prog.RamSizeLoaded)
+	PrintSrecInfo()
+	err = prog.UploadRamBuffer("Upload to target")
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	fmt.Println(" Program Running!")
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdAsm
+// Function: ASM command - Assemble an HC05 source file into an S-record file
+// -------------------------------------------------------------------------------------------------------------------
+func CmdAsm(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	path := Argument(reader, args, 0, " Enter path and file name of assembly source file: ")
+	if err := AssembleSource(path); err != nil {
+		fmt.Println(" Error:", err)
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdDisasm
+// Function: DISASM command - Disassemble an address range of a buffer
+// -------------------------------------------------------------------------------------------------------------------
+func CmdDisasm(reader *bufio.Reader, args []string, flags map[string]string) {
+	Disassembly(reader, args)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdSim
+// Function: SIM command - Run a RAM program on the HC05 emulator
+// -------------------------------------------------------------------------------------------------------------------
+func CmdSim(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	path := Argument(reader, args, 0, " Enter path and file name (.s19, .hex, .bin or .asm): ")
+
+	prog.Images.ClearRam()
+	err := LoadUserFile(path, flags["format"], flags["base"], programmer.RAM_0050)
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	fmt.Printf("File loaded Successfully. %d bytes written to buffer\r\n", prog.RamSizeLoaded)
+	PrintSrecInfo()
+	RunSimulator(reader)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ConfirmSecurityBit
+// Function: Ask the user to confirm that the OPTION register loaded may set the security bit, which cannot be undone
+// on an OTP part and locks the bootloader out of the EPROM
+// Parameters: Reader for user input, true if --secure was given (no question asked)
+// Returns: true if programming may go on
+// -------------------------------------------------------------------------------------------------------------------
+func ConfirmSecurityBit(reader *bufio.Reader, secure bool) bool {
+
+	fmt.Printf(" WARNING: the file sets the security bit (OPTION = %02X). Once programmed the HC05 can no longer be read,\r\n", prog.Images.OPTION_REGISTER)
+	fmt.Println(" verified or dumped, an OTP part stays secured for good")
+	if secure {
+		return true
+	}
+	fmt.Print(" Type SECURE to program the security bit: ")
+	answer, err := ReadLine(reader)
+	return err == nil && answer == "SECURE"
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdLoad
+// Function: LOAD command - Program the EPROM/OTP of the HC05 from an S-record
+// -------------------------------------------------------------------------------------------------------------------
+func CmdLoad(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	path := Argument(reader, args, 0, " Enter path and file name (.s19, .hex, .bin or .asm): ")
+
+	// Clear images prior to loading
+	prog.Images.ClearPromImages(prog.Erased)
+	err := LoadUserFile(path, flags["format"], flags["base"], programmer.EPROM_ALL)
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	for _, address := range prog.ApplyOptions() {
+		fmt.Printf(" %s ($%04X) of the file replaced by the value composed with OPTIONS\r\n", hc05.REGISTER_NAMES[address], address)
+	}
+	fmt.Printf("File loaded Successfully. %d bytes written to EPROM images\r\n", prog.PromSizeLoaded)
+	PrintSrecInfo()
+	if prog.LoadsSecurityBit() && !ConfirmSecurityBit(reader, flags["secure"] != "") {
+		fmt.Println(" Programming cancelled")
+		ExitIfBatch(true)
+		return
+	}
+
+	// Programming is done by an applet in the HC05 RAM
+	if !StartAppletInteractive(reader, applet.MEMPROG, "Preparing to program HC05...") {
+		return
+	}
+	if prog.Board.Drives(board.VPP) {
+		err = prog.Board.SetVpp(true)
+		if err != nil {
+			fmt.Println(" Error:", err)
+			return
+		}
+		fmt.Println("Vpp switched ON")
+	} else {
+		PrintVppInstruction()
+		ReadLine(reader)
+	}
+
+	programmed, failures, err := prog.ProgramPromImages()
+	if prog.Board.Drives(board.VPP) {
+		if err := prog.Board.SetVpp(false); err != nil {
+			fmt.Println(" Error:", err)
+			return
+		}
+	}
+	for _, f := range failures {
+		fmt.Printf(" Program failure at %04X: wrote %02X read %02X\r\n", f.Address, f.Expected, f.Actual)
+	}
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	fmt.Printf(" %d bytes programmed, %d failures\r\n", programmed, len(failures))
+	if flags["noverify"] != "" {
+		ExitIfBatch(len(failures) != 0)
+		return
+	}
+	if prog.LoadsSecurityBit() {
+		fmt.Println(" Verify skipped - the HC05 is now secured and cannot be read back")
+		ExitIfBatch(len(failures) != 0)
+		return
+	}
+
+	// Verify pass with the memblock applet, the target has to go through the loader again
+	message := "Switch Vpp OFF and hold the target in RESET for verification"
+	if prog.Board.HandsFree() && prog.Board.Drives(board.VPP) {
+		message = "Preparing to verify HC05..."
+	}
+	if !StartAppletInteractive(reader, applet.MEMBLOCK, message) {
+		return
+	}
+	ExitIfBatch(VerifyPromImages() != 0)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdRead
+// Function: READ command - Read the addresses given, or every address entered until Q
+// -------------------------------------------------------------------------------------------------------------------
+func CmdRead(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	if !StartAppletInteractive(reader, applet.MEMREAD, "Preparing to access HC05...") {
+		return
+	}
+	read := func(argument string) {
+		address, err := ParseHex(argument, 16)
+		if err != nil {
+			fmt.Println(" Invalid user input- must be 4 hexadecimal digits (format: nnnn)")
+			return
+		}
+		readbyte, err := prog.ReadByteFromMCU(address)
+		if err == nil {
+			fmt.Printf(" %04X Value Read: %02X\r\n", address, readbyte)
+		} else {
+			fmt.Println(" Error reading memory")
+		}
+	}
+	if len(args) > 0 {
+		for _, argument := range args {
+			read(argument)
+		}
+		return
+	}
+
+	// Applet is in the HC05, now we can interact with it
+	fmt.Println("     -- HC05 is in access mode, enter Q to exit and return --    ")
+	for {
+		fmt.Printf("Enter address to be read (in hexadecimal):")
+		keyinput, err := ReadLine(reader)
+		if err != nil || strings.EqualFold(keyinput, "Q") {
+			fmt.Println("     -- HC05 access mode terminated --    ")
+			return
+		}
+		if keyinput != "" {
+			read(keyinput)
+		}
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdWrite
+// Function: WRITE command - Write the address/data pairs given, or every pair entered until Q
+// -------------------------------------------------------------------------------------------------------------------
+func CmdWrite(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	if len(args)%2 != 0 {
+		fmt.Println(" Invalid user input- address and data must come in pairs")
+		return
+	}
+	if !StartAppletInteractive(reader, applet.MEMWRITE, "Preparing to access HC05...") {
+		return
+	}
+	write := func(addressinput string, datainput string) {
+		address, err := ParseHex(addressinput, 16)
+		if err != nil {
+			fmt.Println(" Invalid user input- must be 4 hexadecimal digits (format: nnnn)")
+			return
+		}
+		data, err := ParseHex(datainput, 8)
+		if err != nil {
+			fmt.Println(" Invalid user input- must be 2 hexadecimal digits (format: nn)")
+			return
+		}
+		err = prog.WriteByteToMCU(address, byte(data))
+		if err != nil {
+			fmt.Println(" Error:", err)
+			return
+		}
+		fmt.Printf(" %04X <- %02X Write operation complete...\r\n", address, data)
+	}
+	if len(args) > 0 {
+		for n := 0; n < len(args); n += 2 {
+			write(args[n], args[n+1])
+		}
+		return
+	}
+
+	// Applet is in the HC05, now we can interact with it
+	fmt.Println("     -- HC05 is in access mode, enter Q to exit and return --    ")
+	for {
+		fmt.Printf("Enter address to be written (in hexadecimal):")
+		addressinput, err := ReadLine(reader)
+		if err != nil || strings.EqualFold(addressinput, "Q") {
+			break
+		}
+		if addressinput == "" {
+			continue
+		}
+		fmt.Printf("Enter data to be written (in hexadecimal):")
+		datainput, err := ReadLine(reader)
+		if err != nil || strings.EqualFold(datainput, "Q") {
+			break
+		}
+		write(addressinput, datainput)
+	}
+	fmt.Println("     -- HC05 access mode terminated --    ")
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdBlankCheck
+// Function: BLANKCHECK command - Confirm the EPROM/OTP is still erased
+// -------------------------------------------------------------------------------------------------------------------
+func CmdBlankCheck(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	if !StartAppletInteractive(reader, applet.MEMBLOCK, "Preparing to blank check HC05...") {
+		return
+	}
+	spans, err := prog.BlankCheck()
+	for _, span := range spans {
+		fmt.Printf(" Not blank: $%04X-$%04X\r\n", span.Start, span.End)
+	}
+	if err == nil {
+		fmt.Printf(" Read %s\r\n", prog.Transfer)
+	}
+	if err != nil {
+		fmt.Println(" Error:", err)
+	} else if len(spans) != 0 {
+		fmt.Printf(" Blank check [FAILED] - %d programmed region(s) found\r\n", len(spans))
+	} else {
+		fmt.Printf(" Blank check [OK] - every EPROM byte reads %02X\r\n", prog.Erased)
+	}
+	ExitIfBatch(err != nil || len(spans) != 0)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdVerify
+// Function: VERIFY command - Compare an S-record against the HC05 contents
+// -------------------------------------------------------------------------------------------------------------------
+func CmdVerify(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	path := Argument(reader, args, 0, " Enter path and file name (.s19, .hex, .bin or .asm): ")
+
+	prog.Images.ClearPromImages(prog.Erased)
+	err := LoadUserFile(path, flags["format"], flags["base"], programmer.EPROM_ALL)
+	if err != nil {
+		fmt.Println(" Error:", err)
+		ExitIfBatch(true)
+		return
+	}
+	fmt.Printf("File loaded Successfully. %d bytes to verify\r\n", prog.PromSizeLoaded)
+	PrintSrecInfo()
+	if !StartAppletInteractive(reader, applet.MEMBLOCK, "Preparing to verify HC05...") {
+		return
+	}
+	ExitIfBatch(VerifyPromImages() != 0)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdDumpMcu
+// Function: DUMPMCU command - Dump entire MCU address space 0x0000 - 0x1FFF
+// -------------------------------------------------------------------------------------------------------------------
+func CmdDumpMcu(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	format, spans, err := ExportOptions(flags["out"], flags["format"], flags["regions"])
+	if flags["out"] != "" && err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+
+	// First we load an applet to the HC05 to access the memory map
+	if !StartAppletInteractive(reader, applet.MEMBLOCK, "Preparing to dump HC05...") {
+		return
+	}
+
+	registers, err := prog.ReadOptions()
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	OPTIONREG := registers[hc05.OPTION_ADDRESS]
+	MASK_OPT_REG1 := registers[hc05.MOR1_ADDRESS]
+	MASK_OPT_REG2 := registers[hc05.MOR2_ADDRESS]
+	fmt.Printf(" OPTION Register = %02X\r\n", OPTIONREG)
+	fmt.Printf(" MASK OPTION Register 1 = %02X\r\n", MASK_OPT_REG1)
+	fmt.Printf(" MASK OPTION Register 2 = %02X\r\n", MASK_OPT_REG2)
+
+	// Loop to dump entire memory range
+	err = prog.DumpMCU()
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	fmt.Println(" Entire HC05 memory space read successfully")
+	fmt.Printf(" Read %s\r\n", prog.Transfer)
+	PrintLayout(OPTIONREG)
+	if flags["out"] == "" {
+		DumpMemory(prog.McuDump, len(prog.McuDump), 0)
+		return
+	}
+	err = prog.ExportDump(flags["out"], format, spans, flags["json"] != "")
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	fmt.Printf(" HC05 memory written to %s\r\n", flags["out"])
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdQuit
+// Function: QUIT command
+// -------------------------------------------------------------------------------------------------------------------
+func CmdQuit(reader *bufio.Reader, args []string, flags map[string]string) {
+	if err := prog.Board.Idle(); err != nil {
+		fmt.Println(" Error:", err)
+	}
+	prog.Port.Close()
+	fmt.Println("Program shutdown")
+	os.Exit(0)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdOptions
+// Function: OPTIONS command - Decode the option registers of the HC05, or compose values for the next LOAD
+// -------------------------------------------------------------------------------------------------------------------
+func CmdOptions(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	if flags["clear"] != "" {
+		prog.Options = make(map[uint16]byte)
+		fmt.Println(" Composed option register values cleared")
+		return
+	}
+	if len(args) != 0 {
+		latches, err := hc05.ComposeOptions(prog.Options, args, prog.Erased)
+		if err != nil {
+			fmt.Println(" Error:", err)
+			return
+		}
+		if len(latches) != 0 {
+			fmt.Printf(" Note: programming %s has no effect, RAM0, RAM1 and IRQ are latches set by the program at run time\r\n", strings.Join(latches, ", "))
+		}
+		fmt.Println(" Option register values programmed by the next LOAD (unnamed bits erased):")
+		PrintOptions(prog.Options)
+		return
+	}
+
+	if !StartAppletInteractive(reader, applet.MEMBLOCK, "Preparing to read the option registers...") {
+		return
+	}
+	registers, err := prog.ReadOptions()
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	PrintOptions(registers)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: PrintOptions
+// Function: Show the option registers with the meaning of each named bit
+// Parameters: Register values by address
+// -------------------------------------------------------------------------------------------------------------------
+func PrintOptions(registers map[uint16]byte) {
+
+	for _, address := range hc05.OPTION_REGISTERS {
+		value, found := registers[address]
+		if !found {
+			continue
+		}
+		fmt.Printf(" %s ($%04X) = %02X\r\n", hc05.REGISTER_NAMES[address], address, value)
+		for _, line := range hc05.DecodeOptions(address, value) {
+			fmt.Printf("   %s\r\n", line)
+		}
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdClock
+// Function: CLOCK command - Show the target clock, give the crystal frequency or probe it
+// -------------------------------------------------------------------------------------------------------------------
+func CmdClock(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	if len(args) == 0 {
+		if prog.Clock == 0 {
+			fmt.Println(" Target clock not known, use CLOCK AUTO to probe it")
+			return
+		}
+		fmt.Printf(" Target clock: %s, bootloader SCI rate %d baud\r\n", hc05.FormatClock(prog.Clock), prog.BaudRate)
+		return
+	}
+	if strings.EqualFold(args[0], "auto") {
+		err := ProbeTargetClock()
+		if err != nil {
+			fmt.Println(" Error:", err)
+		}
+		return
+	}
+	clock, err := hc05.ParseClock(args[0])
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	err = prog.SetClock(clock)
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	fmt.Printf(" Target clock: %s, bootloader SCI rate %d baud\r\n", hc05.FormatClock(prog.Clock), prog.BaudRate)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CmdPorts
+// Function: PORTS command - List the serial ports of the computer, the port in use is marked
+// -------------------------------------------------------------------------------------------------------------------
+func CmdPorts(reader *bufio.Reader, args []string, flags map[string]string) {
+
+	err := PrintPorts(os.Stdout, portname)
+	if err != nil {
+		fmt.Println(" Error:", err)
+	}
+}
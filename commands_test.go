@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadLine(t *testing.T) {
+	for _, input := range []string{"dump b\n", "dump b\r\n", "  dump b  \r\n", "dump b"} {
+		line, err := ReadLine(bufio.NewReader(strings.NewReader(input)))
+		if err != nil || line != "dump b" {
+			t.Errorf("%q: read %q, %v", input, line, err)
+		}
+	}
+	if _, err := ReadLine(bufio.NewReader(strings.NewReader(""))); err == nil {
+		t.Error("no error at the end of the input")
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		line string
+		want []string
+	}{
+		{"DUMP B\n", []string{"DUMP", "B"}},
+		{"DUMP B\r\n", []string{"DUMP", "B"}},
+		{"  read\t0160  1FDF ", []string{"read", "0160", "1FDF"}},
+		{`load "my files/app.s19" --noverify`, []string{"load", "my files/app.s19", "--noverify"}},
+		{`verify ""`, []string{"verify", ""}},
+		{"\r\n", nil},
+	}
+	for _, c := range cases {
+		if words := Tokenize(c.line); !reflect.DeepEqual(words, c.want) {
+			t.Errorf("%q: %q, want %q", c.line, words, c.want)
+		}
+	}
+}
+
+func TestFindCommand(t *testing.T) {
+	cases := []struct {
+		name string
+		want string // Command found, empty for an error
+		err  string
+	}{
+		{"DUMP", "DUMP", ""},
+		{"dump", "DUMP", ""}, // Exact match beats the DUMPMCU prefix
+		{"DumpMcu", "DUMPMCU", ""},
+		{"bl", "BLANKCHECK", ""},
+		{"loadr", "LOADRAM", ""},
+		{"?", "HELP", ""},
+		{"du", "", "ambiguous command DU (DUMP, DUMPMCU)"},
+		{"lo", "", "ambiguous command LO (LOAD, LOADRAM)"},
+		{"erase", "", "unknown command ERASE"},
+	}
+	for _, c := range cases {
+		command, err := FindCommand(c.name)
+		switch {
+		case c.err != "" && (err == nil || err.Error() != c.err):
+			t.Errorf("%s: error %v, want %q", c.name, err, c.err)
+		case c.err == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.err == "" && command.Name != c.want:
+			t.Errorf("%s: found %s, want %s", c.name, command.Name, c.want)
+		}
+	}
+}
+
+func TestParseArguments(t *testing.T) {
+	options := map[string]bool{"noverify": false, "out": true}
+	cases := []struct {
+		words      []string
+		positional []string
+		flags      map[string]string
+		err        bool
+	}{
+		{[]string{"app.s19"}, []string{"app.s19"}, map[string]string{}, false},
+		{[]string{"app.s19", "--NoVerify"}, []string{"app.s19"}, map[string]string{"noverify": "true"}, false},
+		{[]string{"--out", "dump.s19"}, nil, map[string]string{"out": "dump.s19"}, false},
+		{[]string{"-out=dump.hex", "-"}, []string{"-"}, map[string]string{"out": "dump.hex"}, false},
+		{[]string{"--out"}, nil, nil, true},
+		{[]string{"--force"}, nil, nil, true},
+	}
+	for _, c := range cases {
+		positional, flags, err := ParseArguments(c.words, options)
+		if (err != nil) != c.err {
+			t.Errorf("%q: error %v", c.words, err)
+			continue
+		}
+		if !c.err && (!reflect.DeepEqual(positional, c.positional) || !reflect.DeepEqual(flags, c.flags)) {
+			t.Errorf("%q: %q %v, want %q %v", c.words, positional, flags, c.positional, c.flags)
+		}
+	}
+}
@@ -0,0 +1,442 @@
+// Package config reads the PROG05 configuration: board profiles, serial port and target settings, overridden by
+// environment variables and command line flags
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sonikku2k/PROG05/applet"
+	"github.com/sonikku2k/PROG05/board"
+	"github.com/sonikku2k/PROG05/hc05"
+)
+
+// Version of the configuration file written by this PROG05, files without a version are read as version 1
+const VERSION = 1
+
+// Name of the configuration file in each of the places searched
+const FILE_NAME = "config.json"
+
+// Boards known to PROG05
+const BOARD_PGMR = "MC68HC05PGMR"  // Motorola MC68HC05PGMR, 2MHz crystal, loader started with switches S2-S6
+const BOARD_MIDON = "MIDON PROG05" // MIDON PROG05, 2MHz crystal, loader started with J1 and SW1
+const BOARD_CUSTOM = "custom"      // Any other board, every setting comes from the profile
+
+// Defaults of each board, the settings of the file take precedence
+var BOARDS = map[string]Profile{
+	BOARD_PGMR:   {Targetclock: "2MHz"},
+	BOARD_MIDON:  {Targetclock: "2MHz"},
+	BOARD_CUSTOM: {},
+}
+
+// Limits of the numeric settings
+const MIN_BAUD = 300
+const MAX_BAUD = 115200
+const MAX_PACING = 1000 // mS
+const MAX_RESET_TIME = 5000
+
+// Environment variables overriding the configuration file (the flags override them in turn)
+const ENV_CONFIG = "PROG05_CONFIG"
+const ENV_PROFILE = "PROG05_PROFILE"
+const ENV_BOARD = "PROG05_BOARD"
+const ENV_PORT = "PROG05_PORT"
+const ENV_CLOCK = "PROG05_CLOCK"
+const ENV_BAUD = "PROG05_BAUD"
+const ENV_PACING = "PROG05_PACING"
+const ENV_ERASED = "PROG05_ERASED"
+
+// AutoReset describes how PROG05 drives the board signals (RESET, IRQ, VPP and PD5-PD2) so the HC05 gets into its
+// bootloader, and is programmed, without the operator
+type AutoReset struct {
+	Line   string            `json:"line,omitempty"`   // DTR or RTS wired to RESET, short for "pins": {"RESET": line}
+	Pins   map[string]string `json:"pins,omitempty"`   // Line of each signal driven: DTR, RTS or a GPIO output, "!" inverts
+	Gpio   string            `json:"gpio,omitempty"`   // Serial port of the GPIO controller, the lines are then its outputs
+	Pulse  int               `json:"pulse,omitempty"`  // mS the HC05 is held in reset
+	Settle int               `json:"settle,omitempty"` // mS between the release of reset and the first byte sent to the loader
+	Vpp    int               `json:"vpp,omitempty"`    // mS between switching Vpp on and the first byte programmed
+}
+
+// PinMap returns the line of each signal driven, "line" included
+func (a *AutoReset) PinMap() map[string]string {
+	pins := make(map[string]string)
+	for signal, line := range a.Pins {
+		pins[signal] = line
+	}
+	if a.Line != "" {
+		pins[board.RESET] = a.Line
+	}
+	return pins
+}
+
+// Profile is a set of settings as written in the file, a field left out keeps the value of the layer below
+type Profile struct {
+	Board       string            `json:"board,omitempty"`
+	Port        string            `json:"port,omitempty"`
+	Targetclock string            `json:"targetclock,omitempty"`
+	Baud        *int              `json:"baud,omitempty"`   // Overrides the rate worked out from the clock, 0 goes back to it
+	Pacing      *int              `json:"pacing,omitempty"` // mS between two bytes sent to the bootloader, 0 for the default
+	Erased      *uint8            `json:"erased,omitempty"`
+	Applets     map[string]string `json:"applets,omitempty"`
+	AutoReset   *AutoReset        `json:"autoreset,omitempty"`
+}
+
+// file is the layout of config.json: settings shared by every profile at the top, then the named profiles
+type file struct {
+	Version int    `json:"version"`
+	Default string `json:"profile"` // Profile used when none is selected
+	Profile
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// Settings are the values in use once the file, the environment and the flags are combined
+type Settings struct {
+	Source      string // Configuration file read, empty if none was found
+	ProfileName string // Profile selected, empty if the file has none
+	Board       string
+	Port        string
+	Targetclock string
+	Baud        int // 0: worked out from the clock
+	Pacing      int // 0: bootloader.BYTE_PACING
+	Erased      uint8
+	Applets     map[string]string
+	AutoReset   *AutoReset // nil when the operator sets the board signals
+}
+
+// ValidationError lists every problem found in the settings
+type ValidationError struct {
+	Source   string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	source := e.Source
+	if source == "" {
+		source = "settings"
+	}
+	return fmt.Sprintf("%s:\r\n  %s", source, strings.Join(e.Problems, "\r\n  "))
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: SearchPaths
+// Function: List the places a configuration file is looked for, in order: PROG05_CONFIG, the working directory, the
+// user configuration directory ($XDG_CONFIG_HOME/prog05 or ~/.config/prog05, %AppData%\prog05 on Windows) and the
+// directory of the executable
+// Returns: Paths
+// -------------------------------------------------------------------------------------------------------------------
+func SearchPaths() []string {
+
+	var paths []string
+	if path := os.Getenv(ENV_CONFIG); path != "" {
+		paths = append(paths, path)
+	}
+	paths = append(paths, FILE_NAME)
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "prog05", FILE_NAME))
+	}
+	if executable, err := os.Executable(); err == nil {
+		paths = append(paths, filepath.Join(filepath.Dir(executable), FILE_NAME))
+	}
+	return paths
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Load
+// Function: Read the configuration file, select a profile and apply the environment variables and the flags
+// Parameters: Configuration file (empty: the first of SearchPaths that exists), profile name (empty: PROG05_PROFILE,
+// then the default profile of the file), settings given by the flags
+// Returns: Settings, error if the file cannot be read or the settings are not valid (*ValidationError)
+// -------------------------------------------------------------------------------------------------------------------
+func Load(path string, profile string, flags Profile) (Settings, error) {
+
+	var settings Settings
+	var contents file
+	if path == "" {
+		for _, candidate := range SearchPaths() {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+	if path != "" {
+		err := readFile(path, &contents)
+		if err != nil {
+			return settings, err
+		}
+		settings.Source = path
+	}
+
+	var problems []string
+	if contents.Version > VERSION {
+		problems = append(problems, fmt.Sprintf("version %d is not supported, this PROG05 reads version %d", contents.Version, VERSION))
+	}
+
+	// Top of the file, then the profile
+	layers := contents.Profile
+	if profile == "" {
+		profile = os.Getenv(ENV_PROFILE)
+	}
+	if profile == "" {
+		profile = contents.Default
+	}
+	if profile == "" && len(contents.Profiles) == 1 {
+		for name := range contents.Profiles {
+			profile = name
+		}
+	}
+	if profile != "" {
+		selected, found := contents.Profiles[profile]
+		if !found {
+			problems = append(problems, fmt.Sprintf("profile \"%s\" not found (profiles: %s)", profile, strings.Join(ProfileNames(contents.Profiles), ", ")))
+		}
+		layers.merge(selected)
+		settings.ProfileName = profile
+	}
+
+	// Environment, then flags
+	environment, envproblems := fromEnvironment()
+	problems = append(problems, envproblems...)
+	layers.merge(environment)
+	layers.merge(flags)
+
+	// The board fills in what is still missing
+	if layers.Board != "" {
+		board, found := findBoard(layers.Board)
+		if !found {
+			problems = append(problems, fmt.Sprintf("board \"%s\" unknown (%s, %s or %s)", layers.Board, BOARD_PGMR, BOARD_MIDON, BOARD_CUSTOM))
+		} else {
+			defaults := BOARDS[board]
+			defaults.merge(layers)
+			layers = defaults
+			layers.Board = board
+		}
+	}
+
+	settings.Board = layers.Board
+	settings.Port = layers.Port
+	settings.Targetclock = layers.Targetclock
+	if layers.Baud != nil {
+		settings.Baud = *layers.Baud
+	}
+	if layers.Pacing != nil {
+		settings.Pacing = *layers.Pacing
+	}
+	if layers.Erased != nil {
+		settings.Erased = *layers.Erased
+	}
+	settings.Applets = layers.Applets
+	settings.AutoReset = layers.AutoReset
+
+	problems = append(problems, settings.validate()...)
+	if len(problems) != 0 {
+		if settings.Source == "" {
+			problems = append(problems, fmt.Sprintf("no configuration file found (searched %s)", strings.Join(SearchPaths(), ", ")))
+		}
+		return settings, &ValidationError{Source: settings.Source, Problems: problems}
+	}
+	return settings, nil
+}
+
+// readFile decodes a configuration file, unknown keys are reported so a misspelt setting is not silently ignored
+func readFile(path string, contents *file) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(contents)
+	if err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			line := bytes.Count(data[:syntax.Offset], []byte("\n")) + 1
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// merge replaces the settings of p by those given in over
+func (p *Profile) merge(over Profile) {
+
+	if over.Board != "" {
+		p.Board = over.Board
+	}
+	if over.Port != "" {
+		p.Port = over.Port
+	}
+	if over.Targetclock != "" {
+		p.Targetclock = over.Targetclock
+	}
+	if over.Baud != nil {
+		p.Baud = over.Baud
+	}
+	if over.Pacing != nil {
+		p.Pacing = over.Pacing
+	}
+	if over.Erased != nil {
+		p.Erased = over.Erased
+	}
+	if over.Applets != nil {
+		p.Applets = over.Applets
+	}
+	if over.AutoReset != nil {
+		p.AutoReset = over.AutoReset
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: fromEnvironment
+// Function: Read the settings given by PROG05_* environment variables
+// Returns: Settings, problems found in the values
+// -------------------------------------------------------------------------------------------------------------------
+func fromEnvironment() (Profile, []string) {
+
+	var profile Profile
+	var problems []string
+	// A variable set, even to 0, replaces the value of the file
+	number := func(name string, bits int) *int {
+		text := os.Getenv(name)
+		if text == "" {
+			return nil
+		}
+		value, err := strconv.ParseUint(text, 10, bits)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: \"%s\" is not a number", name, text))
+		}
+		result := int(value)
+		return &result
+	}
+	profile.Board = os.Getenv(ENV_BOARD)
+	profile.Port = os.Getenv(ENV_PORT)
+	profile.Targetclock = os.Getenv(ENV_CLOCK)
+	profile.Baud = number(ENV_BAUD, 32)
+	profile.Pacing = number(ENV_PACING, 32)
+	if value := number(ENV_ERASED, 8); value != nil {
+		erased := uint8(*value)
+		profile.Erased = &erased
+	}
+	return profile, problems
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: validate
+// Function: Check every setting
+// Returns: Problems found, one line each
+// -------------------------------------------------------------------------------------------------------------------
+func (s Settings) validate() []string {
+
+	var problems []string
+	if s.Port == "" {
+		problems = append(problems, fmt.Sprintf("port: no serial port given (COMx, /dev/ttyUSBx, auto, SIM or EMU, or --port / %s)", ENV_PORT))
+	}
+	if _, err := s.Clock(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if s.Baud != 0 && (s.Baud < MIN_BAUD || s.Baud > MAX_BAUD) {
+		problems = append(problems, fmt.Sprintf("baud: %d out of range (%d - %d)", s.Baud, MIN_BAUD, MAX_BAUD))
+	}
+	if s.Baud != 0 && strings.EqualFold(strings.TrimSpace(s.Targetclock), "auto") {
+		problems = append(problems, "baud: cannot be given with targetclock \"auto\", the probe picks the rate")
+	}
+	if s.Pacing < 0 || s.Pacing > MAX_PACING {
+		problems = append(problems, fmt.Sprintf("pacing: %d mS out of range (0 - %d)", s.Pacing, MAX_PACING))
+	}
+	for name := range s.Applets {
+		if !isApplet(name) {
+			problems = append(problems, fmt.Sprintf("applets: \"%s\" is not the name of an applet (%s)", name, strings.Join(APPLET_NAMES, ", ")))
+		}
+	}
+	if s.AutoReset != nil {
+		for signal := range s.AutoReset.Pins {
+			if s.AutoReset.Line != "" && strings.EqualFold(signal, board.RESET) {
+				problems = append(problems, "autoreset: RESET given twice, by \"line\" and by \"pins\"")
+			}
+		}
+		pins, pinproblems := board.ParsePins(s.AutoReset.PinMap(), s.AutoReset.Gpio != "")
+		for _, problem := range pinproblems {
+			problems = append(problems, "autoreset.pins: "+problem)
+		}
+		if len(pinproblems) == 0 && len(pins) == 0 {
+			problems = append(problems, "autoreset: no signal driven, give \"line\" or \"pins\"")
+		}
+		reset := s.AutoReset
+		if reset.Pulse < 0 || reset.Pulse > MAX_RESET_TIME || reset.Settle < 0 || reset.Settle > MAX_RESET_TIME ||
+			reset.Vpp < 0 || reset.Vpp > MAX_RESET_TIME {
+			problems = append(problems, fmt.Sprintf("autoreset: pulse, settle and vpp must be 0 - %d mS", MAX_RESET_TIME))
+		}
+	}
+	return problems
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Clock
+// Function: Read the target clock: a frequency ("2MHz", "4MHz", "3.6864MHz"...) or "auto"
+// Returns: Crystal frequency in Hz (0 for "auto", the clock is then probed), error if it is not a valid frequency
+// -------------------------------------------------------------------------------------------------------------------
+func (s Settings) Clock() (int, error) {
+
+	switch strings.ToLower(strings.TrimSpace(s.Targetclock)) {
+	case "":
+		// In the absence of being told otherwise, we assume the CPU is clocked at 2MHz
+		return hc05.DEFAULT_CLOCK, nil
+	case "auto":
+		return 0, nil
+	}
+	clock, err := hc05.ParseClock(s.Targetclock)
+	if err != nil {
+		return 0, fmt.Errorf("targetclock: %w, or \"auto\" to probe it", err)
+	}
+	return clock, nil
+}
+
+// BaudRate returns the rate of the serial port for a crystal frequency, the baud setting when there is one
+func (s Settings) BaudRate(clock int) int {
+	if s.Baud != 0 {
+		return s.Baud
+	}
+	return hc05.BootloaderBaudRate(clock)
+}
+
+// Names of the applets that can be replaced
+var APPLET_NAMES = []string{applet.MEMREAD, applet.MEMBLOCK, applet.MEMWRITE, applet.MEMPROG, applet.GOTEST, applet.DEMO}
+
+// isApplet tells whether a name is one of APPLET_NAMES
+func isApplet(name string) bool {
+	for _, known := range APPLET_NAMES {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// findBoard looks a board up by name, case and spaces aside ("midon prog05", "MC68HC05PGMR")
+func findBoard(name string) (string, bool) {
+	simplify := func(s string) string { return strings.ToLower(strings.ReplaceAll(s, " ", "")) }
+	for board := range BOARDS {
+		if simplify(board) == simplify(name) {
+			return board, true
+		}
+	}
+	return "", false
+}
+
+// ProfileNames returns the names of the profiles in alphabetical order
+func ProfileNames(profiles map[string]Profile) []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
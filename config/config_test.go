@@ -0,0 +1,66 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sonikku2k/PROG05/hc05"
+)
+
+// writeConfig writes a configuration file in a temporary directory
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), FILE_NAME)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOverrides(t *testing.T) {
+	path := writeConfig(t, `{
+		"version": 1,
+		"port": "SIM",
+		"profile": "fast",
+		"profiles": {
+			"fast": { "board": "custom", "targetclock": "4MHz", "baud": 19200, "pacing": 10 }
+		}
+	}`)
+	zero := 0
+	baud := 4800
+	cases := []struct {
+		name   string
+		env    map[string]string
+		flags  Profile
+		baud   int
+		pacing int
+	}{
+		{"file", nil, Profile{}, 19200, 10},
+		{"environment", map[string]string{ENV_BAUD: "9600", ENV_PACING: "2"}, Profile{}, 9600, 2},
+		{"environment back to 0", map[string]string{ENV_BAUD: "0", ENV_PACING: "0"}, Profile{}, 0, 0},
+		{"flags over the environment", map[string]string{ENV_BAUD: "9600"}, Profile{Baud: &baud}, 4800, 10},
+		{"flags back to 0", map[string]string{ENV_PACING: "2"}, Profile{Baud: &zero, Pacing: &zero}, 0, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, name := range []string{ENV_PROFILE, ENV_BOARD, ENV_PORT, ENV_CLOCK, ENV_BAUD, ENV_PACING, ENV_ERASED} {
+				t.Setenv(name, c.env[name])
+			}
+			settings, err := Load(path, "", c.flags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if settings.Baud != c.baud || settings.Pacing != c.pacing {
+				t.Fatalf("baud %d pacing %d, want baud %d pacing %d", settings.Baud, settings.Pacing, c.baud, c.pacing)
+			}
+			rate := c.baud
+			if rate == 0 {
+				rate = hc05.BootloaderBaudRate(4000000)
+			}
+			if settings.BaudRate(4000000) != rate {
+				t.Fatalf("port rate %d, want %d", settings.BaudRate(4000000), rate)
+			}
+		})
+	}
+}
@@ -0,0 +1,577 @@
+// Package emulator executes HC05 code against a virtual MC68HC705C8 (CPU, memory map, SCI, ports, timer)
+package emulator
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sonikku2k/PROG05/hc05"
+)
+
+// Condition code register bits
+const (
+	CCR_C = 0x01 // Carry/borrow
+	CCR_Z = 0x02 // Zero
+	CCR_N = 0x04 // Negative
+	CCR_I = 0x08 // Interrupt mask
+	CCR_H = 0x10 // Half carry
+)
+
+// Interrupt vectors of the 68HC705C8
+const (
+	VECTOR_SPI   = 0x1FF4
+	VECTOR_SCI   = 0x1FF6
+	VECTOR_TIMER = 0x1FF8
+	VECTOR_IRQ   = 0x1FFA
+	VECTOR_SWI   = 0x1FFC
+	VECTOR_RESET = 0x1FFE
+)
+
+// Entry point of code uploaded through the bootloader
+const APPLET_ENTRY = 0x0051
+
+// IllegalOpcodeError is returned when the CPU fetches an opcode that is not in the HC05 instruction set
+type IllegalOpcodeError struct {
+	PC     uint16
+	Opcode byte
+}
+
+func (e *IllegalOpcodeError) Error() string {
+	return fmt.Sprintf("illegal opcode %02X at %04X", e.Opcode, e.PC)
+}
+
+// Chip is a virtual MC68HC705C8
+type Chip struct {
+	A, X, SP, CCR byte
+	PC            uint16
+	Cycles        uint64 // Bus cycles executed since reset
+	Halted        bool   // STOP or WAIT executed, only an interrupt wakes the CPU up
+
+	Memory     []byte  // Entire HC05 address space, I/O registers at $00-$1F are in Registers
+	IRQ        bool    // Level of the IRQ pin (true = high)
+	PortInputs [4]byte // Levels applied on the pins of ports A-D
+	Vpp        bool    // Programming voltage applied, the EPROM is only programmed while it is set
+
+	Trace io.Writer // Every instruction executed is printed here when set
+
+	io ioRegisters
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: NewChip
+// Function: Create a virtual HC05, every EPROM byte is erased and the CPU is held in reset
+// Parameters: Value of an erased EPROM byte
+// Returns: Pointer to the chip
+// -------------------------------------------------------------------------------------------------------------------
+func NewChip(erased byte) *Chip {
+
+	c := &Chip{
+		Memory: make([]byte, hc05.MEMORY_SIZE),
+		IRQ:    true,
+	}
+	for address := range c.Memory {
+		if hc05.IsPromAddress(uint16(address)) {
+			c.Memory[address] = erased
+		}
+	}
+	c.Reset(APPLET_ENTRY)
+	return c
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Reset
+// Function: Reset the CPU and the I/O registers, execution resumes at the given address
+// Parameters: Start address (the bootloader jumps to $0051, a user part would use the reset vector)
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) Reset(start uint16) {
+	c.A = 0
+	c.X = 0
+	c.SP = 0xFF
+	c.CCR = 0xE0 | CCR_I
+	c.PC = start & 0x1FFF
+	c.Cycles = 0
+	c.Halted = false
+	c.io.reset()
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: LoadApplet
+// Function: Do what the bootloader does with uploaded code: store it from $0051 and jump there
+// Parameters: Code as sent to the bootloader
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) LoadApplet(code []byte) {
+	copy(c.Memory[APPLET_ENTRY:0x100], code)
+	c.Start()
+}
+
+// Start resets the CPU at $0051 with the SCI left enabled by the bootloader
+func (c *Chip) Start() {
+	c.Reset(APPLET_ENTRY)
+	c.Write(SCCR2, SCCR2_TE|SCCR2_RE)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Read
+// Function: Read a byte of the memory map as the CPU sees it
+// Parameters: Address
+// Returns: Byte read
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) Read(address uint16) byte {
+
+	address &= 0x1FFF
+	if address < 0x20 {
+		return c.readRegister(address)
+	}
+	return c.Memory[address]
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Write
+// Function: Write a byte of the memory map as the CPU does, EPROM and ROM only change through the programming
+// sequence (LAT set in PROG, data written, EPGM pulsed with Vpp applied)
+//
+// Parameters: Address, Data
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) Write(address uint16, data byte) {
+
+	address &= 0x1FFF
+	switch {
+	case address < 0x20:
+		c.writeRegister(address, data)
+	case address == 0x1FDF:
+		// RAM0 and RAM1 are plain latches, the rest of OPTION is EPROM
+		if c.io.prog&PROG_LAT != 0 {
+			c.io.latch(address, data)
+		}
+		c.Memory[address] = c.Memory[address]&0x3F | data&0xC0
+	case c.isRam(address):
+		c.Memory[address] = data
+	case hc05.IsPromAddress(address):
+		if c.io.prog&PROG_LAT != 0 {
+			c.io.latch(address, data)
+		}
+	default:
+		// Bootloader ROM and unimplemented space
+	}
+}
+
+// isRam tells whether the address is RAM with the current RAM0/RAM1 bits of the OPTION register
+func (c *Chip) isRam(address uint16) bool {
+	return hc05.FindRegion(hc05.Layout(c.Memory[hc05.OPTION_ADDRESS]), address).Kind == hc05.REGION_RAM
+}
+
+func (c *Chip) fetch() byte {
+	b := c.Read(c.PC)
+	c.PC = (c.PC + 1) & 0x1FFF
+	return b
+}
+
+func (c *Chip) push(b byte) {
+	c.Memory[0xC0|uint16(c.SP&0x3F)] = b
+	c.SP = 0xC0 | (c.SP-1)&0x3F
+}
+
+func (c *Chip) pull() byte {
+	c.SP = 0xC0 | (c.SP+1)&0x3F
+	return c.Memory[0xC0|uint16(c.SP&0x3F)]
+}
+
+func (c *Chip) setFlag(flag byte, on bool) {
+	if on {
+		c.CCR |= flag
+	} else {
+		c.CCR &^= flag
+	}
+}
+
+// setNZ updates N and Z from a result
+func (c *Chip) setNZ(result byte) {
+	c.setFlag(CCR_N, result&0x80 != 0)
+	c.setFlag(CCR_Z, result == 0)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: interrupt
+// Function: Stack the CPU state and jump through a vector
+// Parameters: Vector address
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) interrupt(vector uint16) {
+	c.push(byte(c.PC))
+	c.push(byte(c.PC >> 8))
+	c.push(c.X)
+	c.push(c.A)
+	c.push(c.CCR)
+	c.CCR |= CCR_I
+	c.PC = (uint16(c.Read(vector))<<8 | uint16(c.Read(vector+1))) & 0x1FFF
+	c.Halted = false
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Run
+// Function: Execute instructions until the given number of bus cycles has elapsed
+// Parameters: Number of bus cycles
+// Returns: error if an illegal opcode was met
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) Run(cycles uint64) error {
+
+	end := c.Cycles + cycles
+	for c.Cycles < end {
+		err := c.Step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Step
+// Function: Execute one instruction (or one idle cycle while halted), then service pending interrupts
+// Returns: error if an illegal opcode was met
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) Step() error {
+
+	if c.Halted {
+		c.Cycles++
+		c.tick(1)
+		c.serviceInterrupts()
+		return nil
+	}
+
+	pc := c.PC
+	opcode := c.fetch()
+	entry := hc05.OPCODES[opcode]
+	if entry.Mnemonic == "" {
+		c.PC = pc
+		return &IllegalOpcodeError{pc, opcode}
+	}
+	if c.Trace != nil {
+		c.trace(pc, entry)
+	}
+
+	c.execute(opcode, entry)
+	c.Cycles += uint64(entry.Cycles)
+	c.tick(entry.Cycles)
+	c.serviceInterrupts()
+	return nil
+}
+
+// trace prints the instruction about to be executed and the CPU registers
+func (c *Chip) trace(pc uint16, entry hc05.Opcode) {
+	peek := func(address uint16) byte {
+		return c.Memory[address&0x1FFF]
+	}
+	var raw string
+	for n := 0; n < entry.Length(); n++ {
+		raw += fmt.Sprintf("%02X ", peek(pc+uint16(n)))
+	}
+	text, _ := hc05.Disassemble(peek, pc, nil)
+	fmt.Fprintf(c.Trace, "%04X  %-9s %-20s A=%02X X=%02X SP=%02X CCR=%02X CYC=%d\r\n",
+		pc, raw, text, c.A, c.X, c.SP, c.CCR, c.Cycles)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: effectiveAddress
+// Function: Fetch the operand of a memory instruction and compute the address it refers to
+// Parameters: Addressing mode
+// Returns: Address
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) effectiveAddress(mode int) uint16 {
+
+	switch mode {
+	case hc05.DIR:
+		return uint16(c.fetch())
+	case hc05.EXT:
+		high := uint16(c.fetch())
+		return high<<8 | uint16(c.fetch())
+	case hc05.IX:
+		return uint16(c.X)
+	case hc05.IX1:
+		return uint16(c.fetch()) + uint16(c.X)
+	case hc05.IX2:
+		high := uint16(c.fetch())
+		return (high<<8 | uint16(c.fetch())) + uint16(c.X)
+	}
+	return 0
+}
+
+// branch fetches a relative offset and takes it when the condition holds
+func (c *Chip) branch(condition bool) {
+	offset := int8(c.fetch())
+	if condition {
+		c.PC = uint16(int(c.PC)+int(offset)) & 0x1FFF
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: execute
+// Function: Execute an instruction whose opcode was fetched
+// Parameters: Opcode, its entry in the opcode map
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) execute(opcode byte, entry hc05.Opcode) {
+
+	high := opcode >> 4
+	low := opcode & 0x0F
+
+	switch {
+	case high == 0x0:
+		// BRSET/BRCLR: the bit tested is copied into C
+		address := uint16(c.fetch())
+		bit := c.Read(address)&(1<<(low>>1)) != 0
+		c.setFlag(CCR_C, bit)
+		c.branch(bit == (low&1 == 0))
+
+	case high == 0x1:
+		// BSET/BCLR
+		address := uint16(c.fetch())
+		if low&1 == 0 {
+			c.Write(address, c.Read(address)|1<<(low>>1))
+		} else {
+			c.Write(address, c.Read(address)&^(1<<(low>>1)))
+		}
+
+	case high == 0x2:
+		c.branch(c.condition(low))
+
+	case opcode == 0x42:
+		// MUL
+		result := uint16(c.X) * uint16(c.A)
+		c.X = byte(result >> 8)
+		c.A = byte(result)
+		c.setFlag(CCR_H, false)
+		c.setFlag(CCR_C, false)
+
+	case high >= 0x3 && high <= 0x7:
+		c.readModifyWrite(high, low, entry)
+
+	case high == 0x8 || high == 0x9:
+		c.control(opcode)
+
+	case opcode == 0xAD:
+		// BSR
+		offset := int8(c.fetch())
+		c.push(byte(c.PC))
+		c.push(byte(c.PC >> 8))
+		c.PC = uint16(int(c.PC)+int(offset)) & 0x1FFF
+
+	default:
+		c.registerMemory(low, entry)
+	}
+}
+
+// condition evaluates the condition of the branch in column 2
+func (c *Chip) condition(low byte) bool {
+
+	carry := c.CCR&CCR_C != 0
+	zero := c.CCR&CCR_Z != 0
+	var taken bool
+	switch low >> 1 {
+	case 0: // BRA/BRN
+		taken = true
+	case 1: // BHI/BLS
+		taken = !carry && !zero
+	case 2: // BCC/BCS
+		taken = !carry
+	case 3: // BNE/BEQ
+		taken = !zero
+	case 4: // BHCC/BHCS
+		taken = c.CCR&CCR_H == 0
+	case 5: // BPL/BMI
+		taken = c.CCR&CCR_N == 0
+	case 6: // BMC/BMS
+		taken = c.CCR&CCR_I == 0
+	case 7: // BIL/BIH
+		taken = !c.IRQ
+	}
+	// Odd opcodes test the opposite condition
+	if low&1 != 0 {
+		taken = !taken
+	}
+	return taken
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: readModifyWrite
+// Function: Execute an instruction of columns 3 to 7 (NEG, COM, LSR, ROR, ASR, LSL, ROL, DEC, INC, TST, CLR)
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) readModifyWrite(high byte, low byte, entry hc05.Opcode) {
+
+	var operand byte
+	var address uint16
+	switch high {
+	case 0x4:
+		operand = c.A
+	case 0x5:
+		operand = c.X
+	default:
+		address = c.effectiveAddress(entry.Mode)
+		operand = c.Read(address)
+	}
+
+	carry := c.CCR & CCR_C
+	var result byte
+	switch low {
+	case 0x0: // NEG
+		result = -operand
+		c.setFlag(CCR_C, result != 0)
+	case 0x3: // COM
+		result = ^operand
+		c.setFlag(CCR_C, true)
+	case 0x4: // LSR
+		result = operand >> 1
+		c.setFlag(CCR_C, operand&1 != 0)
+	case 0x6: // ROR
+		result = operand>>1 | carry<<7
+		c.setFlag(CCR_C, operand&1 != 0)
+	case 0x7: // ASR
+		result = operand&0x80 | operand>>1
+		c.setFlag(CCR_C, operand&1 != 0)
+	case 0x8: // LSL
+		result = operand << 1
+		c.setFlag(CCR_C, operand&0x80 != 0)
+	case 0x9: // ROL
+		result = operand<<1 | carry
+		c.setFlag(CCR_C, operand&0x80 != 0)
+	case 0xA: // DEC
+		result = operand - 1
+	case 0xC: // INC
+		result = operand + 1
+	case 0xD: // TST
+		c.setNZ(operand)
+		return
+	case 0xF: // CLR
+		result = 0
+	}
+	c.setNZ(result)
+
+	switch high {
+	case 0x4:
+		c.A = result
+	case 0x5:
+		c.X = result
+	default:
+		c.Write(address, result)
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: control
+// Function: Execute an instruction of columns 8 and 9 (RTI, RTS, SWI, STOP, WAIT, TAX, CLC, SEC, CLI, SEI, RSP...)
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) control(opcode byte) {
+
+	switch opcode {
+	case 0x80: // RTI
+		c.CCR = c.pull() | 0xE0
+		c.A = c.pull()
+		c.X = c.pull()
+		high := uint16(c.pull())
+		c.PC = (high<<8 | uint16(c.pull())) & 0x1FFF
+	case 0x81: // RTS
+		high := uint16(c.pull())
+		c.PC = (high<<8 | uint16(c.pull())) & 0x1FFF
+	case 0x83: // SWI
+		c.interrupt(VECTOR_SWI)
+	case 0x8E, 0x8F: // STOP, WAIT
+		c.setFlag(CCR_I, false)
+		c.Halted = true
+	case 0x97: // TAX
+		c.X = c.A
+	case 0x98: // CLC
+		c.setFlag(CCR_C, false)
+	case 0x99: // SEC
+		c.setFlag(CCR_C, true)
+	case 0x9A: // CLI
+		c.setFlag(CCR_I, false)
+	case 0x9B: // SEI
+		c.setFlag(CCR_I, true)
+	case 0x9C: // RSP
+		c.SP = 0xFF
+	case 0x9D: // NOP
+	case 0x9F: // TXA
+		c.A = c.X
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: registerMemory
+// Function: Execute an instruction of columns A to F (SUB, CMP, SBC, CPX, AND, BIT, LDA, STA, EOR, ADC, ORA, ADD,
+// JMP, JSR, LDX, STX)
+//
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) registerMemory(low byte, entry hc05.Opcode) {
+
+	var address uint16
+	var operand byte
+	if entry.Mode == hc05.IMM {
+		operand = c.fetch()
+	} else {
+		address = c.effectiveAddress(entry.Mode)
+	}
+	// Stores and jumps do not read their operand
+	if entry.Mode != hc05.IMM && low != 0x7 && low != 0xC && low != 0xD && low != 0xF {
+		operand = c.Read(address)
+	}
+
+	switch low {
+	case 0x0: // SUB
+		c.A = c.subtract(c.A, operand, 0)
+	case 0x1: // CMP
+		c.subtract(c.A, operand, 0)
+	case 0x2: // SBC
+		c.A = c.subtract(c.A, operand, c.CCR&CCR_C)
+	case 0x3: // CPX
+		c.subtract(c.X, operand, 0)
+	case 0x4: // AND
+		c.A &= operand
+		c.setNZ(c.A)
+	case 0x5: // BIT
+		c.setNZ(c.A & operand)
+	case 0x6: // LDA
+		c.A = operand
+		c.setNZ(c.A)
+	case 0x7: // STA
+		c.Write(address, c.A)
+		c.setNZ(c.A)
+	case 0x8: // EOR
+		c.A ^= operand
+		c.setNZ(c.A)
+	case 0x9: // ADC
+		c.A = c.add(c.A, operand, c.CCR&CCR_C)
+	case 0xA: // ORA
+		c.A |= operand
+		c.setNZ(c.A)
+	case 0xB: // ADD
+		c.A = c.add(c.A, operand, 0)
+	case 0xC: // JMP
+		c.PC = address & 0x1FFF
+	case 0xD: // JSR
+		c.push(byte(c.PC))
+		c.push(byte(c.PC >> 8))
+		c.PC = address & 0x1FFF
+	case 0xE: // LDX
+		c.X = operand
+		c.setNZ(c.X)
+	case 0xF: // STX
+		c.Write(address, c.X)
+		c.setNZ(c.X)
+	}
+}
+
+// add returns a + b + carry and updates H, N, Z, C
+func (c *Chip) add(a byte, b byte, carry byte) byte {
+	sum := uint16(a) + uint16(b) + uint16(carry)
+	result := byte(sum)
+	c.setFlag(CCR_H, (a&0x0F)+(b&0x0F)+carry > 0x0F)
+	c.setFlag(CCR_C, sum > 0xFF)
+	c.setNZ(result)
+	return result
+}
+
+// subtract returns a - b - borrow and updates N, Z, C
+func (c *Chip) subtract(a byte, b byte, borrow byte) byte {
+	result := a - b - borrow
+	c.setFlag(CCR_C, uint16(b)+uint16(borrow) > uint16(a))
+	c.setNZ(result)
+	return result
+}
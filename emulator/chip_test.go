@@ -0,0 +1,238 @@
+package emulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sonikku2k/PROG05/srec"
+)
+
+// Flags compared by the instruction tests, I is left set so no interrupt is taken
+const FLAGS = CCR_H | CCR_N | CCR_Z | CCR_C
+
+// newCPU returns a chip about to execute code stored at $0051
+func newCPU(t *testing.T, code ...byte) *Chip {
+	t.Helper()
+	c := NewChip(0)
+	c.LoadApplet(code)
+	return c
+}
+
+// step executes one instruction
+func step(t *testing.T, c *Chip) {
+	t.Helper()
+	if err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	cases := []struct {
+		name  string
+		a, x  byte
+		flags byte // Flags before the instruction
+		code  []byte
+		wantA byte
+		wantX byte
+		want  byte // Flags after it
+	}{
+		{"ADD half carry", 0x0F, 0, 0, []byte{0xAB, 0x01}, 0x10, 0, CCR_H},
+		{"ADD carry", 0xFF, 0, 0, []byte{0xAB, 0x01}, 0x00, 0, CCR_H | CCR_Z | CCR_C},
+		{"ADC", 0x10, 0, CCR_C, []byte{0xA9, 0x01}, 0x12, 0, 0},
+		{"SUB borrow", 0x00, 0, 0, []byte{0xA0, 0x01}, 0xFF, 0, CCR_N | CCR_C},
+		{"SBC", 0x10, 0, CCR_C, []byte{0xA2, 0x0F}, 0x00, 0, CCR_Z},
+		{"CMP keeps A and H", 0x42, 0, CCR_H, []byte{0xA1, 0x42}, 0x42, 0, CCR_H | CCR_Z},
+		{"CPX", 0, 0x10, 0, []byte{0xA3, 0x20}, 0, 0x10, CCR_N | CCR_C},
+		{"NEGA", 0x01, 0, 0, []byte{0x40}, 0xFF, 0, CCR_N | CCR_C},
+		{"NEGA of zero", 0x00, 0, CCR_C, []byte{0x40}, 0x00, 0, CCR_Z},
+		{"COMX", 0, 0x0F, 0, []byte{0x53}, 0, 0xF0, CCR_N | CCR_C},
+		{"LSRA", 0x01, 0, 0, []byte{0x44}, 0x00, 0, CCR_Z | CCR_C},
+		{"RORA", 0x02, 0, CCR_C, []byte{0x46}, 0x81, 0, CCR_N},
+		{"ASRA", 0x81, 0, 0, []byte{0x47}, 0xC0, 0, CCR_N | CCR_C},
+		{"LSLA", 0x80, 0, 0, []byte{0x48}, 0x00, 0, CCR_Z | CCR_C},
+		{"ROLA", 0x40, 0, CCR_C, []byte{0x49}, 0x81, 0, CCR_N},
+		{"DECX keeps C", 0, 0x00, CCR_C, []byte{0x5A}, 0, 0xFF, CCR_N | CCR_C},
+		{"INCA", 0x7F, 0, 0, []byte{0x4C}, 0x80, 0, CCR_N},
+		{"TSTA keeps C", 0x00, 0, CCR_C, []byte{0x4D}, 0x00, 0, CCR_Z | CCR_C},
+		{"CLRX", 0, 0x55, CCR_N, []byte{0x5F}, 0, 0x00, CCR_Z},
+		{"LDA", 0, 0, CCR_Z, []byte{0xA6, 0x80}, 0x80, 0, CCR_N},
+		{"AND", 0xF0, 0, 0, []byte{0xA4, 0x0F}, 0x00, 0, CCR_Z},
+		{"EOR", 0xFF, 0, 0, []byte{0xA8, 0x0F}, 0xF0, 0, CCR_N},
+		{"BIT keeps A", 0x81, 0, 0, []byte{0xA5, 0x80}, 0x81, 0, CCR_N},
+		{"TAX", 0x33, 0, 0, []byte{0x97}, 0x33, 0x33, 0},
+		{"SEC", 0, 0, 0, []byte{0x99}, 0, 0, CCR_C},
+		{"MUL", 0x12, 0x34, 0, []byte{0x42}, 0xA8, 0x03, 0},
+		{"MUL clears H and C only", 0xFF, 0xFF, FLAGS, []byte{0x42}, 0x01, 0xFE, CCR_N | CCR_Z},
+	}
+	for _, c := range cases {
+		cpu := newCPU(t, c.code...)
+		cpu.A, cpu.X = c.a, c.x
+		cpu.CCR = cpu.CCR&^FLAGS | c.flags
+		step(t, cpu)
+		if cpu.A != c.wantA || cpu.X != c.wantX || cpu.CCR&FLAGS != c.want {
+			t.Errorf("%s: A=%02X X=%02X flags %02X, want A=%02X X=%02X flags %02X",
+				c.name, cpu.A, cpu.X, cpu.CCR&FLAGS, c.wantA, c.wantX, c.want)
+		}
+		if cpu.PC != APPLET_ENTRY+uint16(len(c.code)) {
+			t.Errorf("%s: PC %04X after a %d byte instruction", c.name, cpu.PC, len(c.code))
+		}
+	}
+}
+
+func TestBitInstructions(t *testing.T) {
+	cases := []struct {
+		name   string
+		memory byte // Byte at $80
+		code   []byte
+		pc     uint16 // Address of the next instruction
+		carry  bool
+		result byte // Byte at $80 afterwards
+	}{
+		{"BRSET taken", 0x08, []byte{0x06, 0x80, 0x10}, 0x0064, true, 0x08},
+		{"BRSET not taken", 0xF7, []byte{0x06, 0x80, 0x10}, 0x0054, false, 0xF7},
+		{"BRCLR taken", 0xF7, []byte{0x07, 0x80, 0x10}, 0x0064, false, 0xF7},
+		{"BRCLR not taken", 0x08, []byte{0x07, 0x80, 0x10}, 0x0054, true, 0x08},
+		{"BRSET backwards", 0x01, []byte{0x00, 0x80, 0xFD}, 0x0051, true, 0x01},
+		{"BSET", 0x00, []byte{0x1E, 0x80}, 0x0053, false, 0x80},
+		{"BCLR", 0xFF, []byte{0x11, 0x80}, 0x0053, false, 0xFE},
+	}
+	for _, c := range cases {
+		cpu := newCPU(t, c.code...)
+		cpu.Memory[0x80] = c.memory
+		step(t, cpu)
+		if cpu.PC != c.pc {
+			t.Errorf("%s: PC %04X, want %04X", c.name, cpu.PC, c.pc)
+		}
+		if c.code[0] < 0x10 && (cpu.CCR&CCR_C != 0) != c.carry {
+			t.Errorf("%s: C %t, want the bit tested (%t)", c.name, cpu.CCR&CCR_C != 0, c.carry)
+		}
+		if cpu.Memory[0x80] != c.result {
+			t.Errorf("%s: $80 holds %02X, want %02X", c.name, cpu.Memory[0x80], c.result)
+		}
+	}
+}
+
+func TestBranches(t *testing.T) {
+	cases := []struct {
+		name   string
+		opcode byte
+		flags  byte
+		irq    bool
+		taken  bool
+	}{
+		{"BRA", 0x20, 0, true, true},
+		{"BRN", 0x21, 0, true, false},
+		{"BHI", 0x22, 0, true, true},
+		{"BLS on Z", 0x23, CCR_Z, true, true},
+		{"BCC on C", 0x24, CCR_C, true, false},
+		{"BCS", 0x25, CCR_C, true, true},
+		{"BNE on Z", 0x26, CCR_Z, true, false},
+		{"BEQ", 0x27, CCR_Z, true, true},
+		{"BHCS", 0x29, CCR_H, true, true},
+		{"BPL on N", 0x2A, CCR_N, true, false},
+		{"BMI", 0x2B, CCR_N, true, true},
+		{"BMS with I set", 0x2D, 0, true, true},
+		{"BIL on a high pin", 0x2E, 0, true, false},
+		{"BIH", 0x2F, 0, true, true},
+		{"BIL", 0x2E, 0, false, true},
+	}
+	for _, c := range cases {
+		cpu := newCPU(t, c.opcode, 0x20)
+		cpu.CCR = cpu.CCR&^FLAGS | c.flags
+		cpu.IRQ = c.irq
+		step(t, cpu)
+		want := uint16(0x0053)
+		if c.taken {
+			want += 0x20
+		}
+		if cpu.PC != want {
+			t.Errorf("%s: PC %04X, want %04X", c.name, cpu.PC, want)
+		}
+	}
+}
+
+func TestSwiRti(t *testing.T) {
+	// SWI at $0051, the handler at $0100 is a lone RTI
+	cpu := newCPU(t, 0x83)
+	cpu.Memory[VECTOR_SWI] = 0x01
+	cpu.Memory[VECTOR_SWI+1] = 0x00
+	cpu.Memory[0x0100] = 0x80
+	cpu.A, cpu.X = 0x11, 0x22
+	cpu.CCR = 0xE0 | CCR_C
+
+	step(t, cpu)
+	if cpu.PC != 0x0100 || cpu.SP != 0xFA || cpu.CCR&CCR_I == 0 {
+		t.Fatalf("after SWI PC=%04X SP=%02X CCR=%02X, want PC=0100 SP=FA and I set", cpu.PC, cpu.SP, cpu.CCR)
+	}
+	// PCL, PCH, X, A then CCR, from $00FF down
+	stack := []byte{0x52, 0x00, 0x22, 0x11, 0xE1}
+	for n, want := range stack {
+		if got := cpu.Memory[0xFF-n]; got != want {
+			t.Errorf("stack $%02X holds %02X, want %02X", 0xFF-n, got, want)
+		}
+	}
+
+	cpu.A, cpu.X = 0, 0
+	step(t, cpu)
+	if cpu.PC != 0x0052 || cpu.SP != 0xFF || cpu.A != 0x11 || cpu.X != 0x22 || cpu.CCR != 0xE1 {
+		t.Fatalf("after RTI PC=%04X SP=%02X A=%02X X=%02X CCR=%02X, want PC=0052 SP=FF A=11 X=22 CCR=E1",
+			cpu.PC, cpu.SP, cpu.A, cpu.X, cpu.CCR)
+	}
+}
+
+func TestSubroutine(t *testing.T) {
+	// JSR $0060 at $0051, RTS at $0060
+	cpu := newCPU(t, 0xBD, 0x60)
+	cpu.Memory[0x60] = 0x81
+
+	step(t, cpu)
+	if cpu.PC != 0x0060 || cpu.Memory[0xFF] != 0x53 || cpu.Memory[0xFE] != 0x00 {
+		t.Fatalf("after JSR PC=%04X, stack %02X %02X", cpu.PC, cpu.Memory[0xFE], cpu.Memory[0xFF])
+	}
+	step(t, cpu)
+	if cpu.PC != 0x0053 || cpu.SP != 0xFF {
+		t.Fatalf("after RTS PC=%04X SP=%02X, want PC=0053 SP=FF", cpu.PC, cpu.SP)
+	}
+}
+
+func TestIllegalOpcode(t *testing.T) {
+	cpu := newCPU(t, 0x31)
+	err := cpu.Step()
+	illegal, ok := err.(*IllegalOpcodeError)
+	if !ok || illegal.PC != APPLET_ENTRY || illegal.Opcode != 0x31 {
+		t.Fatalf("error %v, want an illegal opcode 31 at 0051", err)
+	}
+}
+
+// The gotest applet goes through the bootloader model, then the CPU runs it and its banner comes out of the SCI
+func TestGotestBanner(t *testing.T) {
+	var code []byte
+	_, err := srec.Load("../srec/hc05_gotest.s19", func(address uint16, data byte) error {
+		for len(code) <= int(address-APPLET_ENTRY) {
+			code = append(code, 0)
+		}
+		code[address-APPLET_ENTRY] = data
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := NewTarget(2000000, 0)
+	upload := append([]byte{byte(len(code) + 1)}, code...)
+	for _, b := range upload {
+		if err := target.WriteByte(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	banner, err := target.ReadN(5, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(banner) != "HC05\r" {
+		t.Fatalf("banner %q, want \"HC05\\r\"", banner)
+	}
+	if target.Fault != nil {
+		t.Fatal(target.Fault)
+	}
+}
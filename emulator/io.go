@@ -0,0 +1,255 @@
+package emulator
+
+// I/O register addresses of the 68HC705C8
+const (
+	PORTA  = 0x00
+	PORTB  = 0x01
+	PORTC  = 0x02
+	PORTD  = 0x03 // Input only
+	DDRA   = 0x04
+	DDRB   = 0x05
+	DDRC   = 0x06
+	SPCR   = 0x0A
+	SPSR   = 0x0B
+	SPDR   = 0x0C
+	BAUD   = 0x0D
+	SCCR1  = 0x0E
+	SCCR2  = 0x0F
+	SCSR   = 0x10
+	SCDAT  = 0x11
+	TCR    = 0x12
+	TSR    = 0x13
+	ICRH   = 0x14
+	ICRL   = 0x15
+	OCRH   = 0x16
+	OCRL   = 0x17
+	TRH    = 0x18
+	TRL    = 0x19
+	ACRH   = 0x1A
+	ACRL   = 0x1B
+	PROG   = 0x1C
+	COPRST = 0x1D
+	COPCR  = 0x1E
+)
+
+// SCI bits
+const (
+	SCCR2_TIE  = 0x80
+	SCCR2_TCIE = 0x40
+	SCCR2_RIE  = 0x20
+	SCCR2_TE   = 0x08
+	SCCR2_RE   = 0x04
+	SCSR_TDRE  = 0x80
+	SCSR_TC    = 0x40
+	SCSR_RDRF  = 0x20
+)
+
+// Timer bits
+const (
+	TCR_OCIE = 0x40
+	TCR_TOIE = 0x20
+	TSR_OCF  = 0x40
+	TSR_TOF  = 0x20
+)
+
+// PROG register bits
+const (
+	PROG_EPGM = 0x01
+	PROG_LAT  = 0x04
+)
+
+// Number of bus cycles per timer count
+const TIMER_PRESCALER = 4
+
+// ioRegisters holds the state behind the registers at $00-$1F
+type ioRegisters struct {
+	registers [32]byte // Plain read/write registers
+
+	scirx []byte // Bytes received by the SCI, the first one is in SCDAT
+	scitx []byte // Bytes transmitted by the SCI
+
+	counter    uint16 // Free running timer counter
+	prescaler  int
+	tsrread    bool // TSR was read, the next access to OCRL/TRL clears the flag
+	tsr        byte
+	counterlow byte // TRL latched when TRH is read
+
+	prog         byte
+	latchaddress uint16
+	latchdata    byte
+	latched      bool
+}
+
+func (r *ioRegisters) reset() {
+	r.registers = [32]byte{}
+	r.scirx = nil
+	r.counter = 0xFFFC
+	r.prescaler = 0
+	r.tsr = 0
+	r.tsrread = false
+	r.prog = 0
+	r.latched = false
+}
+
+// latch records the address and data written while LAT is set
+func (r *ioRegisters) latch(address uint16, data byte) {
+	r.latchaddress = address
+	r.latchdata = data
+	r.latched = true
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: readRegister
+// Function: Read an I/O register
+// Parameters: Address ($00-$1F)
+// Returns: Register value
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) readRegister(address uint16) byte {
+
+	r := &c.io
+	switch address {
+	case PORTA, PORTB, PORTC:
+		// Output pins read their latch, input pins read the level applied
+		ddr := r.registers[DDRA+address]
+		return r.registers[address]&ddr | c.PortInputs[address]&^ddr
+	case PORTD:
+		return c.PortInputs[3]
+	case SCSR:
+		status := byte(SCSR_TDRE | SCSR_TC)
+		if len(r.scirx) > 0 {
+			status |= SCSR_RDRF
+		}
+		return status
+	case SCDAT:
+		if len(r.scirx) == 0 {
+			return r.registers[SCDAT]
+		}
+		data := r.scirx[0]
+		r.scirx = r.scirx[1:]
+		r.registers[SCDAT] = data
+		return data
+	case TSR:
+		r.tsrread = true
+		return r.tsr
+	case TRH, ACRH:
+		r.counterlow = byte(r.counter)
+		return byte(r.counter >> 8)
+	case TRL:
+		if r.tsrread {
+			r.tsr &^= TSR_TOF
+			r.tsrread = false
+		}
+		return r.counterlow
+	case ACRL:
+		return r.counterlow
+	case PROG:
+		return r.prog
+	}
+	return r.registers[address]
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: writeRegister
+// Function: Write an I/O register
+// Parameters: Address ($00-$1F), Data
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) writeRegister(address uint16, data byte) {
+
+	r := &c.io
+	switch address {
+	case SCDAT:
+		if r.registers[SCCR2]&SCCR2_TE != 0 {
+			r.scitx = append(r.scitx, data)
+		}
+	case SCSR, TSR, ICRH, ICRL, TRH, TRL, ACRH, ACRL, PORTD:
+		// Read only
+	case OCRL:
+		r.registers[OCRL] = data
+		if r.tsrread {
+			r.tsr &^= TSR_OCF
+			r.tsrread = false
+		}
+	case PROG:
+		// The programming pulse happens when EPGM is set with the data latched and Vpp applied
+		if data&PROG_EPGM != 0 && r.prog&PROG_EPGM == 0 && r.prog&PROG_LAT != 0 && r.latched && c.Vpp {
+			c.Memory[r.latchaddress] |= r.latchdata
+		}
+		if data&PROG_LAT == 0 {
+			r.latched = false
+		}
+		r.prog = data & (PROG_LAT | PROG_EPGM)
+	default:
+		r.registers[address] = data
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: tick
+// Function: Advance the timer by a number of bus cycles
+// Parameters: Number of bus cycles
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) tick(cycles int) {
+
+	r := &c.io
+	r.prescaler += cycles
+	for r.prescaler >= TIMER_PRESCALER {
+		r.prescaler -= TIMER_PRESCALER
+		r.counter++
+		if r.counter == 0 {
+			r.tsr |= TSR_TOF
+		}
+		if r.counter == uint16(r.registers[OCRH])<<8|uint16(r.registers[OCRL]) {
+			r.tsr |= TSR_OCF
+		}
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: serviceInterrupts
+// Function: Take the highest priority pending interrupt if the I bit allows it
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) serviceInterrupts() {
+
+	if c.CCR&CCR_I != 0 {
+		return
+	}
+	r := &c.io
+	tcr := r.registers[TCR]
+	sccr2 := r.registers[SCCR2]
+	switch {
+	case tcr&TCR_OCIE != 0 && r.tsr&TSR_OCF != 0, tcr&TCR_TOIE != 0 && r.tsr&TSR_TOF != 0:
+		c.interrupt(VECTOR_TIMER)
+		c.Cycles += 10
+	case sccr2&SCCR2_RIE != 0 && len(r.scirx) > 0, sccr2&(SCCR2_TIE|SCCR2_TCIE) != 0:
+		// The transmitter is always empty, TIE/TCIE interrupt as soon as they are enabled
+		c.interrupt(VECTOR_SCI)
+		c.Cycles += 10
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ReceiveByte
+// Function: A byte arrives on the SCI receive pin, it is dropped unless the receiver is enabled
+// Parameters: Data
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) ReceiveByte(data byte) {
+	if c.io.registers[SCCR2]&SCCR2_RE != 0 {
+		c.io.scirx = append(c.io.scirx, data)
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Transmitted
+// Function: Collect the bytes sent by the SCI since the last call
+// Returns: Bytes transmitted
+// -------------------------------------------------------------------------------------------------------------------
+func (c *Chip) Transmitted() []byte {
+	data := c.io.scitx
+	c.io.scitx = nil
+	return data
+}
+
+// Port returns the level driven on the pins of port A, B or C (0, 1 or 2), inputs read as 0
+func (c *Chip) Port(n int) byte {
+	return c.io.registers[PORTA+n] & c.io.registers[DDRA+n]
+}
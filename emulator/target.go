@@ -0,0 +1,169 @@
+package emulator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sonikku2k/PROG05/bootloader"
+	"github.com/sonikku2k/PROG05/hc05"
+)
+
+// States of the emulated target
+const (
+	stateLoaderLength = iota // Bootloader waiting for the length byte
+	stateLoaderCode          // Bootloader storing code from $0051
+	stateRunning             // CPU executing from $0051
+)
+
+// Longest stretch of time emulated in one go, so a long pause of the host does not freeze it for ages
+const MAX_CATCH_UP = 1 * time.Second
+
+// Target puts a Chip behind bootloader.Transport: the mask ROM loader is modelled byte for byte, then the
+// uploaded code runs on the emulated CPU in step with the wall clock
+type Target struct {
+	Chip      *Chip
+	Frequency int   // Bus frequency in Hz (crystal frequency / 2)
+	Fault     error // Set when the CPU met an illegal opcode, execution stops there
+	Baud      int   // SCI rate of the emulated HC05 (0: the host may use any rate)
+
+	mu       sync.Mutex
+	state    int
+	length   int
+	loaded   int
+	last     time.Time
+	rxbuffer []byte
+	host     int // Baud rate set by the host, 0 until SetBaudRate is called
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: NewTarget
+// Function: Create an emulated HC05 sitting in the bootloader
+// Parameters: Bus frequency in Hz, value of an erased EPROM byte
+// Returns: Pointer to the target
+// -------------------------------------------------------------------------------------------------------------------
+func NewTarget(frequency int, erased byte) *Target {
+	return &Target{
+		Chip:      NewChip(erased),
+		Frequency: frequency,
+	}
+}
+
+// ResetTarget implements bootloader.Resetter, the target goes back to the loader
+func (t *Target) ResetTarget() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = stateLoaderLength
+	t.Fault = nil
+	return nil
+}
+
+// SetBaudRate implements bootloader.BaudRateSetter, bytes sent at a rate the emulated SCI does not match are lost
+func (t *Target) SetBaudRate(baudrate int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.host = baudrate
+	return nil
+}
+
+// WriteByte receives a byte on the emulated SCI
+func (t *Target) WriteByte(b byte) error {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Baud != 0 && t.host != 0 && !hc05.BaudRatesMatch(t.host, t.Baud) {
+		return nil // Framing error, the SCI drops the byte
+	}
+	switch t.state {
+	case stateLoaderLength:
+		// The length byte counts itself
+		t.length = int(b) - 1
+		t.loaded = 0
+		t.state = stateLoaderCode
+		if t.length <= 0 {
+			t.start()
+		}
+	case stateLoaderCode:
+		t.Chip.Memory[APPLET_ENTRY+t.loaded] = b
+		t.loaded++
+		if t.loaded == t.length {
+			t.start()
+		}
+	case stateRunning:
+		t.advance()
+		t.Chip.ReceiveByte(b)
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ReadN
+// Function: Wait for bytes sent by the target, the CPU is run every millisecond meanwhile
+// Parameters: Number of bytes, longest time to wait for all of them
+// Returns: Bytes, or on timeout the bytes that did arrive with a *bootloader.TimeoutError
+// -------------------------------------------------------------------------------------------------------------------
+func (t *Target) ReadN(n int, timeout time.Duration) ([]byte, error) {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		t.mu.Lock()
+		t.advance()
+		if len(t.rxbuffer) >= n {
+			data := t.take(n)
+			t.mu.Unlock()
+			return data, nil
+		}
+		if time.Now().After(deadline) {
+			data := t.take(len(t.rxbuffer))
+			t.mu.Unlock()
+			return data, &bootloader.TimeoutError{Wanted: n, Received: len(data), Timeout: timeout}
+		}
+		t.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Flush empties the bytes sent by the target
+func (t *Target) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.advance()
+	t.rxbuffer = t.rxbuffer[:0]
+}
+
+// take removes n bytes from the reception buffer, the lock must be held
+func (t *Target) take(n int) []byte {
+	data := append([]byte(nil), t.rxbuffer[:n]...)
+	t.rxbuffer = append(t.rxbuffer[:0], t.rxbuffer[n:]...)
+	return data
+}
+
+// Close does nothing, it is there to satisfy bootloader.Transport
+func (t *Target) Close() error {
+	return nil
+}
+
+// start jumps to the uploaded code
+func (t *Target) start() {
+	t.Chip.Start()
+	t.state = stateRunning
+	t.last = time.Now()
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: advance
+// Function: Run the CPU for the time elapsed since the last call and collect what the SCI sent
+// -------------------------------------------------------------------------------------------------------------------
+func (t *Target) advance() {
+
+	if t.state != stateRunning || t.Fault != nil {
+		return
+	}
+	now := time.Now()
+	elapsed := now.Sub(t.last)
+	if elapsed > MAX_CATCH_UP {
+		elapsed = MAX_CATCH_UP
+	}
+	t.last = now
+	t.Fault = t.Chip.Run(uint64(elapsed.Seconds() * float64(t.Frequency)))
+	t.rxbuffer = append(t.rxbuffer, t.Chip.Transmitted()...)
+}
@@ -0,0 +1,88 @@
+package hc05
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The bootloader sets the SCI prescaler to 13 and the rate divider to 1, the SCI samples each bit 16 times and the
+// bus runs at half the crystal: 4808 baud with a 2MHz crystal, 9615 baud with a 4MHz crystal
+const BOOTLOADER_BAUD_DIVIDER = 2 * 13 * 16
+
+// Crystal frequency assumed when the configuration does not give one (MC68HC05PGMR and MIDON PROG05 boards)
+const DEFAULT_CLOCK = 2000000
+
+// Crystal frequencies accepted by the 68HC705C8 (bus clock up to 2.1MHz)
+const MIN_CLOCK = 100000
+const MAX_CLOCK = 4200000
+
+// Crystals tried in turn by the clock probe
+var PROBE_CLOCKS = []int{2000000, 4000000}
+
+// Largest difference between two baud rates, in percent, for an SCI to still receive the bytes of the other
+const BAUD_TOLERANCE = 3
+
+// Standard rates used in place of the exact SCI rate when close enough, every USB-to-serial converter supports them
+var STANDARD_BAUD_RATES = []int{1200, 2400, 4800, 9600, 19200}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ParseClock
+// Function: Read a crystal frequency: "4MHz", "3.6864 MHz", "4194.304kHz" or "2000000" (Hz)
+// Parameters: Text
+// Returns: Frequency in Hz, error if the text is not a frequency the 68HC705C8 can run at
+// -------------------------------------------------------------------------------------------------------------------
+func ParseClock(text string) (int, error) {
+
+	number := strings.ToUpper(strings.ReplaceAll(text, " ", ""))
+	scale := 1.0
+	switch {
+	case strings.HasSuffix(number, "MHZ"):
+		number, scale = strings.TrimSuffix(number, "MHZ"), 1e6
+	case strings.HasSuffix(number, "KHZ"):
+		number, scale = strings.TrimSuffix(number, "KHZ"), 1e3
+	case strings.HasSuffix(number, "HZ"):
+		number = strings.TrimSuffix(number, "HZ")
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("\"%s\" is not a clock frequency (e.g. 2MHz, 4MHz or 3.6864MHz)", text)
+	}
+	clock := int(value*scale + 0.5)
+	if clock < MIN_CLOCK || clock > MAX_CLOCK {
+		return 0, fmt.Errorf("clock frequency %s out of the range of the 68HC705C8 (%s - %s)", text, FormatClock(MIN_CLOCK), FormatClock(MAX_CLOCK))
+	}
+	return clock, nil
+}
+
+// FormatClock writes a crystal frequency in MHz, e.g. "4MHz" or "3.6864MHz"
+func FormatClock(clock int) string {
+	return strconv.FormatFloat(float64(clock)/1e6, 'f', -1, 64) + "MHz"
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: BootloaderBaudRate
+// Function: Work out the SCI rate of the bootloader from the crystal frequency, a standard rate within the tolerance
+// of the SCI is used instead of the exact rate
+// Parameters: Crystal frequency in Hz
+// Returns: Baud rate
+// -------------------------------------------------------------------------------------------------------------------
+func BootloaderBaudRate(clock int) int {
+
+	baudrate := (clock + BOOTLOADER_BAUD_DIVIDER/2) / BOOTLOADER_BAUD_DIVIDER
+	for _, standard := range STANDARD_BAUD_RATES {
+		if BaudRatesMatch(baudrate, standard) {
+			return standard
+		}
+	}
+	return baudrate
+}
+
+// BaudRatesMatch tells whether two ends of a serial link running at these rates understand each other
+func BaudRatesMatch(a int, b int) bool {
+	difference := a - b
+	if difference < 0 {
+		difference = -difference
+	}
+	return difference*100 <= b*BAUD_TOLERANCE
+}
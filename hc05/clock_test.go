@@ -0,0 +1,49 @@
+package hc05
+
+import "testing"
+
+func TestParseClock(t *testing.T) {
+	cases := []struct {
+		text  string
+		clock int // 0 for an error
+	}{
+		{"2MHz", 2000000},
+		{"4mhz", 4000000},
+		{"3.6864 MHz", 3686400},
+		{"4194.304kHz", 4194304},
+		{"2000000", 2000000},
+		{"2000000Hz", 2000000},
+		{"", 0},
+		{"fast", 0},
+		{"4,000MHz", 0},
+		{"8MHz", 0},  // Above the bus clock of the part
+		{"32kHz", 0}, // Below it
+	}
+	for _, c := range cases {
+		clock, err := ParseClock(c.text)
+		if c.clock == 0 && err == nil {
+			t.Errorf("%q: %d accepted, want an error", c.text, clock)
+		}
+		if c.clock != 0 && (err != nil || clock != c.clock) {
+			t.Errorf("%q: %d, %v, want %d", c.text, clock, err, c.clock)
+		}
+	}
+}
+
+func TestBootloaderBaudRate(t *testing.T) {
+	cases := []struct {
+		clock    int
+		baudrate int
+	}{
+		{2000000, 4800}, // 4808 baud, a standard rate is close enough
+		{4000000, 9600}, // 9615 baud
+		{3686400, 8862}, // No standard rate within the tolerance of the SCI
+		{1000000, 2400},
+		{4194304, 10082},
+	}
+	for _, c := range cases {
+		if baudrate := BootloaderBaudRate(c.clock); baudrate != c.baudrate {
+			t.Errorf("%s: %d baud, want %d", FormatClock(c.clock), baudrate, c.baudrate)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package hc05
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// REGISTER_NAMES maps the addresses of the 68HC705C8 registers to their names
+var REGISTER_NAMES = map[uint16]string{
+	0x00: "PORTA", 0x01: "PORTB", 0x02: "PORTC", 0x03: "PORTD",
+	0x04: "DDRA", 0x05: "DDRB", 0x06: "DDRC",
+	0x0A: "SPCR", 0x0B: "SPSR", 0x0C: "SPDR",
+	0x0D: "BAUD", 0x0E: "SCCR1", 0x0F: "SCCR2", 0x10: "SCSR", 0x11: "SCDAT",
+	0x12: "TCR", 0x13: "TSR", 0x14: "ICRH", 0x15: "ICRL", 0x16: "OCRH", 0x17: "OCRL",
+	0x18: "TRH", 0x19: "TRL", 0x1A: "ACRH", 0x1B: "ACRL",
+	0x1C: "PROG", 0x1D: "COPRST", 0x1E: "COPCR",
+	0x1FDF: "OPTION", 0x1FF0: "MOR1", 0x1FF1: "MOR2",
+}
+
+// VECTOR_NAMES maps the interrupt vectors of the 68HC705C8 to the name given to their target
+var VECTOR_NAMES = map[uint16]string{
+	0x1FF4: "SPI",
+	0x1FF6: "SCI",
+	0x1FF8: "TIMER",
+	0x1FFA: "IRQ",
+	0x1FFC: "SWI",
+	0x1FFE: "RESET",
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: VectorLabels
+// Function: Build labels for the targets of the interrupt vectors found in a memory image
+// Parameters: Function reading a byte of the image
+// Returns: Labels by address
+// -------------------------------------------------------------------------------------------------------------------
+func VectorLabels(read func(address uint16) byte) map[uint16]string {
+
+	labels := make(map[uint16]string)
+	vectors := make([]uint16, 0, len(VECTOR_NAMES))
+	for vector := range VECTOR_NAMES {
+		vectors = append(vectors, vector)
+	}
+	// Several vectors often share a handler, the lowest vector address names it
+	sort.Slice(vectors, func(i, j int) bool { return vectors[i] > vectors[j] })
+	for _, vector := range vectors {
+		target := (uint16(read(vector))<<8 | uint16(read(vector+1))) & 0x1FFF
+		labels[target] = VECTOR_NAMES[vector]
+	}
+	return labels
+}
+
+// name returns the label or register name of an address, or its hexadecimal form
+func name(address uint16, labels map[uint16]string, digits int) string {
+	if label, ok := labels[address]; ok {
+		return label
+	}
+	if register, ok := REGISTER_NAMES[address]; ok {
+		return register
+	}
+	return fmt.Sprintf("$%0*X", digits, address)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Disassemble
+// Function: Decode the instruction found at an address
+// Parameters: Function reading a byte of the image, address, labels to use for addresses (may be nil)
+// Returns: Mnemonic and operands, length of the instruction in bytes
+// -------------------------------------------------------------------------------------------------------------------
+func Disassemble(read func(address uint16) byte, address uint16, labels map[uint16]string) (string, int) {
+
+	opcode := read(address)
+	entry := OPCODES[opcode]
+	if entry.Mnemonic == "" {
+		return fmt.Sprintf("FCB    $%02X", opcode), 1
+	}
+	length := entry.Length()
+	byte1 := read(address + 1)
+	word := uint16(byte1)<<8 | uint16(read(address+2))
+	relative := func(offset byte) string {
+		return name(uint16(int(address)+length+int(int8(offset)))&0x1FFF, labels, 4)
+	}
+
+	mnemonic := entry.Mnemonic
+	var operand string
+	switch entry.Mode {
+	case IMM:
+		operand = fmt.Sprintf("#$%02X", byte1)
+	case DIR:
+		operand = name(uint16(byte1), labels, 2)
+	case EXT:
+		operand = name(word, labels, 4)
+	case IX:
+		operand = ",X"
+	case IX1:
+		operand = fmt.Sprintf("$%02X,X", byte1)
+	case IX2:
+		operand = fmt.Sprintf("$%04X,X", word)
+	case REL:
+		operand = relative(byte1)
+	case BTB:
+		// BRSETn/BRCLRn are written BRSET n,opr,rel
+		mnemonic = mnemonic[:len(mnemonic)-1]
+		operand = fmt.Sprintf("%d,%s,%s", (opcode>>1)&7, name(uint16(byte1), labels, 2), relative(read(address+2)))
+	case BSC:
+		mnemonic = mnemonic[:len(mnemonic)-1]
+		operand = fmt.Sprintf("%d,%s", (opcode>>1)&7, name(uint16(byte1), labels, 2))
+	}
+	return strings.TrimSpace(fmt.Sprintf("%-6s %s", mnemonic, operand)), length
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: DisassembleRange
+// Function: Produce the listing of an address range, one line per instruction with the raw bytes, labels on their own line
+// Parameters: Function reading a byte of the image, first and last address, labels (may be nil)
+// Returns: Listing lines
+// -------------------------------------------------------------------------------------------------------------------
+func DisassembleRange(read func(address uint16) byte, start uint16, end uint16, labels map[uint16]string) []string {
+
+	var listing []string
+	for address := int(start); address <= int(end); {
+		if label, ok := labels[uint16(address)]; ok {
+			listing = append(listing, label+":")
+		}
+		text, length := Disassemble(read, uint16(address), labels)
+		var raw string
+		for n := 0; n < length; n++ {
+			raw += fmt.Sprintf("%02X ", read(uint16(address+n)))
+		}
+		listing = append(listing, fmt.Sprintf("%04X  %-9s  %s", address, raw, text))
+		address += length
+	}
+	return listing
+}
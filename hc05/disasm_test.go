@@ -0,0 +1,85 @@
+package hc05
+
+import (
+	"reflect"
+	"testing"
+)
+
+// image returns a reader over an 8K memory image holding code at $0100 and the vectors
+func image(code []byte, vectors map[uint16]uint16) func(address uint16) byte {
+	memory := make([]byte, MEMORY_SIZE)
+	copy(memory[0x0100:], code)
+	for vector, target := range vectors {
+		memory[vector] = byte(target >> 8)
+		memory[vector+1] = byte(target)
+	}
+	return func(address uint16) byte {
+		return memory[address&0x1FFF]
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	cases := []struct {
+		name string
+		code []byte
+		want string
+	}{
+		{"inherent", []byte{0x4C}, "INCA"},
+		{"immediate", []byte{0xA6, 0x12}, "LDA    #$12"},
+		{"direct", []byte{0xB6, 0x80}, "LDA    $80"},
+		{"direct register", []byte{0xB7, 0x00}, "STA    PORTA"},
+		{"extended", []byte{0xC6, 0x01, 0x60}, "LDA    $0160"},
+		{"extended register", []byte{0xC6, 0x1F, 0xDF}, "LDA    OPTION"},
+		{"indexed", []byte{0xF6}, "LDA    ,X"},
+		{"indexed 8-bit offset", []byte{0xE6, 0x10}, "LDA    $10,X"},
+		{"indexed 16-bit offset", []byte{0xD6, 0x01, 0x00}, "LDA    $0100,X"},
+		{"relative forward", []byte{0x20, 0x10}, "BRA    $0112"},
+		{"relative backward", []byte{0x26, 0xFE}, "BNE    $0100"},
+		{"BRSET", []byte{0x0E, 0x10, 0xFD}, "BRSET  7,SCSR,$0100"},
+		{"BRCLR", []byte{0x01, 0x80, 0x00}, "BRCLR  0,$80,$0103"},
+		{"BSET", []byte{0x1C, 0x0F}, "BSET   6,SCCR2"},
+		{"BCLR", []byte{0x1B, 0x0F}, "BCLR   5,SCCR2"},
+		{"illegal", []byte{0x31}, "FCB    $31"},
+	}
+	for _, c := range cases {
+		text, length := Disassemble(image(c.code, nil), 0x0100, nil)
+		if text != c.want || length != len(c.code) {
+			t.Errorf("%s: %q (%d bytes), want %q (%d bytes)", c.name, text, length, c.want, len(c.code))
+		}
+	}
+}
+
+func TestVectorLabels(t *testing.T) {
+	read := image(nil, map[uint16]uint16{
+		0x1FF4: 0x0300, 0x1FF6: 0x0200, 0x1FF8: 0x0200, 0x1FFA: 0x0400, 0x1FFC: 0x0500, 0x1FFE: 0x0100,
+	})
+	want := map[uint16]string{
+		0x0100: "RESET",
+		0x0200: "SCI", // Shared with TIMER, the lowest vector names it
+		0x0300: "SPI",
+		0x0400: "IRQ",
+		0x0500: "SWI",
+	}
+	if labels := VectorLabels(read); !reflect.DeepEqual(labels, want) {
+		t.Fatalf("labels %v, want %v", labels, want)
+	}
+}
+
+func TestDisassembleRange(t *testing.T) {
+	// A loop waiting for the SCI transmitter, entered from RESET
+	code := []byte{0xA6, 0x55, 0x0F, 0x10, 0xFD, 0xB7, 0x11, 0x20, 0xF7}
+	read := image(code, map[uint16]uint16{
+		0x1FF4: 0x0100, 0x1FF6: 0x0100, 0x1FF8: 0x0100, 0x1FFA: 0x0100, 0x1FFC: 0x0100, 0x1FFE: 0x0100,
+	})
+	labels := VectorLabels(read)
+	want := []string{
+		"SPI:",
+		"0100  A6 55      LDA    #$55",
+		"0102  0F 10 FD   BRCLR  7,SCSR,$0102",
+		"0105  B7 11      STA    SCDAT",
+		"0107  20 F7      BRA    SPI",
+	}
+	if listing := DisassembleRange(read, 0x0100, 0x0108, labels); !reflect.DeepEqual(listing, want) {
+		t.Fatalf("listing\n%q\nwant\n%q", listing, want)
+	}
+}
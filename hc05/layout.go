@@ -0,0 +1,182 @@
+package hc05
+
+import "fmt"
+
+// Kinds of memory regions
+const REGION_IO = 1
+const REGION_RAM = 2
+const REGION_PROM = 3   // EPROM/OTP
+const REGION_OPTION = 4 // OPTION register: RAM0, RAM1 and IRQ are latches, SEC is EPROM
+const REGION_ROM = 5    // Bootstrap ROM
+const REGION_UNIMPLEMENTED = 6
+const REGION_RESERVED = 7 // Not usable with this OPTION register value
+
+// Region is a range of the HC05 memory map, both ends included
+type Region struct {
+	Name  string
+	Start uint16
+	End   uint16
+	Kind  int
+	image func(m *MemoryImages, offset uint16) *byte // Byte of the memory area images, nil if there is none
+}
+
+// Layouts of the memory map indexed by the RAM0 and RAM1 bits of the OPTION register (see Layout)
+var LAYOUTS [4][]Region
+
+// Layout of the 68HC705C8 while programming: the bootloader leaves RAM0 = RAM1 = 0 so every EPROM/OTP byte is seen
+var PROGRAMMING_LAYOUT []Region
+
+// Region returned for the addresses above the 8K of the HC05
+var UNIMPLEMENTED_REGION = Region{Name: "UNIMPLEMENTED", Start: MEMORY_SIZE, End: 0xFFFF, Kind: REGION_UNIMPLEMENTED}
+
+func init() {
+	for n := range LAYOUTS {
+		LAYOUTS[n] = buildLayout(byte(n << 6))
+	}
+	PROGRAMMING_LAYOUT = LAYOUTS[0]
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: buildLayout
+// Function: List every region of the 68HC705C8 memory map for a value of the OPTION register
+// Parameters: OPTION register
+// Returns: Regions in ascending address order, covering $0000 - $1FFF
+// -------------------------------------------------------------------------------------------------------------------
+func buildLayout(option byte) []Region {
+
+	layout := []Region{
+		{"I/O REGISTERS", 0x0000, 0x001F, REGION_IO, nil},
+	}
+	if option&OPTION_RAM0 != 0 {
+		layout = append(layout,
+			Region{"RESERVED", 0x0020, 0x002F, REGION_RESERVED, nil},
+			Region{"PAGE 0 RAM", 0x0030, 0x004F, REGION_RAM, func(m *MemoryImages, n uint16) *byte { return &m.PAGE0_RAM[n] }})
+	} else {
+		layout = append(layout,
+			Region{"PAGE 0 PROM", 0x0020, 0x004F, REGION_PROM, func(m *MemoryImages, n uint16) *byte { return &m.PAGE0_PROM[n] }})
+	}
+	layout = append(layout,
+		Region{"RAM", 0x0050, 0x00FF, REGION_RAM, func(m *MemoryImages, n uint16) *byte { return &m.RAM[n] }})
+	if option&OPTION_RAM1 != 0 {
+		layout = append(layout,
+			Region{"RAM 2", 0x0100, 0x015F, REGION_RAM, func(m *MemoryImages, n uint16) *byte { return &m.RAM2[n] }})
+	} else {
+		layout = append(layout,
+			Region{"USER PROM", 0x0100, 0x015F, REGION_PROM, func(m *MemoryImages, n uint16) *byte { return &m.USER_PROM[n] }})
+	}
+	return append(layout,
+		Region{"PROM", 0x0160, 0x1EFF, REGION_PROM, func(m *MemoryImages, n uint16) *byte { return &m.PROM[n] }},
+		Region{"BOOTSTRAP ROM", 0x1F00, 0x1FDE, REGION_ROM, nil},
+		Region{"OPTION", 0x1FDF, 0x1FDF, REGION_OPTION, func(m *MemoryImages, n uint16) *byte { return &m.OPTION_REGISTER }},
+		Region{"BOOTSTRAP ROM", 0x1FE0, 0x1FEF, REGION_ROM, nil},
+		Region{"MOR1", 0x1FF0, 0x1FF0, REGION_PROM, func(m *MemoryImages, n uint16) *byte { return &m.MASK_OPTION_REGISTER1 }},
+		Region{"MOR2", 0x1FF1, 0x1FF1, REGION_PROM, func(m *MemoryImages, n uint16) *byte { return &m.MASK_OPTION_REGISTER2 }},
+		Region{"RESERVED PROM", 0x1FF2, 0x1FF3, REGION_PROM, nil},
+		Region{"VECTORS", 0x1FF4, 0x1FFF, REGION_PROM, func(m *MemoryImages, n uint16) *byte { return &m.PROM_VECTORS[n] }},
+	)
+}
+
+// Layout returns the regions of the memory map selected by the RAM0 and RAM1 bits of an OPTION register value
+func Layout(option byte) []Region {
+	return LAYOUTS[option>>6&3]
+}
+
+// FindRegion returns the region of a layout holding an address
+func FindRegion(layout []Region, address uint16) Region {
+	for _, region := range layout {
+		if address >= region.Start && address <= region.End {
+			return region
+		}
+	}
+	return UNIMPLEMENTED_REGION
+}
+
+// IsProm tells whether the region is EPROM/OTP, the OPTION register included
+func (r Region) IsProm() bool {
+	return r.Kind == REGION_PROM || r.Kind == REGION_OPTION
+}
+
+// Byte returns the byte of the memory area images holding an address of the region, nil if it has no image
+func (r Region) Byte(m *MemoryImages, address uint16) *byte {
+	if r.image == nil {
+		return nil
+	}
+	return r.image(m, address-r.Start)
+}
+
+// MemoryMap routes the addresses of the HC05 to the memory area images with the layout chosen by the OPTION register
+type MemoryMap struct {
+	Images *MemoryImages
+	Option byte // Value of the OPTION register, only RAM0 and RAM1 matter
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: NewMemoryMap
+// Function: Create a memory map on the memory area images
+// Parameters: Images, value of the OPTION register (0 for the layout seen while programming)
+// Returns: Pointer to the memory map
+// -------------------------------------------------------------------------------------------------------------------
+func NewMemoryMap(images *MemoryImages, option byte) *MemoryMap {
+	return &MemoryMap{Images: images, Option: option}
+}
+
+// Layout returns every region of the memory map
+func (m *MemoryMap) Layout() []Region {
+	return Layout(m.Option)
+}
+
+// Region returns the region holding an address
+func (m *MemoryMap) Region(address uint16) Region {
+	return FindRegion(m.Layout(), address)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Read
+// Function: Read a byte of the memory area images by HC05 address
+// Parameters: Address
+// Returns: Byte, error if the address is I/O, ROM or unimplemented
+// -------------------------------------------------------------------------------------------------------------------
+func (m *MemoryMap) Read(address uint16) (byte, error) {
+
+	region := m.Region(address)
+	target := region.Byte(m.Images, address)
+	if target == nil {
+		return 0, accessError(region, address)
+	}
+	return *target, nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Write
+// Function: Write a byte of the memory area images by HC05 address
+// Parameters: Address, Data
+// Returns: error if the address is I/O, ROM or unimplemented
+// -------------------------------------------------------------------------------------------------------------------
+func (m *MemoryMap) Write(address uint16, data byte) error {
+
+	region := m.Region(address)
+	target := region.Byte(m.Images, address)
+	if target == nil {
+		return accessError(region, address)
+	}
+	*target = data
+	return nil
+}
+
+// accessError explains why an address has no byte in the memory area images
+func accessError(region Region, address uint16) error {
+	switch region.Kind {
+	case REGION_IO:
+		if name, found := REGISTER_NAMES[address]; found {
+			return fmt.Errorf("address %04X is the I/O register %s", address, name)
+		}
+		return fmt.Errorf("address %04X is in the I/O registers ($0000-$001F)", address)
+	case REGION_ROM:
+		return fmt.Errorf("address %04X is in the bootstrap ROM", address)
+	case REGION_UNIMPLEMENTED:
+		return fmt.Errorf("address %04X is not implemented in the 68HC705C8", address)
+	case REGION_RESERVED:
+		return fmt.Errorf("address %04X is reserved with RAM0 = 1", address)
+	}
+	return fmt.Errorf("address %04X (%s) cannot be loaded", address, region.Name)
+}
@@ -0,0 +1,107 @@
+package hc05
+
+import "testing"
+
+// Every layout covers $0000-$1FFF with no gap or overlap
+func TestLayoutsContiguous(t *testing.T) {
+	for option := 0; option < 4; option++ {
+		next := 0
+		for _, region := range LAYOUTS[option] {
+			if int(region.Start) != next || region.End < region.Start {
+				t.Errorf("layout %d: %s at %04X-%04X, want it to start at %04X", option, region.Name, region.Start, region.End, next)
+			}
+			next = int(region.End) + 1
+		}
+		if next != MEMORY_SIZE {
+			t.Errorf("layout %d ends at %04X", option, next-1)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	cases := []struct {
+		option  byte
+		address uint16
+		name    string
+		kind    int
+	}{
+		{0x00, 0x0020, "PAGE 0 PROM", REGION_PROM},
+		{0x00, 0x0030, "PAGE 0 PROM", REGION_PROM},
+		{0x00, 0x0100, "USER PROM", REGION_PROM},
+		{OPTION_RAM0, 0x0020, "RESERVED", REGION_RESERVED},
+		{OPTION_RAM0, 0x0030, "PAGE 0 RAM", REGION_RAM},
+		{OPTION_RAM0, 0x0100, "USER PROM", REGION_PROM},
+		{OPTION_RAM1, 0x0030, "PAGE 0 PROM", REGION_PROM},
+		{OPTION_RAM1, 0x0100, "RAM 2", REGION_RAM},
+		{OPTION_RAM1, 0x0160, "PROM", REGION_PROM},
+		{OPTION_RAM0 | OPTION_RAM1, 0x0030, "PAGE 0 RAM", REGION_RAM},
+		{OPTION_RAM0 | OPTION_RAM1, 0x015F, "RAM 2", REGION_RAM},
+		{0xFF, 0x0005, "I/O REGISTERS", REGION_IO}, // Only RAM0 and RAM1 select the layout
+		{0x00, 0x1FDF, "OPTION", REGION_OPTION},
+		{0x00, 0x1F00, "BOOTSTRAP ROM", REGION_ROM},
+		{0x00, 0x2000, "UNIMPLEMENTED", REGION_UNIMPLEMENTED},
+	}
+	for _, c := range cases {
+		region := FindRegion(Layout(c.option), c.address)
+		if region.Name != c.name || region.Kind != c.kind {
+			t.Errorf("OPTION %02X: %04X is in %s (%d), want %s (%d)", c.option, c.address, region.Name, region.Kind, c.name, c.kind)
+		}
+	}
+}
+
+func TestMemoryMap(t *testing.T) {
+	cases := []struct {
+		option  byte
+		address uint16
+		image   func(m *MemoryImages) *byte // Byte that must receive the write
+	}{
+		{0x00, 0x0020, func(m *MemoryImages) *byte { return &m.PAGE0_PROM[0] }},
+		{0x00, 0x0030, func(m *MemoryImages) *byte { return &m.PAGE0_PROM[0x10] }},
+		{OPTION_RAM0, 0x0030, func(m *MemoryImages) *byte { return &m.PAGE0_RAM[0] }},
+		{0x00, 0x0100, func(m *MemoryImages) *byte { return &m.USER_PROM[0] }},
+		{OPTION_RAM1, 0x0100, func(m *MemoryImages) *byte { return &m.RAM2[0] }},
+		{0x00, 0x0051, func(m *MemoryImages) *byte { return &m.RAM[1] }},
+		{0x00, 0x1EFF, func(m *MemoryImages) *byte { return &m.PROM[len(m.PROM)-1] }},
+		{0x00, 0x1FDF, func(m *MemoryImages) *byte { return &m.OPTION_REGISTER }},
+		{0x00, 0x1FF1, func(m *MemoryImages) *byte { return &m.MASK_OPTION_REGISTER2 }},
+		{0x00, 0x1FFE, func(m *MemoryImages) *byte { return &m.PROM_VECTORS[10] }},
+	}
+	for _, c := range cases {
+		memory := NewMemoryMap(NewMemoryImages(), c.option)
+		if err := memory.Write(c.address, 0xA5); err != nil {
+			t.Errorf("OPTION %02X: write %04X: %v", c.option, c.address, err)
+			continue
+		}
+		if *c.image(memory.Images) != 0xA5 {
+			t.Errorf("OPTION %02X: write to %04X landed elsewhere", c.option, c.address)
+		}
+		if data, err := memory.Read(c.address); err != nil || data != 0xA5 {
+			t.Errorf("OPTION %02X: read %04X gave %02X, %v", c.option, c.address, data, err)
+		}
+	}
+}
+
+func TestMemoryMapErrors(t *testing.T) {
+	cases := []struct {
+		option  byte
+		address uint16
+		message string
+	}{
+		{0x00, 0x0005, "address 0005 is the I/O register DDRB"},
+		{0x00, 0x0007, "address 0007 is in the I/O registers ($0000-$001F)"},
+		{OPTION_RAM0, 0x0020, "address 0020 is reserved with RAM0 = 1"},
+		{0x00, 0x1F00, "address 1F00 is in the bootstrap ROM"},
+		{0x00, 0x1FF2, "address 1FF2 (RESERVED PROM) cannot be loaded"},
+		{0x00, 0x2000, "address 2000 is not implemented in the 68HC705C8"},
+		{OPTION_RAM1, 0xFFFF, "address FFFF is not implemented in the 68HC705C8"},
+	}
+	for _, c := range cases {
+		memory := NewMemoryMap(NewMemoryImages(), c.option)
+		if err := memory.Write(c.address, 0); err == nil || err.Error() != c.message {
+			t.Errorf("OPTION %02X: write %04X: error %v, want %q", c.option, c.address, err, c.message)
+		}
+		if _, err := memory.Read(c.address); err == nil || err.Error() != c.message {
+			t.Errorf("OPTION %02X: read %04X: error %v, want %q", c.option, c.address, err, c.message)
+		}
+	}
+}
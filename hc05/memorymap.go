@@ -0,0 +1,107 @@
+// Package hc05 describes the MC68HC705C8 memory map and holds the memory area images used by PROG05
+package hc05
+
+// Size of the HC05 address space
+const MEMORY_SIZE = 8192
+
+// First and last addresses of the main RAM, the bootloader places the uploaded code at RAM_START + 1
+const RAM_START = 0x0050
+const RAM_END = 0x00FF
+
+// RAM programs are stored by the bootloader from LOADER_START and run from there, they must end at RAM_PROGRAM_END as
+// the stack sits above it ($00C0 - $00FF)
+const LOADER_START = 0x0051
+const RAM_PROGRAM_END = 0x00BF
+
+// EPROM/OTP areas of the 68HC705C8 checked by BLANKCHECK
+var BLANK_CHECK_RANGES = [][2]uint16{
+	{0x0020, 0x004F}, // PAGE 0 PROM (RAM0 = 0)
+	{0x0100, 0x015F}, // USER PROM (RAM1 = 0)
+	{0x0160, 0x1EFF}, // PROM
+	{0x1FDF, 0x1FDF}, // OPTION register
+	{0x1FF0, 0x1FFF}, // MASK OPTION registers and vectors
+}
+
+// MemoryImages holds the 68HC705C8 memory area images
+type MemoryImages struct {
+	PAGE0_PROM            []byte // If RAM0 bit = 0 (0x0020 - 0x004F)
+	PAGE0_RAM             []byte // If RAM0 bit = 1 (0x0030 - 0x004F)
+	RAM                   []byte // Main RAM + STACK
+	USER_PROM             []byte // If RAM1 bit = 0
+	RAM2                  []byte // If RAM1 bit = 1
+	PROM                  []byte
+	OPTION_REGISTER       byte   // Address 0x1FDF
+	MASK_OPTION_REGISTER1 byte   // Address 0x1FF0
+	MASK_OPTION_REGISTER2 byte   // Address 0x1FF1
+	PROM_VECTORS          []byte // 1FF4 - 1FFF
+	PROM_LOADED           []bool // Marks every address of the EPROM images that was filled by LOAD
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: NewMemoryImages
+// Function: Allocate every memory area image of the 68HC705C8
+// Returns: Pointer to the images
+// -------------------------------------------------------------------------------------------------------------------
+func NewMemoryImages() *MemoryImages {
+	return &MemoryImages{
+		PAGE0_PROM:   make([]byte, 48),
+		PAGE0_RAM:    make([]byte, 32),
+		RAM:          make([]byte, 176),
+		USER_PROM:    make([]byte, 96),
+		RAM2:         make([]byte, 96),
+		PROM:         make([]byte, 7584),
+		PROM_VECTORS: make([]byte, 12),
+		PROM_LOADED:  make([]bool, MEMORY_SIZE),
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: PromImageByte
+// Function: Locate the byte in the 68HC705C8 memory area images that holds the given EPROM/OTP address
+// Parameters: Address in the HC05 memory map
+// Returns: Pointer to the image byte, nil if the address is not EPROM/OTP
+// -------------------------------------------------------------------------------------------------------------------
+func (m *MemoryImages) PromImageByte(address uint16) *byte {
+
+	region := FindRegion(PROGRAMMING_LAYOUT, address)
+	if !region.IsProm() {
+		return nil
+	}
+	return region.Byte(m, address)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: IsPromAddress
+// Function: Tells whether an address of the HC05 memory map is EPROM/OTP (RAM0 = RAM1 = 0)
+// Parameters: Address in the HC05 memory map
+// Returns: true if the address is EPROM/OTP
+// -------------------------------------------------------------------------------------------------------------------
+func IsPromAddress(address uint16) bool {
+	return FindRegion(PROGRAMMING_LAYOUT, address).IsProm()
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ClearPromImages
+// Function: Set every EPROM/OTP image to the erased value and forget what was loaded
+// Parameters: Value of an erased EPROM byte
+// -------------------------------------------------------------------------------------------------------------------
+func (m *MemoryImages) ClearPromImages(erased byte) {
+
+	for address := 0; address < MEMORY_SIZE; address++ {
+		target := m.PromImageByte(uint16(address))
+		if target != nil {
+			*target = erased
+		}
+		m.PROM_LOADED[address] = false
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ClearRam
+// Function: Clear the RAM image prior to loading a program
+// -------------------------------------------------------------------------------------------------------------------
+func (m *MemoryImages) ClearRam() {
+	for n := range m.RAM {
+		m.RAM[n] = 0
+	}
+}
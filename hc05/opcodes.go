@@ -0,0 +1,108 @@
+package hc05
+
+import "fmt"
+
+// Addressing modes of the HC05 instruction set
+const (
+	INH = iota + 1 // Inherent
+	IMM            // Immediate
+	DIR            // Direct (page 0)
+	EXT            // Extended
+	IX             // Indexed, no offset
+	IX1            // Indexed, 8-bit offset
+	IX2            // Indexed, 16-bit offset
+	REL            // Relative
+	BTB            // Bit test and branch (BRSET/BRCLR): direct address, relative offset
+	BSC            // Bit set/clear (BSET/BCLR): direct address
+)
+
+// Opcode describes one entry of the HC05 opcode map
+type Opcode struct {
+	Mnemonic string // Empty for an illegal opcode
+	Mode     int
+	Cycles   int
+}
+
+// OPCODES is the HC05 opcode map, indexed by opcode
+var OPCODES [256]Opcode
+
+// Size in bytes of an instruction (opcode included) for each addressing mode
+var modeLength = map[int]int{
+	INH: 1, IMM: 2, DIR: 2, EXT: 3, IX: 1, IX1: 2, IX2: 3, REL: 2, BTB: 3, BSC: 2,
+}
+
+// Length returns the size in bytes of the instruction, 1 for an illegal opcode
+func (o Opcode) Length() int {
+	if o.Mnemonic == "" {
+		return 1
+	}
+	return modeLength[o.Mode]
+}
+
+func init() {
+
+	// Bit manipulation, columns 0 and 1
+	for n := 0; n < 8; n++ {
+		OPCODES[0x00+2*n] = Opcode{fmt.Sprintf("BRSET%d", n), BTB, 5}
+		OPCODES[0x01+2*n] = Opcode{fmt.Sprintf("BRCLR%d", n), BTB, 5}
+		OPCODES[0x10+2*n] = Opcode{fmt.Sprintf("BSET%d", n), BSC, 5}
+		OPCODES[0x11+2*n] = Opcode{fmt.Sprintf("BCLR%d", n), BSC, 5}
+	}
+
+	// Branches, column 2
+	branches := []string{"BRA", "BRN", "BHI", "BLS", "BCC", "BCS", "BNE", "BEQ",
+		"BHCC", "BHCS", "BPL", "BMI", "BMC", "BMS", "BIL", "BIH"}
+	for n, mnemonic := range branches {
+		OPCODES[0x20+n] = Opcode{mnemonic, REL, 3}
+	}
+
+	// Read-modify-write, columns 3 to 7
+	readmodifywrite := map[int]string{0x0: "NEG", 0x3: "COM", 0x4: "LSR", 0x6: "ROR", 0x7: "ASR",
+		0x8: "LSL", 0x9: "ROL", 0xA: "DEC", 0xC: "INC", 0xD: "TST", 0xF: "CLR"}
+	for low, mnemonic := range readmodifywrite {
+		cycles := []int{5, 3, 3, 6, 5}
+		if mnemonic == "TST" {
+			cycles = []int{4, 3, 3, 5, 4} // TST does not write back
+		}
+		OPCODES[0x30+low] = Opcode{mnemonic, DIR, cycles[0]}
+		OPCODES[0x40+low] = Opcode{mnemonic + "A", INH, cycles[1]}
+		OPCODES[0x50+low] = Opcode{mnemonic + "X", INH, cycles[2]}
+		OPCODES[0x60+low] = Opcode{mnemonic, IX1, cycles[3]}
+		OPCODES[0x70+low] = Opcode{mnemonic, IX, cycles[4]}
+	}
+	OPCODES[0x42] = Opcode{"MUL", INH, 11}
+
+	// Control, columns 8 and 9
+	control := map[int]Opcode{
+		0x80: {"RTI", INH, 9}, 0x81: {"RTS", INH, 6}, 0x83: {"SWI", INH, 10},
+		0x8E: {"STOP", INH, 2}, 0x8F: {"WAIT", INH, 2},
+		0x97: {"TAX", INH, 2}, 0x98: {"CLC", INH, 2}, 0x99: {"SEC", INH, 2}, 0x9A: {"CLI", INH, 2},
+		0x9B: {"SEI", INH, 2}, 0x9C: {"RSP", INH, 2}, 0x9D: {"NOP", INH, 2}, 0x9F: {"TXA", INH, 2},
+	}
+	for opcode, entry := range control {
+		OPCODES[opcode] = entry
+	}
+
+	// Register/memory, columns A to F
+	registermemory := []string{"SUB", "CMP", "SBC", "CPX", "AND", "BIT", "LDA", "STA",
+		"EOR", "ADC", "ORA", "ADD", "JMP", "JSR", "LDX", "STX"}
+	modes := []int{IMM, DIR, EXT, IX2, IX1, IX}
+	for low, mnemonic := range registermemory {
+		cycles := []int{2, 3, 4, 5, 4, 3}
+		switch mnemonic {
+		case "STA", "STX":
+			cycles = []int{0, 4, 5, 6, 5, 4}
+		case "JMP":
+			cycles = []int{0, 2, 3, 4, 3, 2}
+		case "JSR":
+			cycles = []int{0, 5, 6, 7, 6, 5}
+		}
+		for column, mode := range modes {
+			if cycles[column] == 0 {
+				continue // No immediate form
+			}
+			OPCODES[0xA0+column*0x10+low] = Opcode{mnemonic, mode, cycles[column]}
+		}
+	}
+	OPCODES[0xAD] = Opcode{"BSR", REL, 6}
+}
@@ -0,0 +1,122 @@
+package hc05
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Address of the OPTION register and its bits
+const OPTION_ADDRESS = 0x1FDF
+const OPTION_RAM0 = 0x80 // $0030 - $004F is RAM instead of PAGE 0 PROM
+const OPTION_RAM1 = 0x40 // $0100 - $015F is RAM instead of USER PROM
+const OPTION_SEC = 0x08  // Security: the bootloader no longer runs uploaded code, the EPROM cannot be read out
+const OPTION_IRQ = 0x02  // IRQ pin sensitive to the low level as well as the falling edge
+
+// Addresses of the MASK OPTION registers (68HC705C8A, program them to $00 for a 68HC705C8 application)
+const MOR1_ADDRESS = 0x1FF0
+const MOR2_ADDRESS = 0x1FF1
+
+// Option registers in the order they are shown
+var OPTION_REGISTERS = []uint16{OPTION_ADDRESS, MOR1_ADDRESS, MOR2_ADDRESS}
+
+// OptionField is a bit of the OPTION or MASK OPTION registers
+type OptionField struct {
+	Name    string
+	Address uint16
+	Mask    byte
+	Meaning [2]string // What the bit selects when it reads 0 and 1
+	Latch   bool      // Cleared or set by reset and changed by the program at run time, not an EPROM cell
+}
+
+// Every named bit of the option registers (MC68HC705C8A technical data, section 9.5)
+var OPTION_FIELDS = []OptionField{
+	{"RAM0", OPTION_ADDRESS, OPTION_RAM0, [2]string{"$0020-$004F is PAGE 0 PROM", "$0030-$004F is RAM, $0020-$002F reserved"}, true},
+	{"RAM1", OPTION_ADDRESS, OPTION_RAM1, [2]string{"$0100-$015F is USER PROM", "$0100-$015F is RAM"}, true},
+	{"SEC", OPTION_ADDRESS, OPTION_SEC, [2]string{"Security off, the bootloader can be used", "Secured, bootloader disabled (single-chip mode only)"}, false},
+	{"IRQ", OPTION_ADDRESS, OPTION_IRQ, [2]string{"IRQ pin falling edge sensitive only", "IRQ pin falling edge and low level sensitive"}, true},
+	{"PBPU7", MOR1_ADDRESS, 0x80, [2]string{"PB7 pullup and interrupt disabled", "PB7 pullup and interrupt enabled"}, false},
+	{"PBPU6", MOR1_ADDRESS, 0x40, [2]string{"PB6 pullup and interrupt disabled", "PB6 pullup and interrupt enabled"}, false},
+	{"PBPU5", MOR1_ADDRESS, 0x20, [2]string{"PB5 pullup and interrupt disabled", "PB5 pullup and interrupt enabled"}, false},
+	{"PBPU4", MOR1_ADDRESS, 0x10, [2]string{"PB4 pullup and interrupt disabled", "PB4 pullup and interrupt enabled"}, false},
+	{"PBPU3", MOR1_ADDRESS, 0x08, [2]string{"PB3 pullup and interrupt disabled", "PB3 pullup and interrupt enabled"}, false},
+	{"PBPU2", MOR1_ADDRESS, 0x04, [2]string{"PB2 pullup and interrupt disabled", "PB2 pullup and interrupt enabled"}, false},
+	{"PBPU1", MOR1_ADDRESS, 0x02, [2]string{"PB1 pullup and interrupt disabled", "PB1 pullup and interrupt enabled"}, false},
+	{"PBPU0", MOR1_ADDRESS, 0x01, [2]string{"PB0 pullup and interrupt disabled", "PB0 pullup and interrupt enabled"}, false},
+	{"NCOPE", MOR2_ADDRESS, 0x01, [2]string{"Non-programmable COP watchdog disabled", "Non-programmable COP watchdog enabled"}, false},
+}
+
+// IsSecured tells whether an OPTION register value has the security bit set
+func IsSecured(option byte) bool {
+	return option&OPTION_SEC != 0
+}
+
+// Bit returns the state of the field in a register value
+func (f OptionField) Bit(value byte) int {
+	if value&f.Mask != 0 {
+		return 1
+	}
+	return 0
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: DecodeOptions
+// Function: Describe every named bit of an option register
+// Parameters: Address of the register ($1FDF, $1FF0 or $1FF1), value
+// Returns: One line per bit: name, state and meaning
+// -------------------------------------------------------------------------------------------------------------------
+func DecodeOptions(address uint16, value byte) []string {
+
+	var lines []string
+	for _, field := range OPTION_FIELDS {
+		if field.Address == address {
+			line := fmt.Sprintf("%-5s = %d  %s", field.Name, field.Bit(value), field.Meaning[field.Bit(value)])
+			if field.Latch {
+				line += " (run-time latch)"
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ComposeOptions
+// Function: Set option register bits by name, e.g. "RAM1=1" or "NCOPE=0" (ON/OFF are accepted for 1/0)
+// Parameters: Register values by address, updated in place (a missing register starts from the erased value),
+// assignments, value of an erased EPROM byte
+// Returns: Latch bits named (programming them has no effect on the part), error if a name or value is not known
+// -------------------------------------------------------------------------------------------------------------------
+func ComposeOptions(registers map[uint16]byte, assignments []string, erased byte) ([]string, error) {
+
+	var latches []string
+	for _, assignment := range assignments {
+		name, value, found := strings.Cut(assignment, "=")
+		if !found {
+			return latches, fmt.Errorf("%s: expected NAME=0 or NAME=1", assignment)
+		}
+		var field *OptionField
+		for n := range OPTION_FIELDS {
+			if strings.EqualFold(OPTION_FIELDS[n].Name, strings.TrimSpace(name)) {
+				field = &OPTION_FIELDS[n]
+			}
+		}
+		if field == nil {
+			return latches, fmt.Errorf("%s: unknown option bit (RAM0, RAM1, SEC, IRQ, PBPU0-PBPU7 or NCOPE)", name)
+		}
+		if _, found := registers[field.Address]; !found {
+			registers[field.Address] = erased
+		}
+		switch strings.ToUpper(strings.TrimSpace(value)) {
+		case "1", "ON":
+			registers[field.Address] |= field.Mask
+		case "0", "OFF":
+			registers[field.Address] &^= field.Mask
+		default:
+			return latches, fmt.Errorf("%s: the value of %s must be 0 or 1", assignment, field.Name)
+		}
+		if field.Latch {
+			latches = append(latches, field.Name)
+		}
+	}
+	return latches, nil
+}
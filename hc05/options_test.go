@@ -0,0 +1,81 @@
+package hc05
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Each named bit composes to its mask and decodes back to the same name set
+func TestComposeDecodeOptions(t *testing.T) {
+	cases := []struct {
+		assignment string
+		address    uint16
+		value      byte
+		latch      bool
+	}{
+		{"RAM0=1", OPTION_ADDRESS, 0x80, true},
+		{"ram1=on", OPTION_ADDRESS, 0x40, true},
+		{"SEC=1", OPTION_ADDRESS, 0x08, false},
+		{"IRQ = 1", OPTION_ADDRESS, 0x02, true},
+		{"PBPU7=1", MOR1_ADDRESS, 0x80, false},
+		{"PBPU0=ON", MOR1_ADDRESS, 0x01, false},
+		{"NCOPE=1", MOR2_ADDRESS, 0x01, false},
+	}
+	for _, c := range cases {
+		registers := make(map[uint16]byte)
+		latches, err := ComposeOptions(registers, []string{c.assignment}, 0)
+		if err != nil {
+			t.Errorf("%s: %v", c.assignment, err)
+			continue
+		}
+		if !reflect.DeepEqual(registers, map[uint16]byte{c.address: c.value}) {
+			t.Errorf("%s: registers %v, want %04X = %02X", c.assignment, registers, c.address, c.value)
+		}
+		if (len(latches) == 1) != c.latch {
+			t.Errorf("%s: latches %v", c.assignment, latches)
+		}
+
+		name := strings.ToUpper(strings.TrimSpace(strings.Split(c.assignment, "=")[0]))
+		var set []string
+		for _, line := range DecodeOptions(c.address, c.value) {
+			if strings.Contains(line, "= 1") {
+				set = append(set, strings.TrimSpace(line[:5]))
+			}
+		}
+		if !reflect.DeepEqual(set, []string{name}) {
+			t.Errorf("%02X at %04X decodes with %v set, want %s", c.value, c.address, set, name)
+		}
+	}
+}
+
+// Bits are cleared from the erased value and several assignments land in their own registers
+func TestComposeOptionsErased(t *testing.T) {
+	registers := map[uint16]byte{OPTION_ADDRESS: 0x0A}
+	_, err := ComposeOptions(registers, []string{"SEC=0", "NCOPE=off", "PBPU3=1"}, 0xFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint16]byte{OPTION_ADDRESS: 0x02, MOR1_ADDRESS: 0xFF, MOR2_ADDRESS: 0xFE}
+	if !reflect.DeepEqual(registers, want) {
+		t.Fatalf("registers %v, want %v", registers, want)
+	}
+}
+
+func TestComposeOptionsErrors(t *testing.T) {
+	cases := []struct {
+		assignment string
+		message    string
+	}{
+		{"COPE=1", "COPE: unknown option bit"},
+		{"PBPU8=1", "PBPU8: unknown option bit"},
+		{"SEC", "SEC: expected NAME=0 or NAME=1"},
+		{"SEC=2", "SEC=2: the value of SEC must be 0 or 1"},
+	}
+	for _, c := range cases {
+		_, err := ComposeOptions(make(map[uint16]byte), []string{c.assignment}, 0)
+		if err == nil || !strings.HasPrefix(err.Error(), c.message) {
+			t.Errorf("%s: error %v, want %q", c.assignment, err, c.message)
+		}
+	}
+}
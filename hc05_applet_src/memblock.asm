@@ -0,0 +1,150 @@
+***************************************************************
+* MEMBLOCK.ASM
+* Applet for PROG05 to read blocks of up to 256 bytes anywhere
+* within the HC05 memory map
+*
+* Protocol: the host sends address high, address low and count
+* (0 = 256 bytes). The applet answers with the bytes followed by
+* a checksum: the 8 bit sum of the three request bytes and every
+* byte sent, so a request damaged on the way in is caught as well
+*
+* Compatibility: Should work with mask all mask revisions as far
+* back as 0C16W. Tested and developed on mask revision 0K08B
+***************************************************************
+
+* Definitions of addresses and constants
+
+
+EPGM       EQU 0              ;PROG BIT0; - Vpp CONTROL BIT
+ERASED     EQU $00            ;VALUE OF AN ERASED EPROM BYTE
+INSTAT     EQU %01100000      ;INITIAL PORT C LED STATUS
+LAT        EQU 2              ;PROG BIT2; - EPROM ADDRESS LATCH BIT
+LATCH      EQU %00000100      ;PROG BIT2
+MUL        EQU $42            ;OP-CODE FOR MULTIPLY INSTRUCTION
+OCF        EQU 6              ;TIMSR        BIT6; - OUTPUT COMPARE FLAG
+OLVL       EQU 0              ;TIMCR        BIT0; - TIMER COMPARE OUTPUT LEVEL
+RDRF       EQU 5              ;SCSR         BIT5; - RCV DATA REG FULL FLAG
+TDRE       EQU 7              ;SCSR         BIT7; - XMIT DATA REG EMPTY FLAG
+TEST       EQU 2              ;PORTD        BIT2; - '0' GO BOOT,'1'GO $51 (RAM)
+OPTION     EQU $1FDF          ;OPTION REGISTER
+TSTREG     EQU $1F            ;TEST REGISTER
+
+
+*
+* I/O DEFINITIONS
+*
+PORTA   EQU $00    ;PORT A DATA
+PORTB   EQU $01    ;PORT B DATA
+PORTC   EQU $02    ;PORT C DATA
+PORTD   EQU $03    ;PORT D DATA (Input Only!)
+DDRA    EQU $04    ;PORT A DDR
+DDRB    EQU $05    ;PORT B DDR
+DDRC    EQU $06    ;PORT C DDR
+
+*
+* SERIAL COMMUNICATIONS INTERFACE REGISTERS
+*
+BAUD  EQU $0D           ; BAUD RATE CONTROL
+SCCR1 EQU $0E           ; SERIAL COMM'S CONTROL REGISTER 1
+SCCR2 EQU $0F           ; SERIAL COMM'S CONTROL REGISTER 2
+SCSR  EQU $10           ; SERIAL COMM'S STATUS
+SCDAT EQU $11           ; SERIAL COMM'S DATA
+
+*
+* OTHERS
+*
+
+
+*************************************************************************
+* Allocation of variables in RAM
+*************************************************************************
+
+
+
+* Variables located at address 0xB8 to 0xBF
+********************************************
+    org $B8
+count     ds      1     ; bytes left to send in the block
+checksum  ds      1     ; running sum of the request and the bytes sent
+opcode    ds      1     ; LDA nnnn,X
+addrhi    ds      1
+addrlo    ds      1     ; high and low address in memory map
+return    ds      1     ; RTS
+
+
+
+********************************************************************************************************
+* Locate program in RAM
+* Execution begins from address 0x0051 once the loader has written all the received bytes to RAM
+*********************************************************************************************************
+    org $51
+
+****************
+* Program start
+****************
+start:
+        ; Here we set up the SCI to transmit
+        ; at standard 9600bps
+
+        CLR SCCR1
+        LDA #%00001100
+        STA SCCR2
+        LDA #$30     ; Baud rate = 9600 bps
+        STA BAUD
+
+        ; Initialise the overlay
+        LDA #$D6           ; <- LDA,X ee ff
+        STA opcode
+        LDA #$81           ; <- RTS
+        STA return
+
+****************************************************
+* Main processing loop
+****************************************************
+Loop:
+     ; Wait for address high, address low and count
+        JSR     Receive
+        STA     addrhi
+        STA     checksum
+        JSR     Receive
+        STA     addrlo
+        ADD     checksum
+        STA     checksum
+        JSR     Receive
+        STA     count
+        ADD     checksum
+        STA     checksum
+
+     ; Send count bytes from the address, X is the offset of the overlay
+        CLRX
+Next:
+        JSR     opcode          ; LDA addrhi:addrlo,X
+        JSR     Transmit
+        ADD     checksum
+        STA     checksum
+        INCX
+        DEC     count           ; a count of 0 wraps to 255, 256 bytes are sent
+        BNE     Next
+
+     ; The checksum closes the block
+        LDA     checksum
+        JSR     Transmit
+        BRA     Loop
+
+****************************************************
+* Name: Transmit
+* Function: Send byte in A out on SCI
+****************************************************
+Transmit:
+        BRCLR   TDRE,SCSR,Transmit    ; Wait for transmitter to be empty
+        STA     SCDAT
+        RTS
+
+****************************************************
+* Name: Receive
+* Function: Poll SCI for received data and store in A
+****************************************************
+Receive:
+        BRCLR   RDRF,SCSR,Receive
+        LDA     SCDAT
+        RTS
@@ -0,0 +1,167 @@
+***************************************************************
+* MEMPROG.ASM
+* Applet for PROG05 to program EPROM/OTP bytes within the HC05 memory map
+* Author: Sonic2k
+* Date: 21 May 2023
+*
+* Compatibility: Should work with mask all mask revisions as far
+* back as 0C16W. Tested and developed on mask revision 0K08B
+***************************************************************
+
+* Definitions of addresses and constants
+
+
+EPGM       EQU 0              ;PROG BIT0; - Vpp CONTROL BIT
+ERASED     EQU $00            ;VALUE OF AN ERASED EPROM BYTE
+INSTAT     EQU %01100000      ;INITIAL PORT C LED STATUS
+LAT        EQU 2              ;PROG BIT2; - EPROM ADDRESS LATCH BIT
+LATCH      EQU %00000100      ;PROG BIT2
+MUL        EQU $42            ;OP-CODE FOR MULTIPLY INSTRUCTION
+OCF        EQU 6              ;TIMSR        BIT6; - OUTPUT COMPARE FLAG
+OLVL       EQU 0              ;TIMCR        BIT0; - TIMER COMPARE OUTPUT LEVEL
+RDRF       EQU 5              ;SCSR         BIT5; - RCV DATA REG FULL FLAG
+TDRE       EQU 7              ;SCSR         BIT7; - XMIT DATA REG EMPTY FLAG
+TEST       EQU 2              ;PORTD        BIT2; - '0' GO BOOT,'1'GO $51 (RAM)
+OPTION     EQU $1FDF          ;OPTION REGISTER
+TSTREG     EQU $1F            ;TEST REGISTER
+PROG       EQU $1C            ;EPROM PROGRAMMING REGISTER
+
+
+*
+* I/O DEFINITIONS
+*
+PORTA   EQU $00    ;PORT A DATA
+PORTB   EQU $01    ;PORT B DATA
+PORTC   EQU $02    ;PORT C DATA
+PORTD   EQU $03    ;PORT D DATA (Input Only!)
+DDRA    EQU $04    ;PORT A DDR
+DDRB    EQU $05    ;PORT B DDR
+DDRC    EQU $06    ;PORT C DDR
+
+*
+* SERIAL COMMUNICATIONS INTERFACE REGISTERS
+*
+BAUD  EQU $0D           ; BAUD RATE CONTROL
+SCCR1 EQU $0E           ; SERIAL COMM'S CONTROL REGISTER 1
+SCCR2 EQU $0F           ; SERIAL COMM'S CONTROL REGISTER 2
+SCSR  EQU $10           ; SERIAL COMM'S STATUS
+SCDAT EQU $11           ; SERIAL COMM'S DATA
+
+*
+* OTHERS
+*
+
+
+*************************************************************************
+* Allocation of variables in RAM
+*************************************************************************
+
+
+
+* Variables located at address 0xBA to 0xBF
+* The first portion is an overlay to allow us to switch between
+* STA hhll,X (program) and LDA hhll,X (read back)
+**********************************************************************************
+    org $BA
+opcode    ds      1     ; STA/LDA hhll,X
+addrhi    ds      1     ; hh
+addrlo    ds      1     ; ll - high and low address in memory map
+return    ds      1     ; RTS
+DataByte  ds      1
+
+
+********************************************************************************************************
+* Locate program in RAM
+* Execution begins from address 0x0051 once the loader has written all the received bytes to RAM
+*
+* Protocol: host sends address high, address low and data byte. The byte is latched and
+*           programmed (Vpp must be present on the target), then read back and returned to
+*           the host so it can check the result.
+*********************************************************************************************************
+    org $51
+
+****************
+* Program start
+****************
+start:
+        ; Here we set up the SCI to transmit
+        ; at standard 9600bps
+
+        LDX #DDRA    ; X <- 4
+        CLR SCCR1
+        LDA #%00001100
+        STA SCCR2
+        LDA #$30     ; Baud rate = 9600 bps
+        STA BAUD
+
+        ; Initialise the overlay
+        LDA #$81           ; <- RTS
+        STA return
+
+****************************************************
+* Main processing loop
+****************************************************
+Loop:
+     ; Wait for address high and low bytes
+        JSR     Receive
+        STA     addrhi
+        JSR     Receive
+        STA     addrlo
+     ; Wait for data byte that will be programmed at the provided address
+        JSR     Receive
+        STA     DataByte
+
+     ; Latch address and data into the EPROM array
+        BSET    LAT,PROG
+        LDA     #$D7           ; <- STA,X ee ff
+        STA     opcode
+        CLRX
+        LDA     DataByte
+        JSR     $BA
+
+     ; Apply programming pulse, then release the latch
+        BSET    EPGM,PROG
+        JSR     Delay
+        BCLR    EPGM,PROG
+        BCLR    LAT,PROG
+
+     ; Read location back and return the value to the host
+        LDA     #$D6           ; <- LDA,X ee ff
+        STA     opcode
+        CLRX
+        JSR     $BA
+        JSR     Transmit
+        BRA     Loop
+
+****************************************************
+* Name: Transmit
+* Function: Send byte in A out on SCI
+****************************************************
+Transmit:
+        BRCLR   TDRE,SCSR,Transmit    ; Wait for transmitter to be empty
+        STA     SCDAT
+        RTS
+
+****************************************************
+* Name: Receive
+* Function: Poll SCI for received data and store in A
+****************************************************
+Receive:
+        BRCLR   RDRF,SCSR,Receive
+        LDA     SCDAT
+        RTS
+
+****************************************************
+* Name: Delay
+* Function: Programming pulse delay
+****************************************************
+Delay:
+        LDA #$0A        ; 0x0A gives around 5mS at 4MHz (10mS at 2MHz), tEPGM is 4mS minimum
+oloop:  LDX #$A6
+
+iloop:
+        DECX
+        BNE iloop
+        DECA
+        BNE oloop
+        RTS
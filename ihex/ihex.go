@@ -0,0 +1,170 @@
+// Package ihex reads and writes Intel HEX files for PROG05
+//
+// The HC05 address space fits in 16 bits, so only data (00) and end of file (01) records are written. Extended
+// address and start address records are accepted on input as long as the data stays below $10000.
+package ihex
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sonikku2k/PROG05/srec"
+)
+
+// Record types
+const DATA = 0x00
+const END_OF_FILE = 0x01
+const EXTENDED_SEGMENT_ADDRESS = 0x02
+const START_SEGMENT_ADDRESS = 0x03
+const EXTENDED_LINEAR_ADDRESS = 0x04
+const START_LINEAR_ADDRESS = 0x05
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Read
+// Function: Parse Intel HEX records from a reader, every data byte decoded is handed to the store function. Byte
+// counts and checksums are checked, addresses must fit the 16-bit HC05 address space
+// Parameters: Reader supplying the records, name used in error messages, function storing one byte at an HC05 address
+// Returns: Description of the file (same as for S-records, there is no header), error (*srec.Error) if any
+// -------------------------------------------------------------------------------------------------------------------
+func Read(records io.Reader, name string, store func(address uint16, data byte) error) (srec.Info, error) {
+
+	var info srec.Info
+	var base uint32 // From the extended address records
+	var ended = false
+	var number = 0
+
+	lines := bufio.NewScanner(records)
+	for lines.Scan() {
+		number++
+		fail := func(format string, a ...interface{}) (srec.Info, error) {
+			return info, &srec.Error{File: name, Line: number, Err: fmt.Errorf(format, a...)}
+		}
+		line := strings.TrimSpace(lines.Text())
+		if line == "" {
+			continue
+		}
+
+		// :ll aaaa tt dd... cc
+		if line[0] != ':' {
+			return fail("not an Intel HEX record")
+		}
+		if ended {
+			return fail("record after the end of file record")
+		}
+		record, err := hex.DecodeString(line[1:])
+		if err != nil {
+			return fail("invalid hexadecimal digits")
+		}
+		if len(record) < 5 || int(record[0]) != len(record)-5 {
+			return fail("byte count does not match the length of the record")
+		}
+		var checksum byte
+		for _, b := range record {
+			checksum += b
+		}
+		if checksum != 0 {
+			return fail("checksum %02X, expected %02X", record[len(record)-1], record[len(record)-1]-checksum)
+		}
+		address := uint32(record[1])<<8 | uint32(record[2])
+		data := record[4 : len(record)-1]
+
+		switch record[3] {
+		case DATA:
+			address += base
+			if address+uint32(len(data)) > 0x10000 {
+				return fail("data at %X-%X is beyond the HC05 address space", address, address+uint32(len(data))-1)
+			}
+			info.Records++
+			if len(data) == 0 {
+				continue
+			}
+			if info.Length == 0 {
+				info.First = uint16(address)
+				info.Lowest = uint16(address)
+			}
+			for n, b := range data {
+				err = store(uint16(address)+uint16(n), b)
+				if err != nil {
+					return fail("%w", err)
+				}
+			}
+			if uint16(address) < info.Lowest {
+				info.Lowest = uint16(address)
+			}
+			if last := uint16(address) + uint16(len(data)) - 1; last > info.Highest {
+				info.Highest = last
+			}
+			info.Length += len(data)
+		case END_OF_FILE:
+			ended = true
+		case EXTENDED_SEGMENT_ADDRESS, EXTENDED_LINEAR_ADDRESS:
+			if len(data) != 2 {
+				return fail("extended address record must hold 2 bytes")
+			}
+			base = uint32(data[0])<<8 | uint32(data[1])
+			if record[3] == EXTENDED_SEGMENT_ADDRESS {
+				base <<= 4
+			} else {
+				base <<= 16
+			}
+		case START_SEGMENT_ADDRESS, START_LINEAR_ADDRESS:
+			if len(data) != 4 {
+				return fail("start address record must hold 4 bytes")
+			}
+			start := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+			if record[3] == START_SEGMENT_ADDRESS {
+				start = (start>>16)<<4 + start&0xFFFF
+			}
+			if start > 0xFFFF {
+				return fail("start address %X is beyond the HC05 address space", start)
+			}
+			info.Start = uint16(start)
+			info.HasStart = start != 0
+		default:
+			return fail("record type %02X is not valid", record[3])
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return info, &srec.Error{File: name, Line: number, Err: err}
+	}
+	return info, nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Write
+// Function: Write segments as data records of up to 16 bytes, terminated by an end of file record
+// Parameters: Writer, segments
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func Write(w io.Writer, segments []srec.Segment) error {
+
+	for _, segment := range segments {
+		for offset := 0; offset < len(segment.Data); offset += 16 {
+			end := offset + 16
+			if end > len(segment.Data) {
+				end = len(segment.Data)
+			}
+			err := writeRecord(w, DATA, segment.Address+uint16(offset), segment.Data[offset:end])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return writeRecord(w, END_OF_FILE, 0, nil)
+}
+
+// writeRecord writes a single record, the checksum is the two's complement of the sum of every other byte
+func writeRecord(w io.Writer, recordtype byte, address uint16, data []byte) error {
+
+	checksum := byte(len(data)) + byte(address>>8) + byte(address) + recordtype
+	line := fmt.Sprintf(":%02X%04X%02X", len(data), address, recordtype)
+	for _, b := range data {
+		line += fmt.Sprintf("%02X", b)
+		checksum += b
+	}
+	_, err := fmt.Fprintf(w, "%s%02X\n", line, -checksum)
+	return err
+}
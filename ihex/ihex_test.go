@@ -0,0 +1,104 @@
+package ihex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sonikku2k/PROG05/srec"
+)
+
+// store accepts every address once
+func store() func(address uint16, data byte) error {
+	loaded := make(map[uint16]bool)
+	return func(address uint16, data byte) error {
+		if loaded[address] {
+			return fmt.Errorf("address %04X is loaded twice", address)
+		}
+		loaded[address] = true
+		return nil
+	}
+}
+
+func TestRead(t *testing.T) {
+	cases := []struct {
+		name    string
+		records string
+		want    srec.Info
+	}{
+		{"data", ":04005100AE043F0EAC\r\n\r\n:02005500A60CF7\r\n:00000001FF\r\n",
+			srec.Info{Length: 6, First: 0x0051, Lowest: 0x0051, Highest: 0x0056, Records: 2}},
+		{"start record only", ":0400000500000051A6\n:00000001FF\n",
+			srec.Info{Start: 0x0051, HasStart: true}},
+		{"empty data record", ":00005100AF\n:02005500A60CF7\n",
+			srec.Info{Length: 2, First: 0x0055, Lowest: 0x0055, Highest: 0x0056, Records: 2}},
+		{"extended address of zero", ":020000040000FA\n:01002000558A\n",
+			srec.Info{Length: 1, First: 0x0020, Lowest: 0x0020, Highest: 0x0020, Records: 1}},
+	}
+	for _, c := range cases {
+		info, err := Read(strings.NewReader(c.records), "test.hex", store())
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if info != c.want {
+			t.Errorf("%s: info %+v, want %+v", c.name, info, c.want)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		records string
+		line    int
+		message string
+	}{
+		{"bad checksum", ":04005100AE043F0EAC\n:02005500A60CF8\n", 2, "checksum F8, expected F7"},
+		{"data past FFFF", ":020000040001F9\n:0100000055AA\n", 2, "data at 10000-10000 is beyond the HC05 address space"},
+		{"segment past FFFF", ":020000021000EC\n:0100000055AA\n", 2, "data at 10000-10000 is beyond the HC05 address space"},
+		{"truncated record", ":\n", 1, "byte count does not match"},
+		{"byte count too large", ":05005100AE043F0EAB\n", 1, "byte count does not match"},
+		{"odd hexadecimal digits", ":02005500A60CF\n", 1, "invalid hexadecimal digits"},
+		{"not a record", "S1050055A60CF3\n", 1, "not an Intel HEX record"},
+		{"data after the end", ":00000001FF\n:02005500A60CF7\n", 2, "record after the end of file record"},
+		{"unknown record type", ":00000006FA\n", 1, "record type 06 is not valid"},
+		{"start beyond FFFF", ":0400000500010000F6\n", 1, "start address 10000 is beyond"},
+		{"duplicate address", ":02005500A60CF7\n:0100560000A9\n", 2, "address 0056 is loaded twice"},
+	}
+	for _, c := range cases {
+		_, err := Read(strings.NewReader(c.records), "test.hex", store())
+		var located *srec.Error
+		if !errors.As(err, &located) {
+			t.Errorf("%s: error %v, want a *srec.Error", c.name, err)
+			continue
+		}
+		if located.File != "test.hex" || located.Line != c.line {
+			t.Errorf("%s: reported at %s:%d, want test.hex:%d", c.name, located.File, located.Line, c.line)
+		}
+		prefix := fmt.Sprintf("test.hex:%d: ", c.line)
+		if !strings.HasPrefix(err.Error(), prefix) || !strings.Contains(err.Error(), c.message) {
+			t.Errorf("%s: error %q, want %q%s", c.name, err, prefix, c.message)
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	segments := []srec.Segment{{Address: 0x0051, Data: []byte{1, 2, 3}}, {Address: 0x1FF0, Data: make([]byte, 16)}}
+	var records strings.Builder
+	if err := Write(&records, segments); err != nil {
+		t.Fatal(err)
+	}
+	stored := make(map[uint16]byte)
+	info, err := Read(strings.NewReader(records.String()), "written", func(address uint16, data byte) error {
+		stored[address] = data
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Length != 19 || len(stored) != 19 || stored[0x0053] != 3 || info.Highest != 0x1FFF {
+		t.Fatalf("read back %+v, %d bytes stored", info, len(stored))
+	}
+}
@@ -2,271 +2,514 @@ package main
 
 import (
 	"bufio"
-	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/matishsiao/goInfo"
-	"go.bug.st/serial"
+	"github.com/sonikku2k/PROG05/assembler"
+	"github.com/sonikku2k/PROG05/board"
+	"github.com/sonikku2k/PROG05/bootloader"
+	"github.com/sonikku2k/PROG05/config"
+	"github.com/sonikku2k/PROG05/emulator"
+	"github.com/sonikku2k/PROG05/hc05"
+	"github.com/sonikku2k/PROG05/programmer"
+	"github.com/sonikku2k/PROG05/simulator"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// Struct for settings read from the config.json file
-// ----------------------------------------------------
-type Settings struct {
-	Port        string
-	Targetclock string
-}
-
 // Main Variables
-var rambuffer = make([]byte, 255) // Main RAM buffer (1:1) correspondence with HC05 RAM in bootloader mode
-var length byte = 1               // Length indicator sent to the bootloader, the count includes itself hence we set it to 1
-var selector = 0
-
-var rxbuffer = make([]byte, 1024) // Main serial reception buffer
-var rxbuffercount = 0
-var tmpbuf = make([]byte, 100)
-var port serial.Port
-
-const RAM_0050 = 1
-
-// Menu Selection constants
-const DUMP_BUFFER_REGION_A = 10
-
-// 68HC705C8 memory area images
-//------------------------------
-
-var RAM = make([]byte, 176)      // Main RAM + STACK
-var USER_PROM = make([]byte, 96) // If RAM1 bit = 0
-var RAM2 = make([]byte, 96)      // If RAM1 bit = 1
-var PROM = make([]byte, 7584)
-var OPTION_REGISTER byte            // Address 0x1FDF
-var MASK_OPTION_REGISTER1 byte      // Address 0x1FF0
-var MASK_OPTION_REGISTER2 byte      // Address 0x1FF1
-var PROM_VECTORS = make([]byte, 12) // 1FF2 - 1FFF
-
-var RAM_SIZE_LOADED uint16 = 0
-var RAM_PROGRAM_START uint16 = 0
-
-var userinput string
-var errtype error
-var addr_hi uint8
-var addr_lo uint8
-var data_byte uint8
-var mcuaddress uint16
-var mcudump = make([]byte, 8192)
-
-//-------------------------------------------------------------------------------------------------------------------
-// Utility Functions
-//-------------------------------------------------------------------------------------------------------------------
-
-func asciihex2bin(digit1 byte, digit2 byte) byte {
-	var returnvalue byte = 0
-
-	if digit1 > 0x2F && digit1 < 0x3A || digit1 > 0x40 && digit1 < 0x47 {
-		// Value is between ASCII '0'..'9' and ASCII 'A'..'F'
-		if digit1 > 0x2F && digit1 < 0x3A {
-			returnvalue = digit1 - 0x30
+var prog *programmer.Programmer // Programmer attached to the serial port, owns the memory images and buffers
+var portname string             // Serial port the programmer is attached to (SIM and EMU included)
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: DumpMemory
+// Function: Hex dump to the console, the memory area passed by reference and the size of the passed memory area.
+// Each line carries an ASCII column, runs of erased lines are collapsed into a single '*'
+//
+// Parameters: Pointer to buffer, Size of memory area (in bytes), HC05 address of the first byte
+// Returns: void
+// -------------------------------------------------------------------------------------------------------------------
+func DumpMemory(buffer []byte, size int, offset uint16) {
+
+	var previouserased = false
+	var skipping = false
+	for addr := 0; addr < size; addr += 16 {
+		end := addr + 16
+		if end > size {
+			end = size
 		}
-		// Value is between ASCII 'A'.. 'F'
-		if digit1 > 0x40 && digit1 < 0x47 {
-			returnvalue = digit1 - 0x37
+		line := buffer[addr:end]
+
+		// A line that only holds erased bytes is printed once, repeats are skipped (the last line is always shown)
+		erased := true
+		for _, b := range line {
+			if b != prog.Erased {
+				erased = false
+				break
+			}
 		}
-		returnvalue = returnvalue << 4
-	}
-
-	if digit2 > 0x2F && digit2 < 0x3A || digit2 > 0x40 && digit2 < 0x47 {
-		// Value is between ASCII '0'..'9' and ASCII 'A'..'F'
-		if digit2 > 0x2F && digit2 < 0x3A {
-			returnvalue |= digit2 - 0x30
+		if erased && previouserased && end < size {
+			if !skipping {
+				fmt.Println("*")
+				skipping = true
+			}
+			continue
 		}
-		// Value is between ASCII 'A'.. 'F'
-		if digit2 > 0x40 && digit2 < 0x47 {
-			returnvalue |= digit2 - 0x37
+		previouserased = erased
+		skipping = false
+
+		var hexpart strings.Builder
+		var asciipart strings.Builder
+		for n := 0; n < 16; n++ {
+			if n == 8 {
+				hexpart.WriteString("   ")
+			}
+			if n >= len(line) {
+				hexpart.WriteString("   ")
+				continue
+			}
+			fmt.Fprintf(&hexpart, " %02X", line[n])
+			if line[n] >= 0x20 && line[n] < 0x7F {
+				asciipart.WriteByte(line[n])
+			} else {
+				asciipart.WriteByte('.')
+			}
 		}
-
+		fmt.Printf("%04X:  %s |%s|\r\n", uint16(addr)+offset, hexpart.String(), asciipart.String())
 	}
-	return returnvalue
 }
 
-// LoadSrec --------------------------------------------------------------------------------------------------------
-// Name: LoadSrec
-// Function: Load Motorola S-Record file from the disk and parse it, then decode all the data to binary and store
-//
-//	that in the appropriate buffer
+// -------------------------------------------------------------------------------------------------------------------
+// Name: VerifyPromImages
+// Function: Compare the EPROM images with the HC05 (memblock applet running), print each mismatch and a summary
 //
-// Parameters: Full path to the file that shall be opened, Target Area in MCU, Pointer to variable where length shall be stored
-// Returns: Result of operation
-// ----------------------------------------------------------------------------------------------------------------
-func LoadSrec(path string, targetarea uint8, objectlength *uint16) int {
-
-	var address uint16
+// Returns: 0 if every byte matches, -1 if not
+// -------------------------------------------------------------------------------------------------------------------
+func VerifyPromImages() int {
 
-	*objectlength = 0
-	srec, err := os.Open(path)
+	failures, err := prog.VerifyPromImages()
+	for _, f := range failures {
+		fmt.Printf(" Mismatch at %04X: expected %02X actual %02X\r\n", f.Address, f.Expected, f.Actual)
+	}
 	if err != nil {
-		fmt.Println(" Error opening file! ")
+		fmt.Println(" Error:", err)
 		return -1
 	}
-	defer srec.Close()
-	srecords := bufio.NewScanner(srec)
-	for srecords.Scan() {
-		line := srecords.Text()
-		// Each line of the S-record is parsed here
-		if line[0] == 'S' && line[1] == '1' {
-			// Valid S-Record, extract record length
-
-			len := asciihex2bin(line[2], line[3])
-			// The length value includes 3 extra i.e. address and check byte, so we subtract to get total size of bytes
-			len -= 3
-			address = uint16(asciihex2bin(line[4], line[5]))
-			address = address << 8
-			address |= uint16(asciihex2bin(line[6], line[7]))
-
-			// We now have the address and we have the data, see where we must store it
-			if targetarea == RAM_0050 {
-				// Target memory is the MCU RAM, the address supplied must fall in that range
-				if address < 0x0050 && address > 0x00BF {
-					fmt.Println(" Error: S-Record address falls outside of allowable memory range!")
-					return -1
-				}
-				// The very first S-record is usually where the program starts, so we grab that as the start address of the program
-				if RAM_PROGRAM_START == 0 {
-					RAM_PROGRAM_START = address
-				}
-				// Range check is OK, decode s-record to the buffer
-				var offset = 8
-				for n := 0; n < int(len); n++ {
-					RAM[(address-0x50)+uint16(n)] = asciihex2bin(line[offset], line[offset+1])
-					offset += 2
-					*objectlength++
-				}
-			}
-		}
+	fmt.Printf(" Read %s\r\n", prog.Transfer)
+	if len(failures) != 0 {
+		fmt.Printf(" Verify [FAILED] - %d of %d bytes differ\r\n", len(failures), prog.PromSizeLoaded)
+		return -1
 	}
-
+	fmt.Printf(" Verify [OK] - %d bytes match\r\n", prog.PromSizeLoaded)
 	return 0
 }
 
 // -------------------------------------------------------------------------------------------------------------------
-// Name: DumpMemory
-// Function: Hex dump to the console, the memory area passed by reference and the size of the passed memory area
-// Parameters: Pointer to buffer, Size of memory area (in bytes)
-// Returns: void
+// Name: AssembleSource
+// Function: Assemble an HC05 source file and write the S-record file next to it (same name, .s19 extension)
+// Parameters: Path to the source file
+// Returns: error if any
 // -------------------------------------------------------------------------------------------------------------------
-func DumpMemory(buffer []byte, size int, offset uint16) {
+func AssembleSource(path string) error {
 
-	var addr uint16 = 0
-	for {
-		fmt.Printf("%04X:   %02X %02X %02X %02X %02X %02X %02X %02X    %02X %02X %02X %02X %02X %02X %02X %02X |\r\n", addr+offset,
-			buffer[addr+0], buffer[addr+1], buffer[addr+2], buffer[addr+3], buffer[addr+4], buffer[addr+5], buffer[addr+6], buffer[addr+7],
-			buffer[addr+8], buffer[addr+9], buffer[addr+10], buffer[addr+11], buffer[addr+12], buffer[addr+13], buffer[addr+14], buffer[addr+15])
-		addr += 16
-		if addr >= uint16(size) {
-			break
-		}
+	program, err := assembler.AssembleFile(path)
+	if err != nil {
+		return err
+	}
+	output := strings.TrimSuffix(path, filepath.Ext(path)) + ".s19"
+	file, err := os.Create(output)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
+	if err = program.WriteSrec(file); err != nil {
+		return err
+	}
+	size := 0
+	for _, segment := range program.Segments {
+		size += len(segment.Data)
+	}
+	fmt.Printf(" %d bytes assembled, %d symbols, written to %s\r\n", size, len(program.Symbols), output)
+	return nil
 }
 
 // -------------------------------------------------------------------------------------------------------------------
-// Name: ReadByteFromMCU
-// Function: Reads byte from specified address in the HC05
-// Parameters: Address, pointer to location where read data will be stored
-// Returns: 0 if OK, -1 if error
+// Name: Disassembly
+// Function: Print the disassembly of an address range of the MCU dump, the PROM images or the RAM buffer
+// Parameters: Console reader, arguments given with the command (source, start, end), missing ones are asked for
 // -------------------------------------------------------------------------------------------------------------------
-func ReadByteFromMCU(address uint16, data *uint8) int {
+func Disassembly(reader *bufio.Reader, args []string) {
 
-	var readtimeout = 0
-	addr_hi = uint8((address >> 8) & 0xFF)
-	addr_lo = uint8(address & 0xFF)
-	//fmt.Printf(" [DEBUG] Address bytes: %02X %02X\r\n", addr_hi, addr_lo)
+	source := Argument(reader, args, 0, "Source (M: MCU dump, P: PROM images, R: RAM buffer):")
 
-	// Clear serial receive buffer
-	for i := 0; i < 1024; i++ {
-		rxbuffer[i] = 0
+	var read func(address uint16) byte
+	var labels map[uint16]string
+	switch strings.ToUpper(source) {
+	case "M":
+		read = func(address uint16) byte {
+			return prog.McuDump[address&0x1FFF]
+		}
+		labels = hc05.VectorLabels(read)
+	case "P":
+		read = func(address uint16) byte {
+			data, err := prog.Memory.Read(address & 0x1FFF)
+			if err != nil || !prog.Memory.Region(address&0x1FFF).IsProm() {
+				return prog.Erased
+			}
+			return data
+		}
+		labels = hc05.VectorLabels(read)
+	case "R":
+		read = func(address uint16) byte {
+			if prog.Memory.Region(address).Kind != hc05.REGION_RAM {
+				return 0
+			}
+			data, _ := prog.Memory.Read(address)
+			return data
+		}
+		labels = map[uint16]string{0x51: "START"}
+	default:
+		fmt.Println(" Invalid user input- must be M, P or R")
+		return
 	}
-	rxbuffercount = 0
 
-	// Then, transmit address to program running in the HC05
-	var p = make([]byte, 1)
-	p[0] = addr_hi
-	_, err := port.Write(p[0:])
+	start, err := ParseHex(Argument(reader, args, 1, "Start address (in hexadecimal):"), 16)
 	if err != nil {
-		fmt.Println("Error Sending byte on serial port...(1) ")
-		return -1
+		fmt.Println(" Invalid user input- must be 4 hexadecimal digits (format: nnnn)")
+		return
 	}
-	time.Sleep(1 * time.Millisecond)
-	p[0] = addr_lo
-	_, err = port.Write(p[0:])
-	if err != nil {
-		fmt.Println("Error Sending byte on serial port...(2) ")
-		return -1
+	end, err := ParseHex(Argument(reader, args, 2, "End address (in hexadecimal):"), 16)
+	if err != nil || end < start {
+		fmt.Println(" Invalid user input- must be 4 hexadecimal digits, not below the start address")
+		return
+	}
+	for _, line := range hc05.DisassembleRange(read, start, end, labels) {
+		fmt.Printf("%s\r\n", line)
 	}
-	readtimeout = 500
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: RunSimulator
+// Function: Run the program held in the RAM buffer on the HC05 emulator, one trace line per instruction
+// Parameters: Console reader
+// -------------------------------------------------------------------------------------------------------------------
+func RunSimulator(reader *bufio.Reader) {
+
+	chip := emulator.NewChip(prog.Erased)
+	selector := int(prog.RamProgramStart - 0x50)
+	chip.LoadApplet(prog.Images.RAM[selector : selector+int(prog.RamSizeLoaded)])
+	chip.Trace = os.Stdout
+
+	fmt.Println("     -- HC05 emulator: ENTER = step, n = run n instructions, S hh = send byte to SCI, ")
+	fmt.Println("        P = show ports, Q = exit --    ")
 	for {
-		time.Sleep(10 * time.Microsecond) // Sleep for 10uS
-		if rxbuffercount > 0 {
-			break
+		fmt.Printf("SIM>")
+		keyinput, err := ReadLine(reader)
+		if err != nil {
+			return
 		}
-		readtimeout--
-		if readtimeout == 0 {
-			break
+		keyinput = strings.ToUpper(keyinput)
+
+		steps := 1
+		switch {
+		case keyinput == "Q":
+			fmt.Println("     -- HC05 emulator terminated --    ")
+			return
+		case keyinput == "P":
+			fmt.Printf(" PORTA = %02X  PORTB = %02X  PORTC = %02X\r\n", chip.Port(0), chip.Port(1), chip.Port(2))
+			continue
+		case strings.HasPrefix(keyinput, "S "):
+			data, err := strconv.ParseUint(strings.TrimSpace(keyinput[2:]), 16, 8)
+			if err != nil {
+				fmt.Println(" Invalid user input- must be 2 hexadecimal digits (format: S nn)")
+			} else {
+				chip.ReceiveByte(byte(data))
+			}
+			continue
+		case keyinput != "":
+			n, err := strconv.Atoi(keyinput)
+			if err != nil || n < 1 {
+				fmt.Println(" Invalid user input")
+				continue
+			}
+			steps = n
 		}
-	}
 
-	// Get byte received
-	if readtimeout == 0 {
-		fmt.Println(" Response timeout...")
-		return -1
+		for n := 0; n < steps; n++ {
+			err := chip.Step()
+			if err != nil {
+				fmt.Println(" Error:", err)
+				break
+			}
+		}
+		sent := chip.Transmitted()
+		if len(sent) > 0 {
+			fmt.Printf(" SCI sent: % X  %q\r\n", sent, sent)
+		}
 	}
-	if rxbuffercount > 0 {
-		readbyte := rxbuffer[0]
-		//fmt.Printf(" Value Read: %02X\r\n", readbyte)
-		*data = readbyte
-		return 0
-	} else {
-		fmt.Println(" Error reading memory")
-		return -1
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: IsBatchMode
+// Function: Tells whether the commands come from a script (stdin redirected) rather than from a user at a console
+// Returns: true if running in batch mode
+// -------------------------------------------------------------------------------------------------------------------
+func IsBatchMode() bool {
+
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
 	}
+	return info.Mode()&os.ModeCharDevice == 0
+}
 
+// ------------------------------------------------------------------------------
+// Name: PrintVppInstruction
+// Function: Print out instructions to apply the programming voltage to the console
+// ------------------------------------------------------------------------------
+func PrintVppInstruction() {
+	fmt.Println("Programming applet is running, now apply the programming voltage:")
+	fmt.Println("  * Switch Vpp ON at the programmer board (check your board documentation)")
+	fmt.Println("  **** PRESS ENTER WHEN READY ***")
 }
 
 // ------------------------------------------------------------------------------
 // Name: PrintHC05LoaderInstruction
 // Function: Print out instructions to invoke the HC05 bootloader to the console
+// Parameters: Board of the profile in use (the instructions of both known boards are given for any other), true when
+// PROG05 resets the HC05 itself
 // ------------------------------------------------------------------------------
-func PrintHC05LoaderInstruction() {
-	fmt.Println("Please enable loader either by: ")
-	fmt.Println("  * MC68HC05PGMR: S3-S5 = OFF, S6 = ON, shunt across Pin 1 & 2 of J1")
-	fmt.Println("  * MIDON PROG05: shunt across pins 1 & 2 of J1")
-	fmt.Println("Then, release reset by:")
-	fmt.Println("  * MC68HC05PGMR: switch S2 from RESET -> OUT")
-	fmt.Println("  * MIDON PROG05: Press and release SW1")
+func PrintHC05LoaderInstruction(name string, reset bool) {
+	switch name {
+	case config.BOARD_PGMR:
+		fmt.Println("Please enable loader by: S3-S5 = OFF, S6 = ON, shunt across Pin 1 & 2 of J1")
+		if !reset {
+			fmt.Println("Then, release reset by: switch S2 from RESET -> OUT")
+		}
+	case config.BOARD_MIDON:
+		fmt.Println("Please enable loader by: shunt across pins 1 & 2 of J1")
+		if !reset {
+			fmt.Println("Then, release reset by: Press and release SW1")
+		}
+	default:
+		fmt.Println("Please enable loader either by: ")
+		fmt.Println("  * MC68HC05PGMR: S3-S5 = OFF, S6 = ON, shunt across Pin 1 & 2 of J1")
+		fmt.Println("  * MIDON PROG05: shunt across pins 1 & 2 of J1")
+		if !reset {
+			fmt.Println("Then, release reset by:")
+			fmt.Println("  * MC68HC05PGMR: switch S2 from RESET -> OUT")
+			fmt.Println("  * MIDON PROG05: Press and release SW1")
+		}
+	}
+	if reset {
+		fmt.Println("The HC05 is reset by PROG05 once ENTER is pressed")
+	}
 	fmt.Println("  **** PRESS ENTER WHEN READY ***")
 }
 
-// Name: ShowCommands
-// Function: Print out all the available commands to the console
-// ----------------------------------------------------------------
-func ShowCommands() {
-	fmt.Println("***************** PROG05 COMMAND OPTIONS *********************")
-	fmt.Println("Available Commands:")
-	fmt.Println(" * TEST    - Load test program into HC05 and check response (supports official boards and MIDON PROG05 programmer)")
-	fmt.Println(" * DUMP    - Dump internal buffer by area (A: RAM ($20-$4F), B: PROM ($160-$1EFF))")
-	fmt.Println(" * DEMO    - Load simple demonstration program into HC05 that toggles PORT A pins (use this to confirm your MCU is OK)")
-	fmt.Println(" * LOADRAM - Load user application into HC05 RAM and execute (specify a .S19 file)")
-	fmt.Println(" * LOAD    - Load user application into memory for EPROM programming")
-	fmt.Println(" * READ    - Read a specified memory address in the HC05 memory map")
-	fmt.Println(" * WRITE   - Write a specified memory address in the HC05 memory map")
-	fmt.Println(" * DUMPMCU - Read entire HC05 address space and display as hexdump (only works if device is unsecured)")
-	fmt.Println(" * QUIT    - Quit this program ")
+// Crystal of the simulated and emulated HC05 when the clock is to be probed, so the probe has something to find
+const SIMULATED_CLOCK = 4000000
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ProbeTargetClock
+// Function: Find the crystal of the HC05 by uploading the test applet at the SCI rate of each usual clock
+// Returns: error if the target did not answer at any of them
+// -------------------------------------------------------------------------------------------------------------------
+func ProbeTargetClock() error {
+
+	fmt.Fprintln(prog.Out, "Probing the target clock, the test program is uploaded at each candidate rate")
+	clock, err := prog.ProbeClock(hc05.PROBE_CLOCKS)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(prog.Out, "Target clock detected: %s (%d baud), set \"targetclock\": \"%s\" in the configuration to skip the probe\r\n",
+		hc05.FormatClock(clock), prog.BaudRate, hc05.FormatClock(clock))
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: PrintPorts
+// Function: List the serial ports of the computer with the USB details of each adapter
+// Parameters: Output, port in use (marked with '*', "" for none)
+// Returns: error if the ports cannot be listed
+// -------------------------------------------------------------------------------------------------------------------
+func PrintPorts(out io.Writer, current string) error {
+
+	ports, err := bootloader.ListPorts()
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		fmt.Fprintln(out, " No serial port found")
+		return nil
+	}
+	for _, port := range ports {
+		mark := " "
+		if port.Name == current {
+			mark = "*"
+		}
+		fmt.Fprintf(out, "%s %s\r\n", mark, port)
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: FindAdapter
+// Function: Find the port of the USB-to-serial adapter for "port": "auto", among the adapters PROG05 knows (CP2102,
+// FT232 series)
+// Parameters: Function picking one of several adapters (nil when there is no one to ask, several adapters are then an
+// error)
+// Returns: Port name, error if no adapter or more than one was found and none was picked
+// -------------------------------------------------------------------------------------------------------------------
+func FindAdapter(choose func(adapters []bootloader.PortInfo) (int, error)) (string, error) {
+
+	ports, err := bootloader.ListPorts()
+	if err != nil {
+		return "", err
+	}
+	return bootloader.PickAdapter(ports, choose)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ChooseAdapter
+// Function: Ask the user which of several USB-to-serial adapters the programmer is connected to
+// Parameters: Console reader, adapters found
+// Returns: Index of the adapter, error if the console was closed
+// -------------------------------------------------------------------------------------------------------------------
+func ChooseAdapter(reader *bufio.Reader, adapters []bootloader.PortInfo) (int, error) {
+
+	fmt.Println("Several USB-to-serial adapters found:")
+	for n, adapter := range adapters {
+		fmt.Printf("  %d: %s\r\n", n+1, adapter)
+	}
+	for {
+		fmt.Printf("Adapter connected to the programmer (1-%d): ", len(adapters))
+		line, err := ReadLine(reader)
+		if err != nil {
+			return 0, errors.New("no adapter selected")
+		}
+		n, err := strconv.Atoi(line)
+		if err == nil && n >= 1 && n <= len(adapters) {
+			return n - 1, nil
+		}
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: OpenTarget
+// Function: Open the link to the HC05 named in the settings: a serial port, or SIM/EMU when there is no hardware
+// Parameters: Settings, crystal frequency of the HC05 (0 when it is to be probed, the port starts at the rate of the
+// first clock probed)
+// Returns: Transport, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func OpenTarget(workingset config.Settings, clock int) (bootloader.Transport, error) {
+
+	baudrate := workingset.BaudRate(clock)
+	simulated := clock
+	if clock == 0 {
+		baudrate = hc05.BootloaderBaudRate(hc05.PROBE_CLOCKS[0])
+		simulated = SIMULATED_CLOCK
+	}
+	if workingset.Port == "SIM" {
+		// No hardware, a simulated HC05 answers instead
+		target := simulator.New(workingset.Erased)
+		err := target.RegisterShippedApplets(workingset.Applets)
+		if err != nil {
+			return nil, fmt.Errorf("error loading applets into the simulator: %w", err)
+		}
+		target.Vpp = true
+		target.Baud = hc05.BootloaderBaudRate(simulated)
+		target.SetBaudRate(baudrate)
+		fmt.Fprintln(os.Stderr, "Using simulated target (no hardware)")
+		return target, nil
+	} else if workingset.Port == "EMU" {
+		// No hardware, the uploaded code runs on the HC05 emulator (bus clock is half the crystal)
+		target := emulator.NewTarget(simulated/2, workingset.Erased)
+		target.Chip.Vpp = true
+		target.Baud = hc05.BootloaderBaudRate(simulated)
+		target.SetBaudRate(baudrate)
+		fmt.Fprintln(os.Stderr, "Using emulated target (no hardware)")
+		return target, nil
+	}
+	return bootloader.Open(workingset.Port, baudrate)
+}
+
+// ReportViolations prints the board timing rules the simulated HC05 found broken, other targets do not check them
+func ReportViolations(out io.Writer) {
+	if target, ok := prog.Port.(*simulator.Target); ok {
+		for _, violation := range target.Violations() {
+			fmt.Fprintln(out, " Simulator:", violation)
+		}
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: OpenBoard
+// Function: Set up the board signals PROG05 drives (autoreset in the settings): through the modem lines of the port,
+// the outputs of a GPIO controller, or wired straight into the simulated HC05
+// Parameters: Settings, link to the HC05
+// Returns: Board control (nil when the operator sets the signals), error if any
+// -------------------------------------------------------------------------------------------------------------------
+func OpenBoard(workingset config.Settings, port bootloader.Transport) (*board.Control, error) {
+
+	reset := workingset.AutoReset
+	if reset == nil {
+		return nil, nil
+	}
+	var control *board.Control
+	switch target := port.(type) {
+	case *simulator.Target:
+		// Every signal of the simulated board is driven, whatever the pin map
+		target.WireBoard()
+		control = board.Direct(target)
+	case *emulator.Target:
+		return nil, errors.New("the emulated target has no board signals, remove autoreset or use SIM")
+	default:
+		pins, problems := board.ParsePins(reset.PinMap(), reset.Gpio != "")
+		if len(problems) != 0 {
+			return nil, errors.New(strings.Join(problems, ", "))
+		}
+		control = &board.Control{Pins: pins}
+		if reset.Gpio != "" {
+			gpio, err := bootloader.Open(reset.Gpio, board.GPIO_BAUD_RATE)
+			if err != nil {
+				return nil, fmt.Errorf("cannot open the GPIO controller on %s: %w", reset.Gpio, err)
+			}
+			control.Driver = board.Gpio{Port: gpio}
+		} else if lines, ok := port.(board.ModemLines); ok {
+			control.Driver = board.Modem{Port: lines}
+		} else {
+			return nil, errors.New("the port has no modem lines")
+		}
+		control.Pulse, control.Settle, control.Vpp = board.RESET_PULSE, board.RESET_SETTLE, board.VPP_SETTLE
+	}
+	if reset.Pulse != 0 {
+		control.Pulse = time.Duration(reset.Pulse) * time.Millisecond
+	}
+	if reset.Settle != 0 {
+		control.Settle = time.Duration(reset.Settle) * time.Millisecond
+	}
+	if reset.Vpp != 0 {
+		control.Vpp = time.Duration(reset.Vpp) * time.Millisecond
+	}
+	return control, control.Idle()
 }
 
 // -------------------------------------------------------------------------------------------------------------------
 // Main Function
 // -------------------------------------------------------------------------------------------------------------------
 func main() {
+
+	// A command selects the non-interactive mode used by scripts, configuration flags alone go to the interactive mode
+	options, overrides, interactive := InteractiveFlags(os.Args[1:])
+	if !interactive {
+		os.Exit(RunCommandLine(os.Args[1:]))
+	}
+
 	fmt.Println("                                              ")
 	fmt.Println("╔════════════════════════════════════════════╗")
 	fmt.Println("║   PROG05 - A modern 68HC705C8 Programmer   ║")
@@ -274,602 +517,122 @@ func main() {
 	fmt.Println("╚════════════════════════════════════════════╝")
 	fmt.Println("                                              ")
 
-	// Open the config.json file and see what port is specified for use to talk to the hardware
-	content, err := os.ReadFile("./config.json")
-	if err != nil {
-		fmt.Println("Unable to open configuration file: ", err)
-	}
-
 	// Print OS information here...
 	gi, _ := goInfo.GetInfo()
 	fmt.Printf("  OS: %s  VER: %s \r\n\r\n", gi.GoOS, gi.Core)
 
-	var workingset Settings
-	err = json.Unmarshal(content, &workingset)
+	// Find the configuration file and see what port is specified for use to talk to the hardware
+	workingset, err := config.Load(options.Config, options.Profile, overrides)
 	if err != nil {
 		fmt.Println("Configuration file contains invalid data: ", err)
 		fmt.Println("Program will now quit!")
 		os.Exit(0)
 	}
-	var tstr string
-	tstr = "Configuration Loaded- Port " + workingset.Port + " is assigned"
-	fmt.Println(tstr)
-	tstr = "Target clock frequency: " + workingset.Targetclock
-	fmt.Println(tstr)
+	clock, _ := workingset.Clock()
+	if workingset.Source != "" {
+		fmt.Println("Configuration Loaded from " + workingset.Source)
+	} else {
+		fmt.Println("No configuration file, settings taken from the environment")
+	}
+	if workingset.ProfileName != "" {
+		fmt.Printf("Profile %s, board %s\r\n", workingset.ProfileName, workingset.Board)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	if strings.EqualFold(workingset.Port, bootloader.AUTO_PORT) {
+		workingset.Port, err = FindAdapter(func(adapters []bootloader.PortInfo) (int, error) {
+			return ChooseAdapter(reader, adapters)
+		})
+		if err != nil {
+			fmt.Println("Error finding the serial port:", err)
+			fmt.Println("Program will now quit")
+			os.Exit(0)
+		}
+	}
+	fmt.Println("Port " + workingset.Port + " is assigned")
+	if clock == 0 {
+		fmt.Println("Target clock frequency: auto (probed at startup)")
+	} else {
+		fmt.Printf("Target clock frequency: %s (%d baud)\r\n", hc05.FormatClock(clock), workingset.BaudRate(clock))
+	}
+	fmt.Printf("Erased EPROM value: %02X\r\n", workingset.Erased)
 
 	// Attempt to open port specified in config file
-	mode := &serial.Mode{
-		BaudRate: 4800, // In the absence of being told otherwise, we assume the CPU is clocked at 2MHz
-		Parity:   serial.NoParity,
-		DataBits: 8,
-		StopBits: serial.OneStopBit,
-	}
-	// If the higher clock frequency is selected we go for it, otherwise we do the Motorola default of 2MHz
-	if strings.Contains(workingset.Targetclock, "4MHz") {
-		mode.BaudRate = 9600
+	port, err := OpenTarget(workingset, clock)
+	if err != nil {
+		fmt.Println("Error opening serial port:", err)
+		fmt.Println("Serial ports found (set \"port\" in the configuration, or \"auto\" for a CP2102/FT232 adapter):")
+		if err := PrintPorts(os.Stdout, ""); err != nil {
+			fmt.Println(" Error:", err)
+		}
+		fmt.Println("Program will now quit")
+		os.Exit(0)
 	}
-	port, err = serial.Open(workingset.Port, mode)
+	portname = workingset.Port
+	prog = programmer.New(port, workingset.Erased)
+	prog.Board, err = OpenBoard(workingset, port)
 	if err != nil {
-		fmt.Println("Error opening serial port. Program will now quit")
+		fmt.Println("Error setting up the board signals:", err)
+		fmt.Println("Program will now quit")
 		os.Exit(0)
-
+	}
+	if prog.Board != nil {
+		fmt.Println("Board signals driven by PROG05: " + prog.Board.String())
+	}
+	prog.UploadPacing = time.Duration(workingset.Pacing) * time.Millisecond
+	if clock != 0 {
+		// The port already runs at this rate, only the clock is recorded
+		prog.Clock = clock
+		prog.BaudRate = workingset.BaudRate(clock)
+	}
+	for name, path := range workingset.Applets {
+		prog.AppletFiles[name] = path
+		fmt.Printf("Applet %s replaced by %s\r\n", name, path)
 	}
 
 	// Serial port was opened OK... begin interactive mode
 	fmt.Println("   ** READY TO ACCESS TARGET MC68HC705C8  **   ")
-	go SerialRx()
 	ShowCommands()
 
 	//--------------------------------------------------------------------------------------
 	// User Input Handling
 	//--------------------------------------------------------------------------------------
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Printf(">") // Print initial command prompt
-	for {
-	CmdInput:
-
-		userinput, errtype = reader.ReadString('\n')
-
-		switch userinput {
-
-		case "?\r\n":
-			ShowCommands()
-			fmt.Printf(">")
-			break
-		case "\r\n":
-			fmt.Printf(">")
-			break
-
-		case "DUMPMCU\r\n":
-			//------------------------------------------------------------------
-			// Dump entire MCU address space 0x0000 - 0x1FFFF
-			//------------------------------------------------------------------
-			// First we load an applet to the HC05 to access the memory map
-			pwd, _ := os.Getwd()
-			res := LoadSrec(pwd+"/srec/memread.s19", RAM_0050, &RAM_SIZE_LOADED)
-			if res != 0 {
-				goto CmdInput
-			}
-			fmt.Println("Preparing to dump HC05...")
-			PrintHC05LoaderInstruction()
-			anykey, _ := reader.ReadByte()
-			if anykey > 0 {
-				length = byte(RAM_SIZE_LOADED)
-				length++
-				//fmt.Printf("Length Indicator (1st byte) = %d\r\n", length)
-				fmt.Printf("Initialising target")
-				selector = int(RAM_PROGRAM_START - 0x50)
-				var p = make([]byte, 1)
-
-				// Send length to the bootloader
-				p[0] = length
-				_, err := port.Write(p[0:])
-				if err == nil {
-					for n := 0; n < int(length-1); n++ {
-						p[0] = RAM[selector]
-						time.Sleep(5 * time.Millisecond)
-						_, err := port.Write(p[0:])
-
-						if err != nil {
-							fmt.Println("Error writing byte to target.. ")
-						} else {
-							fmt.Printf(".")
-						}
-						selector++
-					}
-					fmt.Println(" DONE!")
-				}
-			}
-			// Applet is in the HC05, now we can interact with it
-			reader.Discard(1)
-			for i := 0; i < 8192; i++ {
-				mcudump[i] = 0xFF
-			}
-
-			var OPTIONREG uint8 = 0
-			var MASK_OPT_REG1 uint8 = 0
-			var MASK_OPT_REG2 uint8 = 0
-			ReadByteFromMCU(0x1FDF, &OPTIONREG)
-			ReadByteFromMCU(0x1FF0, &MASK_OPT_REG1)
-			ReadByteFromMCU(0x1FF1, &MASK_OPT_REG2)
-			fmt.Printf(" OPTION Register = %02X\r\n", OPTIONREG)
-			fmt.Printf(" MASK OPTION Register 1 = %02X\r\n", MASK_OPT_REG1)
-			fmt.Printf(" MASK OPTION Register 2 = %02X\r\n", MASK_OPT_REG2)
-
-			// Loop to dump entire memory range
-			var mcuaddress uint16 = 0
-			for {
-				ReadByteFromMCU(mcuaddress, &mcudump[mcuaddress])
-				fmt.Printf(" Address: %04X \r", mcuaddress)
-				mcuaddress++
-				if mcuaddress > 8191 {
-					break
-				}
-			}
-			fmt.Println(" Entire HC05 memory space read successfully")
-			DumpMemory(mcudump, 8192, 0)
-			fmt.Printf(">")
-			break
-
-		case "WRITE\r\n":
-			//------------------------------------------------------------------
-			// WRITE command
-			//-----------------------------------------------------------------
-			if strings.Contains(userinput, "WRITE") {
-				// First we load an applet to the HC05 to access the memory map
-				pwd, _ := os.Getwd()
-				res := LoadSrec(pwd+"/srec/memwrite.s19", RAM_0050, &RAM_SIZE_LOADED)
-				if res != 0 {
-					goto CmdInput
-				}
-				fmt.Println("Preparing to access HC05...")
-				PrintHC05LoaderInstruction()
-				anykey, _ := reader.ReadByte()
-				if anykey > 0 {
-					length = byte(RAM_SIZE_LOADED)
-					length++
-					//fmt.Printf("Length Indicator (1st byte) = %d\r\n", length)
-					fmt.Printf("Initialising target")
-					selector = int(RAM_PROGRAM_START - 0x50)
-					var p = make([]byte, 1)
-
-					// Send length to the bootloader
-					p[0] = length
-					_, err := port.Write(p[0:])
-					if err == nil {
-						for n := 0; n < int(length-1); n++ {
-							p[0] = RAM[selector]
-							time.Sleep(5 * time.Millisecond)
-							_, err := port.Write(p[0:])
-
-							if err != nil {
-								fmt.Println("Error writing byte to target.. ")
-							} else {
-								fmt.Printf(".")
-							}
-							selector++
-						}
-						fmt.Println(" DONE!")
-					}
-				}
-				// Applet is in the HC05, now we can interact with it
-				fmt.Println("     -- HC05 is in access mode, enter Q to exit and return --    ")
-				reader.Discard(1)
-				for {
-				Reloop2:
-					fmt.Printf("Enter address to be written (in hexadecimal):")
-					keyinput, _ := reader.ReadString('\n')
-
-					if keyinput == "\r\n" {
-						goto Reloop2
-					}
-
-					if strings.Contains(keyinput, "Q\r\n") {
-						fmt.Println("     -- HC05 access mode terminated --    ")
-						break
-					} else {
-
-						address, err := hex.DecodeString(keyinput[:4])
-						if err != nil {
-							fmt.Println(" Invalid user input- must be 4 hexadecimal digits (format: nnnn)")
-						} else {
-							// User entered an address in hexadecimal, now the user is asked for the value in hexadecimal
-						Reloop3:
-							fmt.Printf("Enter data to be written (in hexadecimal):")
-							keyinput_b, _ := reader.ReadString('\n')
-							if keyinput_b == "\r\n" {
-								goto Reloop3
-							}
-							if strings.Contains(keyinput, "Q\r\n") {
-								fmt.Println("     -- HC05 access mode terminated --    ")
-								break
-							} else {
-								hexdata, err := hex.DecodeString(keyinput_b[:2])
-								if err != nil {
-									fmt.Println(" Invalid user input- must be 2 hexadecimal digits (format: nn)")
-									goto Reloop3
-								}
-
-								// Transmit address bytes (16 bits)
-								addr_hi = address[0]
-								addr_lo = address[1]
-								data_byte = hexdata[0]
-								fmt.Printf(" [DEBUG] Address bytes + Data : %02X %02X   %02X\r\n", addr_hi, addr_lo, data_byte)
-
-								// Clear receive buffer
-
-								for i := 0; i < 1024; i++ {
-									rxbuffer[i] = 0
-								}
-								rxbuffercount = 0
-
-								//	Then, transmit address..
-								var p = make([]byte, 1)
-								p[0] = addr_hi
-								_, err = port.Write(p[0:])
-								if err != nil {
-									fmt.Println("Error Sending byte on serial port...(1) ")
-								}
-								time.Sleep(1 * time.Millisecond)
-								p[0] = addr_lo
-								_, err = port.Write(p[0:])
-								if err != nil {
-									fmt.Println("Error Sending byte on serial port...(2) ")
-								}
-								time.Sleep(1 * time.Millisecond)
-								p[0] = data_byte
-								_, err = port.Write(p[0:])
-								if err != nil {
-									fmt.Println("Error Sending byte on serial port...(3) ")
-								}
-								fmt.Println("Write operation complete...")
-							}
-						}
-					}
-				}
-			}
-			fmt.Printf(">")
-			break
-
-		case "READ\r\n":
-			//------------------------------------------------------------------
-			// READ command
-			//-----------------------------------------------------------------
-			if strings.Contains(userinput, "READ") {
-				// First we load an applet to the HC05 to access the memory map
-				pwd, _ := os.Getwd()
-				res := LoadSrec(pwd+"/srec/memread.s19", RAM_0050, &RAM_SIZE_LOADED)
-				if res != 0 {
-					goto CmdInput
-				}
-				fmt.Println("Preparing to access HC05...")
-				PrintHC05LoaderInstruction()
-				anykey, _ := reader.ReadByte()
-				if anykey > 0 {
-					length = byte(RAM_SIZE_LOADED)
-					length++
-					//fmt.Printf("Length Indicator (1st byte) = %d\r\n", length)
-					fmt.Printf("Initialising target")
-					selector = int(RAM_PROGRAM_START - 0x50)
-					var p = make([]byte, 1)
-
-					// Send length to the bootloader
-					p[0] = length
-					_, err := port.Write(p[0:])
-					if err == nil {
-						for n := 0; n < int(length-1); n++ {
-							p[0] = RAM[selector]
-							time.Sleep(5 * time.Millisecond)
-							_, err := port.Write(p[0:])
-
-							if err != nil {
-								fmt.Println("Error writing byte to target.. ")
-							} else {
-								fmt.Printf(".")
-							}
-							selector++
-						}
-						fmt.Println(" DONE!")
-					}
-				}
-				// Applet is in the HC05, now we can interact with it
-				fmt.Println("     -- HC05 is in access mode, enter Q to exit and return --    ")
-				reader.Discard(1)
-				for {
-				Reloop:
-					fmt.Printf("Enter address to be read (in hexadecimal):")
-					keyinput, _ := reader.ReadString('\n')
-
-					if keyinput == "\r\n" {
-						goto Reloop
-					}
-
-					if strings.Contains(keyinput, "Q\r\n") {
-						fmt.Println("     -- HC05 access mode terminated --    ")
-						break
-					} else {
-						// We assume the value entered is valid, so we try and convert it to an integer
-
-						address, err := hex.DecodeString(keyinput[:4])
-						if err != nil {
-							fmt.Println(" Invalid user input- must be 4 hexadecimal digits (format: nnnn)")
-						} else {
-							// Transmit address bytes (16 bits)
-							addr_hi = address[0]
-							addr_lo = address[1]
-							fmt.Printf(" [DEBUG] Address bytes: %02X %02X\r\n", addr_hi, addr_lo)
-
-							// Clear receive buffer
-
-							for i := 0; i < 1024; i++ {
-								rxbuffer[i] = 0
-							}
-							rxbuffercount = 0
-
-							//Then, transmit address..
-							var p = make([]byte, 1)
-							p[0] = addr_hi
-							_, err := port.Write(p[0:])
-							if err != nil {
-								fmt.Println("Error Sending byte on serial port...(1) ")
-							}
-							time.Sleep(1 * time.Millisecond)
-							p[0] = addr_lo
-							_, err = port.Write(p[0:])
-							if err != nil {
-								fmt.Println("Error Sending byte on serial port...(2) ")
-							}
-							time.Sleep(100 * time.Millisecond)
-
-							// Get byte received
-							if rxbuffercount > 0 {
-								readbyte := rxbuffer[0]
-								fmt.Printf(" Value Read: %02X\r\n", readbyte)
-							} else {
-								fmt.Println(" Error reading memory")
-							}
-
-						}
-					}
-				}
-			}
-			fmt.Printf(">")
-			break
-
-		case "DUMP A\r\n":
-			//------------------------------------------------------------------
-			// DUMP command
-			//-----------------------------------------------------------------
-			if strings.Contains(userinput, "DUMP A") {
-				fmt.Println("HEX Dump of RAM buffer ($005F - $00FF in the HC05 memory map)")
-				DumpMemory(RAM, len(RAM), 0x50)
-			}
-			fmt.Printf(">") // Print initial command prompt
-			break
-
-		case "LOADRAM\r\n":
-			//------------------------------------------------------------------
-			// LOADRAM command
-			//------------------------------------------------------------------
-			if strings.Contains(userinput, "LOADRAM") {
-				fmt.Printf(" Enter path and file name of S-record file: ")
-				path, _ := reader.ReadString('\n')
-				path = strings.Trim(path, "\n")
-				path = strings.Trim(path, "\r")
-
-				// Clear buffer prior to loading
-				for n := range RAM {
-					RAM[n] = 0
-				}
-				res := LoadSrec(path, RAM_0050, &RAM_SIZE_LOADED)
-				if res != 0 {
-					goto CmdInput
-				}
-				fmt.Printf("S-Record loaded Successfully. %d bytes written to buffer\r\n", RAM_SIZE_LOADED)
-				PrintHC05LoaderInstruction()
-				anykey, _ := reader.ReadByte()
-				if anykey > 0 {
-					length = byte(RAM_SIZE_LOADED)
-					length++
-					//fmt.Printf("Length Indicator (1st byte) = %d\r\n", length)
-					fmt.Printf("Upload to target")
-					selector = int(RAM_PROGRAM_START - 0x50)
-					var p = make([]byte, 1)
-
-					// Send length to the bootloader
-					p[0] = length
-					_, err := port.Write(p[0:])
-					if err == nil {
-						for n := 0; n < int(length-1); n++ {
-							p[0] = RAM[selector]
-							time.Sleep(5 * time.Millisecond)
-							_, err := port.Write(p[0:])
-
-							if err != nil {
-								fmt.Println("Error writing byte to target.. ")
-							} else {
-								fmt.Printf(".")
-							}
-							selector++
-						}
-						fmt.Println(" DONE!")
-						fmt.Println(" Program Running!")
-					}
-				}
-			}
-			fmt.Printf(">") // Print initial command prompt
-			break
-
-		case "QUIT\r\n":
-			//--------------
-			// Quit command
-			//--------------
-			if strings.Contains(userinput, "QUIT") {
-				port.Close()
-				fmt.Println("Program shutdown")
-				os.Exit(0)
-			}
-
-		case "DEMO\r\n":
-			//-------------------------------------------------------------------
-			// DEMO command - Load small app into HC05 to allow user to play with it
-			// Here we use a small applet loaded in from an s-record file
-			//-------------------------------------------------------------------
-			if strings.Contains(userinput, "DEMO") {
-				for i := 0; i < 1024; i++ {
-					rxbuffer[i] = 0
-				}
-				rxbuffercount = 0
-				fmt.Println("Loading DEMO program compatible with MC68HC05PGMR and MIDON PROG05")
-				pwd, _ := os.Getwd()
-				res := LoadSrec(pwd+"/srec/hc05demo.s19", RAM_0050, &RAM_SIZE_LOADED)
-				if res != 0 {
-					goto CmdInput
-				}
-				fmt.Printf("S-Record loaded Successfully. %d bytes written to buffer\r\n", RAM_SIZE_LOADED)
-				PrintHC05LoaderInstruction()
-				anykey, _ := reader.ReadByte()
-				if anykey > 0 {
-					length = byte(RAM_SIZE_LOADED)
-					length++
-					//fmt.Printf("Length Indicator (1st byte) = %d\r\n", length)
-					fmt.Printf("Upload to target")
-					selector = int(RAM_PROGRAM_START - 0x50)
-					var p = make([]byte, 1)
-
-					// Send length to the bootloader
-					p[0] = length
-					_, err := port.Write(p[0:])
-					if err == nil {
-						for n := 0; n < int(length-1); n++ {
-							p[0] = RAM[selector]
-							time.Sleep(5 * time.Millisecond)
-							_, err := port.Write(p[0:])
-
-							if err != nil {
-								fmt.Println("Error writing byte to target.. ")
-							} else {
-								fmt.Printf(".")
-							}
-							selector++
-						}
-						fmt.Println(" DONE!")
-						fmt.Printf("Demo program should be running - Check PORT A pins for toggling\r\n")
-
-						// Clear buffer and pointer
-						for i := 0; i < 1024; i++ {
-							rxbuffer[i] = 0
-						}
-						rxbuffercount = 0
-
-					}
-				}
-			}
-			reader.Discard(1)
-			fmt.Printf(">") // Print initial command prompt
-			break
-
-		default:
-			fmt.Println(" Unknown Command")
-			fmt.Printf(">") // Print initial command prompt
-			break
-
-		case "TEST\r\n":
-			//-------------------------------------------------------------------
-			// TEST command - Load small app into HC05 and process its response
-			// Here we use a small applet loaded in from an s-record file
-			//-------------------------------------------------------------------
-			if strings.Contains(userinput, "TEST") {
-				for i := 0; i < 1024; i++ {
-					rxbuffer[i] = 0
-				}
-				rxbuffercount = 0
-				fmt.Println("Loading test program compatible with MC68HC05PGMR and MIDON PROG05")
-				pwd, _ := os.Getwd()
-				res := LoadSrec(pwd+"/srec/hc05_gotest.s19", RAM_0050, &RAM_SIZE_LOADED)
-				if res != 0 {
-					goto CmdInput
-				}
-				fmt.Printf("S-Record loaded Successfully. %d bytes written to buffer\r\n", RAM_SIZE_LOADED)
-				PrintHC05LoaderInstruction()
-				anykey, _ := reader.ReadByte()
-				if anykey > 0 {
-					length = byte(RAM_SIZE_LOADED)
-					length++
-					//fmt.Printf("Length Indicator (1st byte) = %d\r\n", length)
-					fmt.Printf("Upload to target")
-					selector = int(RAM_PROGRAM_START - 0x50)
-					var p = make([]byte, 1)
-
-					// Send length to the bootloader
-					p[0] = length
-					_, err := port.Write(p[0:])
-					if err == nil {
-						for n := 0; n < int(length-1); n++ {
-							p[0] = RAM[selector]
-							time.Sleep(5 * time.Millisecond)
-							_, err := port.Write(p[0:])
-
-							if err != nil {
-								fmt.Println("Error writing byte to target.. ")
-							} else {
-								fmt.Printf(".")
-							}
-							selector++
-						}
-						fmt.Println(" DONE!")
-						fmt.Printf("Checking target.... ")
-						// Clear buffer and pointer
-						for i := 0; i < 1024; i++ {
-							rxbuffer[i] = 0
-						}
-						rxbuffercount = 0
-
-						// Allow time for the HC05 to have sent its string to the host
-						time.Sleep(800 * time.Millisecond)
-						str1 := string(rxbuffer[:rxbuffercount])
-						if strings.Contains(str1, "HC05") {
-							fmt.Printf(" [OK]\r\n")
-							fmt.Println("Target (68HC705C8) access is Successful")
-
-						} else {
-							fmt.Printf(" [FAILED]\r\n")
-							fmt.Println("  Check your hardware, clock speed, and confirm HC05 did go into bootloader mode")
-						}
-						// Clear buffer and pointer
-						for i := 0; i < 1024; i++ {
-							rxbuffer[i] = 0
-						}
-						rxbuffercount = 0
-
-					}
-				}
-			}
-			reader.Discard(1)
-			fmt.Printf(">") // Print initial command prompt
-			break
+	if !prog.Board.HandsFree() {
+		prog.Handshake = func() error {
+			// Every upload waits for the user to start the loader
+			PrintHC05LoaderInstruction(workingset.Board, prog.Board.Drives(board.RESET))
+			ReadLine(reader)
+			return nil
+		}
+	}
+	if clock == 0 {
+		err = ProbeTargetClock()
+		if err != nil {
+			fmt.Println(" Error:", err)
+			fmt.Println(" Use the CLOCK command to probe again or to give the crystal frequency")
 		}
-
 	}
-}
-
-// Serial Port Reception goroutine
-// This thread will sit and block on the serial port receive callback in the OS
-// If a byte is received, it is stored in the buffer
-// ----------------------------------------------------------------------------
-func SerialRx() {
-
 	for {
-		n, _ := port.Read(tmpbuf)
-		if n > 0 {
-			// n holds the number of bytes we got in this read, copy to buffer
-			for r := 0; r < n; r++ {
-				rxbuffer[rxbuffercount] = tmpbuf[r]
-				rxbuffercount++
-				if rxbuffercount > 1023 {
-					rxbuffercount = 1023 // reached end of buffer, discard the data until it is emptied
-				}
-			}
+		fmt.Printf(">") // Print command prompt
+		line, err := ReadLine(reader)
+		if err != nil {
+			// End of the input (script done or console closed)
+			CmdQuit(reader, nil, nil)
+		}
+		words := Tokenize(line)
+		if len(words) == 0 {
+			continue
+		}
+		command, err := FindCommand(words[0])
+		if err != nil {
+			fmt.Println(" Error:", err)
+			continue
+		}
+		args, flags, err := ParseArguments(words[1:], command.Options)
+		if err != nil {
+			fmt.Printf(" Error: %v (usage: %s %s)\r\n", err, command.Name, command.Usage)
+			continue
 		}
+		command.Run(reader, args, flags)
+		ReportViolations(os.Stdout)
 	}
 }
@@ -0,0 +1,134 @@
+package programmer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sonikku2k/PROG05/hc05"
+	"github.com/sonikku2k/PROG05/ihex"
+	"github.com/sonikku2k/PROG05/srec"
+)
+
+// DumpInfo is the JSON sidecar written next to an exported dump
+type DumpInfo struct {
+	File                string    `json:"file"`
+	Format              string    `json:"format"`
+	Date                time.Time `json:"date"`
+	Regions             []string  `json:"regions"`
+	OptionRegister      string    `json:"option_register"`       // $1FDF
+	MaskOptionRegister1 string    `json:"mask_option_register1"` // $1FF0
+	MaskOptionRegister2 string    `json:"mask_option_register2"` // $1FF1
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: FormatFromPath
+// Function: Pick the export format from the file extension (.bin, .s19/.srec/.mot, .hex/.ihx)
+// Parameters: Path of the file to write
+// Returns: Format, error if the extension is not known
+// -------------------------------------------------------------------------------------------------------------------
+func FormatFromPath(path string) (string, error) {
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".bin":
+		return FORMAT_BINARY, nil
+	case ".s19", ".srec", ".mot":
+		return FORMAT_SREC, nil
+	case ".hex", ".ihx":
+		return FORMAT_IHEX, nil
+	}
+	return "", fmt.Errorf("cannot tell the format of %s from its extension (.bin, .s19 or .hex)", path)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ParseSpans
+// Function: Convert a list of address ranges such as "0020-004F,0100-1EFF" (hexadecimal, ends included)
+// Parameters: Text, an empty text selects the entire HC05 address space
+// Returns: Ranges, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func ParseSpans(text string) ([]Span, error) {
+
+	if strings.TrimSpace(text) == "" {
+		return []Span{{0, hc05.MEMORY_SIZE - 1}}, nil
+	}
+	var spans []Span
+	for _, item := range strings.Split(text, ",") {
+		ends := strings.SplitN(strings.TrimSpace(item), "-", 2)
+		if len(ends) != 2 {
+			return nil, fmt.Errorf("invalid range %s (format: nnnn-nnnn)", item)
+		}
+		start, err1 := strconv.ParseUint(strings.TrimPrefix(ends[0], "$"), 16, 16)
+		end, err2 := strconv.ParseUint(strings.TrimPrefix(ends[1], "$"), 16, 16)
+		if err1 != nil || err2 != nil || end < start || end >= hc05.MEMORY_SIZE {
+			return nil, fmt.Errorf("invalid range %s (format: nnnn-nnnn, within $0000-$1FFF)", item)
+		}
+		spans = append(spans, Span{uint16(start), uint16(end)})
+	}
+	return spans, nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ExportDump
+// Function: Save regions of McuDump (filled by DumpMCU) to a file, a binary file holds everything from the lowest
+// to the highest address selected as it has no addresses of its own
+// Parameters: Path of the file, format (FORMAT_xxx), regions, true to also write the JSON sidecar (path + .json)
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) ExportDump(path string, format string, regions []Span, sidecar bool) error {
+
+	var segments []srec.Segment
+	for _, region := range regions {
+		segments = append(segments, srec.Segment{Address: region.Start, Data: p.McuDump[region.Start : int(region.End)+1]})
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FORMAT_BINARY:
+		lowest, highest := regions[0].Start, regions[0].End
+		for _, region := range regions {
+			if region.Start < lowest {
+				lowest = region.Start
+			}
+			if region.End > highest {
+				highest = region.End
+			}
+		}
+		_, err = file.Write(p.McuDump[lowest : int(highest)+1])
+	case FORMAT_SREC:
+		err = srec.Write(file, segments, 0)
+	case FORMAT_IHEX:
+		err = ihex.Write(file, segments)
+	default:
+		err = fmt.Errorf("unknown format %s", format)
+	}
+	if closeerr := file.Close(); err == nil {
+		err = closeerr
+	}
+	if err != nil || !sidecar {
+		return err
+	}
+
+	info := DumpInfo{
+		File:                filepath.Base(path),
+		Format:              format,
+		Date:                time.Now(),
+		OptionRegister:      fmt.Sprintf("%02X", p.McuDump[0x1FDF]),
+		MaskOptionRegister1: fmt.Sprintf("%02X", p.McuDump[0x1FF0]),
+		MaskOptionRegister2: fmt.Sprintf("%02X", p.McuDump[0x1FF1]),
+	}
+	for _, region := range regions {
+		info.Regions = append(info.Regions, fmt.Sprintf("%04X-%04X", region.Start, region.End))
+	}
+	content, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".json", append(content, '\n'), 0644)
+}
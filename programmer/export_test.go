@@ -0,0 +1,129 @@
+package programmer
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sonikku2k/PROG05/ihex"
+	"github.com/sonikku2k/PROG05/srec"
+)
+
+func TestParseSpans(t *testing.T) {
+	cases := []struct {
+		text string
+		want []Span
+		err  bool
+	}{
+		{"", []Span{{0x0000, 0x1FFF}}, false},
+		{"0020-004F", []Span{{0x0020, 0x004F}}, false},
+		{"0020-004F, $0100-$1EFF", []Span{{0x0020, 0x004F}, {0x0100, 0x1EFF}}, false},
+		{"1FDF-1FDF", []Span{{0x1FDF, 0x1FDF}}, false},
+		{"0100", nil, true},
+		{"0200-0100", nil, true},
+		{"1F00-2000", nil, true},
+		{"0100-01G0", nil, true},
+	}
+	for _, c := range cases {
+		spans, err := ParseSpans(c.text)
+		if (err != nil) != c.err || !reflect.DeepEqual(spans, c.want) {
+			t.Errorf("%q: %v %v, want %v", c.text, spans, err, c.want)
+		}
+	}
+}
+
+// Every export format reads back to the dumped bytes of the regions
+func TestExportDump(t *testing.T) {
+	p := New(nil, 0)
+	for n := range p.McuDump {
+		p.McuDump[n] = byte(n ^ n>>8)
+	}
+	regions := []Span{{0x0020, 0x004F}, {0x1FDF, 0x1FF1}}
+	dir := t.TempDir()
+
+	// Binary: everything from the lowest to the highest address
+	path := filepath.Join(dir, "dump.bin")
+	if err := p.ExportDump(path, FORMAT_BINARY, regions, false); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, p.McuDump[0x0020:0x1FF2]) {
+		t.Errorf("binary file holds %d bytes, want $0020-$1FF1", len(content))
+	}
+
+	// S-records: only the regions
+	path = filepath.Join(dir, "dump.s19")
+	if err := p.ExportDump(path, FORMAT_SREC, regions, true); err != nil {
+		t.Fatal(err)
+	}
+	readback := make(map[uint16]byte)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = srec.Read(file, path, func(address uint16, data byte) error {
+		readback[address] = data
+		return nil
+	})
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegions(t, "S-records", readback, p.McuDump, regions)
+
+	var info DumpInfo
+	content, err = os.ReadFile(path + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.File != "dump.s19" || info.Format != FORMAT_SREC || !reflect.DeepEqual(info.Regions, []string{"0020-004F", "1FDF-1FF1"}) ||
+		info.OptionRegister != "C0" || info.MaskOptionRegister1 != "EF" || info.MaskOptionRegister2 != "EE" {
+		t.Errorf("sidecar %+v", info)
+	}
+
+	// Intel HEX: only the regions
+	path = filepath.Join(dir, "dump.hex")
+	if err := p.ExportDump(path, FORMAT_IHEX, regions, false); err != nil {
+		t.Fatal(err)
+	}
+	readback = make(map[uint16]byte)
+	file, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ihex.Read(file, path, func(address uint16, data byte) error {
+		readback[address] = data
+		return nil
+	})
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegions(t, "Intel HEX", readback, p.McuDump, regions)
+}
+
+// checkRegions compares the bytes read back from an export with the dump, nothing outside the regions is expected
+func checkRegions(t *testing.T, format string, readback map[uint16]byte, dump []byte, regions []Span) {
+	t.Helper()
+	count := 0
+	for _, region := range regions {
+		for address := int(region.Start); address <= int(region.End); address++ {
+			if data, ok := readback[uint16(address)]; !ok || data != dump[address] {
+				t.Errorf("%s: $%04X reads back %02X (%t), want %02X", format, address, data, ok, dump[address])
+			}
+			count++
+		}
+	}
+	if len(readback) != count {
+		t.Errorf("%s: %d bytes read back, want %d", format, len(readback), count)
+	}
+}
@@ -0,0 +1,773 @@
+// Package programmer drives a MC68HC705C8 through its bootloader and the PROG05 applets
+package programmer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sonikku2k/PROG05/applet"
+	"github.com/sonikku2k/PROG05/assembler"
+	"github.com/sonikku2k/PROG05/board"
+	"github.com/sonikku2k/PROG05/bootloader"
+	"github.com/sonikku2k/PROG05/hc05"
+	"github.com/sonikku2k/PROG05/ihex"
+	"github.com/sonikku2k/PROG05/srec"
+)
+
+// Target areas for LoadSrec
+const RAM_0050 = 1
+const EPROM_ALL = 2 // Every EPROM/OTP area of the 68HC705C8 (used by the LOAD command)
+
+// File formats of LoadFile and ExportDump
+const FORMAT_BINARY = "bin"
+const FORMAT_SREC = "s19"
+const FORMAT_IHEX = "hex"
+const FORMAT_ASM = "asm" // HC05 assembly source, load only
+
+// Programmer owns the link to the HC05, the memory area images and the state of the last loads
+type Programmer struct {
+	Port        bootloader.Transport
+	Images      *hc05.MemoryImages
+	Memory      *hc05.MemoryMap   // Images seen through the layout of the HC05 while it is programmed
+	Out         io.Writer         // Progress output
+	AppletFiles map[string]string // External S-record (or .asm) files replacing built-in applets, by applet name
+	Erased      byte              // Value of an erased EPROM byte (ERASED EQU $00 in the applet sources)
+
+	RamSizeLoaded   uint16 // Bytes to upload, from RamProgramStart
+	RamProgramStart uint16
+	PromSizeLoaded  uint16
+	Srec            srec.Info // Description of the last file loaded (S0 header, start address...)
+	McuDump         []byte    // Image of the entire HC05 address space read by DumpMCU
+
+	Options  map[uint16]byte // Option register values composed by the user, programmed by the next LOAD (see ApplyOptions)
+	Transfer Transfer        // Block reads of the last dump, verify or blank check
+
+	UploadPacing   time.Duration         // Delay between two bytes sent to the bootloader (bootloader.BYTE_PACING when 0)
+	Handshake      func() error          // Gets the HC05 into its bootloader before every upload, e.g. waits for the operator (may be nil)
+	Board          *board.Control        // Board signals driven by PROG05, the bootloader is started through them after Handshake (may be nil)
+	UploadProgress func(sent, total int) // Reports the upload, a dot per byte is printed to Out when nil
+
+	Clock    int // Crystal frequency of the HC05 in Hz, configured or found by ProbeClock
+	BaudRate int // SCI rate of the bootloader at that clock, the rate of Port
+}
+
+// ErrNoBanner is returned by ProbeClock when the gotest applet did not answer at any of the clocks tried
+var ErrNoBanner = errors.New("no \"HC05\" banner at any of the clocks tried, check the hardware and the loader settings")
+
+// ErrSecured is returned when the HC05 has the security bit of its OPTION register set, what it returns is not its
+// memory contents
+var ErrSecured = errors.New("the HC05 is secured (SEC bit set in the OPTION register), its memory cannot be read out")
+
+// Likely causes of an applet that never answers, the first one being a secured part (its bootloader is disabled)
+const NO_LOADER_HINT = "the applet is not running: the HC05 may be secured (SEC bit set disables the bootloader), " +
+	"or the board is not in bootloader mode"
+
+// Mismatch is a byte of the EPROM images that differs from the HC05 contents
+type Mismatch struct {
+	Address  uint16
+	Expected byte
+	Actual   byte
+}
+
+// Number of times a block is asked for before ReadMemory gives up
+const BLOCK_ATTEMPTS = 3
+
+// Transfer measures the block reads of the last DumpMCU, VerifyPromImages or BlankCheck
+type Transfer struct {
+	Bytes   int
+	Retries int // Blocks asked for again after a timeout or a checksum mismatch
+	Elapsed time.Duration
+}
+
+// String gives the throughput, e.g. "8192 bytes in 9.2s (890 bytes/s), 0 retries"
+func (t Transfer) String() string {
+
+	rate := 0.0
+	if t.Elapsed > 0 {
+		rate = float64(t.Bytes) / t.Elapsed.Seconds()
+	}
+	return fmt.Sprintf("%d bytes in %.1fs (%.0f bytes/s), %d retries", t.Bytes, t.Elapsed.Seconds(), rate, t.Retries)
+}
+
+// Span is a range of addresses, both ends included
+type Span struct {
+	Start uint16
+	End   uint16
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: New
+// Function: Create a programmer on an opened transport (serial port or simulator)
+// Parameters: Transport, value of an erased EPROM byte
+// Returns: Pointer to the programmer
+// -------------------------------------------------------------------------------------------------------------------
+func New(port bootloader.Transport, erased byte) *Programmer {
+	images := hc05.NewMemoryImages()
+	return &Programmer{
+		Port:        port,
+		Images:      images,
+		Memory:      hc05.NewMemoryMap(images, 0),
+		Out:         os.Stdout,
+		AppletFiles: make(map[string]string),
+		Erased:      erased,
+		McuDump:     make([]byte, hc05.MEMORY_SIZE),
+		Options:     make(map[uint16]byte),
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: LoadSrec
+// Function: Load Motorola S-Record file from the disk and store the data in the images of the target area,
+// HC05 assembly sources (.asm), Intel HEX files are recognised too (see LoadFile)
+// Parameters: Full path to the file that shall be opened, Target Area in MCU
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) LoadSrec(path string, targetarea uint8) error {
+	return p.LoadFile(path, "", 0, targetarea)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: DetectFormat
+// Function: Tell the format of a file to load from its extension, or from its first character (S or :)
+// Parameters: Path to the file
+// Returns: Format (FORMAT_xxx), error if unknown
+// -------------------------------------------------------------------------------------------------------------------
+func DetectFormat(path string) (string, error) {
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".asm":
+		return FORMAT_ASM, nil
+	case ".bin":
+		return FORMAT_BINARY, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("%s: cannot tell the file format, use S19, Intel HEX or .bin", path)
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case 'S', 's':
+			return FORMAT_SREC, nil
+		case ':':
+			return FORMAT_IHEX, nil
+		}
+		return "", fmt.Errorf("%s: cannot tell the file format, use S19, Intel HEX or .bin", path)
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: LoadFile
+// Function: Load a file from the disk and store the data in the images of the target area
+// Parameters: Full path to the file, format (FORMAT_xxx, "" to detect it), address of the first byte of a binary
+// file, Target Area in MCU
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) LoadFile(path string, format string, base uint16, targetarea uint8) error {
+
+	var err error
+	if format == "" {
+		format, err = DetectFormat(path)
+		if err != nil {
+			return err
+		}
+	}
+	if format == FORMAT_ASM {
+		program, err := assembler.AssembleFile(path)
+		if err != nil {
+			return err
+		}
+		return p.ReadImage(bytes.NewReader(program.Srec()), path, FORMAT_SREC, 0, targetarea)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+	return p.ReadImage(file, path, format, base, targetarea)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ReadImage
+// Function: Parse a file from a reader and store the data in the images of the target area, data outside the
+// target area, or loaded twice, is refused
+// Parameters: Reader, name used in error messages, format (FORMAT_SREC, FORMAT_IHEX or FORMAT_BINARY), address of
+// the first byte of a binary file, Target Area in MCU
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) ReadImage(records io.Reader, name string, format string, base uint16, targetarea uint8) error {
+
+	var store func(address uint16, data byte) error
+	switch targetarea {
+	case RAM_0050:
+		store = func(address uint16, data byte) error {
+			// Target memory is the MCU RAM, the address supplied must fall in the part not used by the stack
+			if address < hc05.RAM_START || address > hc05.RAM_PROGRAM_END {
+				return fmt.Errorf("address %04X falls outside of RAM ($%04X-$%04X)", address, hc05.RAM_START, hc05.RAM_PROGRAM_END)
+			}
+			return p.Memory.Write(address, data)
+		}
+	case EPROM_ALL:
+		store = func(address uint16, data byte) error {
+			// Target memory is the EPROM/OTP of the MCU, every byte must land in one of the PROM images
+			region := p.Memory.Region(address)
+			if !region.IsProm() {
+				return fmt.Errorf("address %04X (%s) is not in EPROM/OTP memory", address, region.Name)
+			}
+			if err := p.Memory.Write(address, data); err != nil {
+				return err
+			}
+			p.Images.PROM_LOADED[address] = true
+			return nil
+		}
+	default:
+		return errors.New("unknown target area")
+	}
+
+	// Every address may only be given once in a file
+	loaded := make([]bool, 0x10000)
+	checked := func(address uint16, data byte) error {
+		if loaded[address] {
+			return fmt.Errorf("address %04X is loaded twice", address)
+		}
+		loaded[address] = true
+		return store(address, data)
+	}
+
+	var info srec.Info
+	var err error
+	switch format {
+	case FORMAT_SREC:
+		info, err = srec.Read(records, name, checked)
+	case FORMAT_IHEX:
+		info, err = ihex.Read(records, name, checked)
+	case FORMAT_BINARY:
+		info, err = readBinary(records, name, base, checked)
+	default:
+		err = fmt.Errorf("unknown format %s", format)
+	}
+	p.Srec = info
+	if err != nil {
+		return err
+	}
+	if targetarea == RAM_0050 {
+		// The bootloader stores the program from $0051 and jumps there
+		if info.Length == 0 {
+			return fmt.Errorf("%s holds no data", name)
+		}
+		if info.Lowest != hc05.LOADER_START {
+			return fmt.Errorf("%s: RAM programs must begin at $%04X, not $%04X", name, hc05.LOADER_START, info.Lowest)
+		}
+		if info.HasStart && info.Start != hc05.LOADER_START {
+			return fmt.Errorf("%s: start address $%04X, the bootloader runs RAM programs from $%04X", name, info.Start, hc05.LOADER_START)
+		}
+		p.RamProgramStart = info.Lowest
+		p.RamSizeLoaded = info.Highest - info.Lowest + 1
+	} else {
+		p.PromSizeLoaded = uint16(info.Length)
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ApplyOptions
+// Function: Store the option register values composed by the user in the EPROM images, over those of the file loaded
+// by LoadSrec, then forget them
+// Returns: Addresses whose value from the file was replaced
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) ApplyOptions() []uint16 {
+
+	var replaced []uint16
+	for _, address := range hc05.OPTION_REGISTERS {
+		value, found := p.Options[address]
+		if !found {
+			continue
+		}
+		if p.Images.PROM_LOADED[address] {
+			replaced = append(replaced, address)
+		} else {
+			p.PromSizeLoaded++
+		}
+		p.Memory.Write(address, value)
+		p.Images.PROM_LOADED[address] = true
+	}
+	p.Options = make(map[uint16]byte)
+	return replaced
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ReadOptions
+// Function: Read the OPTION and MASK OPTION registers of the HC05 (memblock applet must be running)
+// Returns: Values by address, error if the target stopped responding
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) ReadOptions() (map[uint16]byte, error) {
+
+	registers := make(map[uint16]byte)
+	for _, address := range hc05.OPTION_REGISTERS {
+		data := make([]byte, 1)
+		err := p.ReadMemory(address, data)
+		if err != nil && len(registers) == 0 {
+			err = firstAnswer(err)
+		}
+		if err != nil {
+			return registers, fmt.Errorf("reading address %04X: %w", address, err)
+		}
+		registers[address] = data[0]
+	}
+	return registers, nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: HiddenPromRegions
+// Function: List the RAM and reserved regions of the layout chosen by the loaded OPTION register that overlay EPROM
+// bytes filled by LoadSrec, the program running in the HC05 will not see these bytes
+// Returns: Regions hiding loaded data, none if the OPTION register was not loaded
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) HiddenPromRegions() []hc05.Region {
+
+	if !p.Images.PROM_LOADED[hc05.OPTION_ADDRESS] {
+		return nil
+	}
+	var hidden []hc05.Region
+	for _, region := range hc05.Layout(p.Images.OPTION_REGISTER) {
+		if region.Kind != hc05.REGION_RAM && region.Kind != hc05.REGION_RESERVED {
+			continue
+		}
+		for address := region.Start; address <= region.End; address++ {
+			if p.Images.PROM_LOADED[address] {
+				hidden = append(hidden, region)
+				break
+			}
+		}
+	}
+	return hidden
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: readBinary
+// Function: Store the bytes of a binary file from a base address
+// Parameters: Reader, name used in error messages, address of the first byte, function storing one byte
+// Returns: Description of the file, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func readBinary(binary io.Reader, name string, base uint16, store func(address uint16, data byte) error) (srec.Info, error) {
+
+	var info srec.Info
+	data, err := io.ReadAll(binary)
+	if err != nil {
+		return info, err
+	}
+	if int(base)+len(data) > 0x10000 {
+		return info, fmt.Errorf("%s: %d bytes from $%04X go beyond the HC05 address space", name, len(data), base)
+	}
+	for n, b := range data {
+		err = store(base+uint16(n), b)
+		if err != nil {
+			return info, fmt.Errorf("%s: offset %X: %w", name, n, err)
+		}
+	}
+	info.Length = len(data)
+	if len(data) > 0 {
+		info.Records = 1
+		info.First, info.Lowest = base, base
+		info.Highest = base + uint16(len(data)-1)
+	}
+	return info, nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: LoadApplet
+// Function: Load one of the applets into the RAM image, from the file given in AppletFiles if there is one,
+// otherwise from the copy compiled into the binary
+// Parameters: Applet file name (see package applet)
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) LoadApplet(name string) error {
+
+	if path, ok := p.AppletFiles[name]; ok {
+		return p.LoadSrec(path, RAM_0050)
+	}
+	file, err := srec.APPLETS.Open(name)
+	if err != nil {
+		return fmt.Errorf("unknown applet %s", name)
+	}
+	defer file.Close()
+	return p.ReadImage(file, name, FORMAT_SREC, 0, RAM_0050)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: UploadRamBuffer
+// Function: Send the program held in the RAM image to the HC05 bootloader once the Handshake is done and the Board
+// has started the bootloader, a dot is printed for every byte unless UploadProgress is set
+// Parameters: Message printed while uploading
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) UploadRamBuffer(message string) error {
+
+	selector := int(p.RamProgramStart - hc05.RAM_START)
+	code := p.Images.RAM[selector : selector+int(p.RamSizeLoaded)]
+	err := bootloader.CheckUpload(p.RamProgramStart, code)
+	if err != nil {
+		return err
+	}
+
+	progress := p.UploadProgress
+	if progress == nil {
+		progress = func(sent, total int) {
+			if sent > 1 {
+				fmt.Fprint(p.Out, ".")
+			}
+		}
+	}
+	err = bootloader.UploadToBootloader(p.Port, p.RamProgramStart, code, bootloader.UploadOptions{
+		Pacing: p.UploadPacing,
+		Handshake: func() error {
+			if p.Handshake != nil {
+				if err := p.Handshake(); err != nil {
+					return err
+				}
+			}
+			if p.Board != nil {
+				if err := p.Board.EnterLoader(); err != nil {
+					return err
+				}
+			}
+			fmt.Fprint(p.Out, message)
+			return nil
+		},
+		Progress: progress,
+	})
+	if err != nil {
+		fmt.Fprintln(p.Out, " Error writing byte to target.. ")
+		return err
+	}
+	fmt.Fprintln(p.Out, " DONE!")
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ReadByteFromMCU
+// Function: Reads byte from specified address in the HC05 (memread applet must be running)
+// Parameters: Address
+// Returns: Byte read, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) ReadByteFromMCU(address uint16) (byte, error) {
+	return applet.ReadByte(p.Port, address)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: WriteByteToMCU
+// Function: Writes byte at specified address in the HC05 (memwrite applet must be running)
+// Parameters: Address, Data
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) WriteByteToMCU(address uint16, data byte) error {
+	return applet.WriteByte(p.Port, address, data)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ProgramPromImages
+// Function: Program every non-erased byte filled by LoadSrec (memprog applet must be running, Vpp applied)
+// Returns: Number of bytes programmed, bytes that did not read back as written, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) ProgramPromImages() (int, []Mismatch, error) {
+
+	// The OPTION register goes last, its security bit must not lock the part before everything else is written
+	order := make([]int, 0, hc05.MEMORY_SIZE)
+	for address := 0; address < hc05.MEMORY_SIZE; address++ {
+		if address != hc05.OPTION_ADDRESS {
+			order = append(order, address)
+		}
+	}
+	order = append(order, hc05.OPTION_ADDRESS)
+
+	var programmed = 0
+	var failures []Mismatch
+	for _, address := range order {
+		if !p.Images.PROM_LOADED[address] {
+			continue
+		}
+		// Erased bytes are skipped as there is nothing to program
+		data, _ := p.Memory.Read(uint16(address))
+		if data == p.Erased {
+			continue
+		}
+		fmt.Fprintf(p.Out, " Address: %04X \r", address)
+		readback, err := applet.ProgramByte(p.Port, uint16(address), data)
+		if err != nil {
+			return programmed, failures, fmt.Errorf("programming aborted at address %04X: %w", address, err)
+		}
+		if readback != data {
+			failures = append(failures, Mismatch{uint16(address), data, readback})
+		}
+		programmed++
+	}
+	return programmed, failures, nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: VerifyPromImages
+// Function: Read back every address filled by LoadSrec (memblock applet must be running) and compare it with the
+// EPROM images
+// Returns: Bytes that differ, error if the target stopped responding
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) VerifyPromImages() ([]Mismatch, error) {
+
+	var failures []Mismatch
+	if err := p.CheckSecurity(); err != nil {
+		return failures, err
+	}
+	p.Transfer = Transfer{}
+
+	// Runs of loaded addresses are read as blocks
+	actual := make([]byte, hc05.MEMORY_SIZE)
+	for _, span := range p.loadedSpans() {
+		err := p.ReadMemory(span.Start, actual[span.Start:int(span.End)+1])
+		if err != nil {
+			return failures, fmt.Errorf("verify aborted: %w", err)
+		}
+	}
+	for address := 0; address < hc05.MEMORY_SIZE; address++ {
+		if !p.Images.PROM_LOADED[address] {
+			continue
+		}
+		expected, _ := p.Memory.Read(uint16(address))
+		if actual[address] != expected {
+			failures = append(failures, Mismatch{uint16(address), expected, actual[address]})
+		}
+	}
+	return failures, nil
+}
+
+// loadedSpans returns the runs of addresses filled by LoadSrec
+func (p *Programmer) loadedSpans() []Span {
+
+	var spans []Span
+	var spanstart = -1
+	for address := 0; address <= hc05.MEMORY_SIZE; address++ {
+		loaded := address < hc05.MEMORY_SIZE && p.Images.PROM_LOADED[address]
+		if loaded && spanstart < 0 {
+			spanstart = address
+		}
+		if !loaded && spanstart >= 0 {
+			spans = append(spans, Span{uint16(spanstart), uint16(address - 1)})
+			spanstart = -1
+		}
+	}
+	return spans
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: BlankCheck
+// Function: Read every EPROM/OTP area of the HC05 (memblock applet must be running) and collect the spans of
+// addresses that do not hold the erased value
+// Returns: Programmed spans, error if the target stopped responding
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) BlankCheck() ([]Span, error) {
+
+	var spans []Span
+	p.Transfer = Transfer{}
+	for _, area := range hc05.BLANK_CHECK_RANGES {
+		contents := make([]byte, int(area[1])-int(area[0])+1)
+		err := p.ReadMemory(area[0], contents)
+		if err != nil {
+			return spans, fmt.Errorf("blank check aborted: %w", err)
+		}
+		var spanstart = -1
+		for offset, data := range contents {
+			address := int(area[0]) + offset
+			if data != p.Erased && spanstart < 0 {
+				spanstart = address
+			}
+			if data == p.Erased && spanstart >= 0 {
+				spans = append(spans, Span{uint16(spanstart), uint16(address - 1)})
+				spanstart = -1
+			}
+		}
+		if spanstart >= 0 {
+			spans = append(spans, Span{uint16(spanstart), area[1]})
+		}
+	}
+	return spans, nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: DumpMCU
+// Function: Read the entire HC05 address space into McuDump (memblock applet must be running)
+// Returns: error if the target stopped responding
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) DumpMCU() error {
+
+	if err := p.CheckSecurity(); err != nil {
+		return err
+	}
+	// Only the dump itself is counted, not the OPTION byte read by CheckSecurity
+	p.Transfer = Transfer{}
+	for i := range p.McuDump {
+		p.McuDump[i] = 0xFF
+	}
+	err := p.ReadMemory(0, p.McuDump)
+	if err != nil {
+		return fmt.Errorf("dump aborted: %w", err)
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ReadMemory
+// Function: Read consecutive addresses of the HC05 in blocks (memblock applet must be running), a block that times
+// out or fails its checksum is asked for again up to BLOCK_ATTEMPTS times. Transfer counts the bytes and time taken
+// Parameters: First address, buffer to fill (its length gives the number of bytes)
+// Returns: error if a block could not be read
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) ReadMemory(start uint16, buffer []byte) error {
+
+	began := time.Now()
+	defer func() { p.Transfer.Elapsed += time.Since(began) }()
+
+	for offset := 0; offset < len(buffer); offset += applet.MAX_BLOCK {
+		count := len(buffer) - offset
+		if count > applet.MAX_BLOCK {
+			count = applet.MAX_BLOCK
+		}
+		address := start + uint16(offset)
+		var block []byte
+		var err error
+		for attempt := 1; attempt <= BLOCK_ATTEMPTS; attempt++ {
+			block, err = applet.ReadBlock(p.Port, address, count)
+			if err == nil {
+				break
+			}
+			p.Transfer.Retries++
+			if errors.Is(err, applet.ErrTimeout) {
+				applet.ResyncBlock(p.Port)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("block %04X-%04X: %w", address, int(address)+count-1, err)
+		}
+		copy(buffer[offset:], block)
+		p.Transfer.Bytes += count
+		fmt.Fprintf(p.Out, " Address: %04X \r", int(address)+count-1)
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: CheckSecurity
+// Function: Read the OPTION register of the HC05 (memblock applet must be running) and refuse to go on if the part is
+// secured. A secured part has its bootloader disabled and usually never runs the applet, the read then times out and
+// the error names the security bit as a likely cause: the SEC bit itself is only seen while the loader answers.
+// Returns: ErrSecured if the SEC bit is set, error if the target stopped responding
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) CheckSecurity() error {
+
+	option := make([]byte, 1)
+	err := p.ReadMemory(hc05.OPTION_ADDRESS, option)
+	if err != nil {
+		return fmt.Errorf("reading the OPTION register: %w", firstAnswer(err))
+	}
+	if hc05.IsSecured(option[0]) {
+		return ErrSecured
+	}
+	return nil
+}
+
+// firstAnswer explains a timeout of the first request to an applet just uploaded: nothing ran the applet, and a secured
+// HC05 (SEC bit set) disables its bootloader
+func firstAnswer(err error) error {
+	if errors.Is(err, applet.ErrTimeout) {
+		return fmt.Errorf("%w - %s", err, NO_LOADER_HINT)
+	}
+	return err
+}
+
+// LoadsSecurityBit tells whether the EPROM images loaded by LoadSrec set the security bit of the OPTION register
+func (p *Programmer) LoadsSecurityBit() bool {
+	return p.Images.PROM_LOADED[hc05.OPTION_ADDRESS] && hc05.IsSecured(p.Images.OPTION_REGISTER)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: TestTarget
+// Function: Check that the gotest applet just uploaded answers with its banner
+// Returns: true if the target is alive
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) TestTarget() bool {
+	return applet.CheckBanner(p.Port)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: SetClock
+// Function: Record the crystal frequency of the HC05 and set the port to the SCI rate of the bootloader at that clock
+// Parameters: Crystal frequency in Hz
+// Returns: error if the rate of the port cannot be changed
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) SetClock(clock int) error {
+
+	baudrate := hc05.BootloaderBaudRate(clock)
+	if setter, ok := p.Port.(bootloader.BaudRateSetter); ok {
+		err := setter.SetBaudRate(baudrate)
+		if err != nil {
+			return err
+		}
+	}
+	p.Clock = clock
+	p.BaudRate = baudrate
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: ProbeClock
+// Function: Find the crystal frequency of the HC05: for each candidate the port is set to the SCI rate of the
+// bootloader at that clock, the gotest applet is uploaded (Handshake included) and its banner is waited for
+// Parameters: Crystal frequencies to try in turn, in Hz
+// Returns: Crystal frequency found (also kept in Clock, its rate in BaudRate), error if any (ErrNoBanner)
+// -------------------------------------------------------------------------------------------------------------------
+func (p *Programmer) ProbeClock(candidates []int) (int, error) {
+
+	setter, ok := p.Port.(bootloader.BaudRateSetter)
+	if !ok {
+		return 0, errors.New("the speed of the link to the HC05 cannot be changed, the clock cannot be probed")
+	}
+	err := p.LoadApplet(applet.GOTEST)
+	if err != nil {
+		return 0, err
+	}
+	for _, clock := range candidates {
+		baudrate := hc05.BootloaderBaudRate(clock)
+		err = setter.SetBaudRate(baudrate)
+		if err != nil {
+			return 0, err
+		}
+		err = p.UploadRamBuffer(fmt.Sprintf("Trying %s (%d baud)", hc05.FormatClock(clock), baudrate))
+		if err != nil {
+			return 0, err
+		}
+		if applet.CheckBanner(p.Port) {
+			p.Clock = clock
+			p.BaudRate = baudrate
+			return clock, nil
+		}
+		fmt.Fprintln(p.Out, " No answer")
+	}
+
+	// Back to the rate in use before the probe
+	if p.BaudRate != 0 {
+		setter.SetBaudRate(p.BaudRate)
+	}
+	return 0, ErrNoBanner
+}
@@ -0,0 +1,387 @@
+package programmer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sonikku2k/PROG05/applet"
+	"github.com/sonikku2k/PROG05/bootloader"
+	"github.com/sonikku2k/PROG05/hc05"
+	"github.com/sonikku2k/PROG05/simulator"
+	"github.com/sonikku2k/PROG05/srec"
+)
+
+// newSimulated attaches a programmer to a simulated HC05 that knows the shipped applets
+func newSimulated(t *testing.T) (*Programmer, *simulator.Target) {
+	t.Helper()
+	target := simulator.New(0)
+	if err := target.RegisterShippedApplets(nil); err != nil {
+		t.Fatal(err)
+	}
+	return newProgrammer(target), target
+}
+
+// newProgrammer returns a programmer with no progress output and short upload pacing, the simulator needs none
+func newProgrammer(port bootloader.Transport) *Programmer {
+	p := New(port, 0)
+	p.Out = io.Discard
+	p.UploadPacing = time.Microsecond
+	return p
+}
+
+// startApplet uploads one of the shipped applets
+func startApplet(t *testing.T, p *Programmer, name string) {
+	t.Helper()
+	if err := p.LoadApplet(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UploadRamBuffer(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTestTarget(t *testing.T) {
+	p, _ := newSimulated(t)
+	startApplet(t, p, applet.GOTEST)
+	if !p.TestTarget() {
+		t.Fatal("no banner from the gotest applet")
+	}
+
+	// Code that does not send the banner
+	startApplet(t, p, applet.MEMREAD)
+	if p.TestTarget() {
+		t.Fatal("banner reported while memread is running")
+	}
+}
+
+func TestReadByte(t *testing.T) {
+	p, target := newSimulated(t)
+	target.Memory[0x1FDF] = 0x84
+	target.Memory[0x0160] = 0x5A
+	startApplet(t, p, applet.MEMREAD)
+
+	for _, c := range []struct {
+		address uint16
+		want    byte
+	}{
+		{0x1FDF, 0x84},
+		{0x0160, 0x5A},
+		{0x2160, 0x5A}, // The 8K map repeats over the 16-bit range
+		{0x0161, 0x00},
+	} {
+		data, err := p.ReadByteFromMCU(c.address)
+		if err != nil {
+			t.Fatalf("%04X: %v", c.address, err)
+		}
+		if data != c.want {
+			t.Errorf("%04X: read %02X, want %02X", c.address, data, c.want)
+		}
+	}
+}
+
+func TestWriteByte(t *testing.T) {
+	p, target := newSimulated(t)
+	startApplet(t, p, applet.MEMWRITE)
+
+	if err := p.WriteByteToMCU(0x00C0, 0x99); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.WriteByteToMCU(0x0160, 0x99); err != nil {
+		t.Fatal(err)
+	}
+	if target.Memory[0x00C0] != 0x99 {
+		t.Errorf("RAM $00C0 holds %02X, want 99", target.Memory[0x00C0])
+	}
+	if target.Memory[0x0160] != 0x00 {
+		t.Errorf("EPROM $0160 changed to %02X by a plain write", target.Memory[0x0160])
+	}
+}
+
+func TestReadMemory(t *testing.T) {
+	p, target := newSimulated(t)
+	for n := 0; n < 300; n++ {
+		target.Memory[0x0100+n] = byte(n * 7)
+	}
+	startApplet(t, p, applet.MEMBLOCK)
+
+	buffer := make([]byte, 300)
+	if err := p.ReadMemory(0x0100, buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer, target.Memory[0x0100:0x0100+300]) {
+		t.Fatal("blocks read differ from the memory of the target")
+	}
+	if p.Transfer.Bytes != 300 || p.Transfer.Retries != 0 {
+		t.Fatalf("transfer %v, want 300 bytes and no retry", p.Transfer)
+	}
+}
+
+func TestDumpMCU(t *testing.T) {
+	p, target := newSimulated(t)
+	copy(target.Memory[0x1000:], []byte{9, 8, 7})
+	target.Memory[0x1FFF] = 0x55
+	startApplet(t, p, applet.MEMBLOCK)
+
+	if err := p.DumpMCU(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.McuDump, target.Memory) {
+		t.Fatal("dump differs from the memory of the target")
+	}
+	if p.Transfer.Bytes != len(target.Memory) {
+		t.Fatalf("%d bytes counted, want the %d bytes of the dump", p.Transfer.Bytes, len(target.Memory))
+	}
+}
+
+// faulty loses or corrupts bytes on their way to or from the simulated HC05
+type faulty struct {
+	*simulator.Target
+	writes  int  // Bytes sent so far
+	drop    int  // Byte sent that is lost (1 for the first, 0 for none)
+	corrupt bool // Flip a byte of the next block received
+}
+
+func (f *faulty) WriteByte(b byte) error {
+	f.writes++
+	if f.writes == f.drop {
+		return nil
+	}
+	return f.Target.WriteByte(b)
+}
+
+func (f *faulty) ReadN(n int, timeout time.Duration) ([]byte, error) {
+	data, err := f.Target.ReadN(n, timeout)
+	if f.corrupt && len(data) > 10 {
+		data[5] ^= 0xFF
+		f.corrupt = false
+	}
+	return data, err
+}
+
+func TestDumpMCURetries(t *testing.T) {
+	target := simulator.New(0)
+	if err := target.RegisterShippedApplets(nil); err != nil {
+		t.Fatal(err)
+	}
+	copy(target.Memory[0x1000:], []byte{9, 8, 7})
+	link := &faulty{Target: target}
+	p := newProgrammer(link)
+	startApplet(t, p, applet.MEMBLOCK)
+
+	// A request is 3 bytes: the second byte of the 6th block request is lost (the block times out), and the first
+	// block received fails its checksum
+	link.drop = link.writes + 3*5 + 2
+	link.corrupt = true
+	if err := p.DumpMCU(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.McuDump, target.Memory) {
+		t.Fatal("dump differs from the memory of the target after the retries")
+	}
+	if p.Transfer.Retries != 2 {
+		t.Fatalf("%d retries, want 2 (one timeout, one checksum mismatch)", p.Transfer.Retries)
+	}
+}
+
+func TestReadMemoryGivesUp(t *testing.T) {
+	p, _ := newSimulated(t)
+	startApplet(t, p, applet.GOTEST) // Nothing answers block requests
+
+	err := p.ReadMemory(0x0100, make([]byte, 16))
+	if !errors.Is(err, applet.ErrTimeout) {
+		t.Fatalf("error %v, want a timeout", err)
+	}
+	if p.Transfer.Retries != BLOCK_ATTEMPTS {
+		t.Fatalf("%d retries, want %d", p.Transfer.Retries, BLOCK_ATTEMPTS)
+	}
+}
+
+func TestReadImageChecks(t *testing.T) {
+	cases := []struct {
+		name       string
+		records    string
+		targetarea uint8
+		message    string
+	}{
+		{"duplicate address", "S1070051AE043F0EA8\nS10400531197\n", RAM_0050, "prog.s19:2: address 0053 is loaded twice"},
+		{"outside RAM", "S104004001BA\n", RAM_0050, "prog.s19:1: address 0040 falls outside of RAM"},
+		{"RAM in an EPROM file", "S1070051AE043F0EA8\n", EPROM_ALL, "prog.s19:1: address 0051 (RAM) is not in EPROM/OTP memory"},
+		{"RAM program after $0051", "S1040060019A\n", RAM_0050, "prog.s19: RAM programs must begin at $0051, not $0060"},
+	}
+	for _, c := range cases {
+		p, _ := newSimulated(t)
+		err := p.ReadImage(strings.NewReader(c.records), "prog.s19", FORMAT_SREC, 0, c.targetarea)
+		if err == nil || !strings.HasPrefix(err.Error(), c.message) {
+			t.Errorf("%s: error %v, want %q", c.name, err, c.message)
+		}
+	}
+}
+
+func TestSecuredPart(t *testing.T) {
+	// The SEC bit disables the bootloader, the applet never runs
+	p, target := newSimulated(t)
+	target.Memory[hc05.OPTION_ADDRESS] |= hc05.OPTION_SEC
+	startApplet(t, p, applet.MEMBLOCK)
+	err := p.DumpMCU()
+	if !errors.Is(err, applet.ErrTimeout) || !strings.Contains(err.Error(), "may be secured") {
+		t.Fatalf("error %v, want a timeout naming a secured part", err)
+	}
+
+	// The bit is read while the applet still runs
+	p, target = newSimulated(t)
+	startApplet(t, p, applet.MEMBLOCK)
+	target.Memory[hc05.OPTION_ADDRESS] |= hc05.OPTION_SEC
+	if err := p.DumpMCU(); !errors.Is(err, ErrSecured) {
+		t.Fatalf("error %v, want ErrSecured", err)
+	}
+}
+
+func TestProbeClock(t *testing.T) {
+	cases := []struct {
+		baud  int // SCI rate of the simulated loader
+		clock int // 0 when no candidate answers
+	}{
+		{hc05.BootloaderBaudRate(2000000), 2000000},
+		{hc05.BootloaderBaudRate(4000000), 4000000},
+		{19200, 0},
+	}
+	for _, c := range cases {
+		p, target := newSimulated(t)
+		target.Baud = c.baud
+		clock, err := p.ProbeClock([]int{2000000, 4000000})
+		if c.clock == 0 {
+			if !errors.Is(err, ErrNoBanner) {
+				t.Errorf("%d baud: %d, %v, want ErrNoBanner", c.baud, clock, err)
+			}
+			continue
+		}
+		if err != nil || clock != c.clock || p.Clock != c.clock || p.BaudRate != c.baud {
+			t.Errorf("%d baud: %d, %v (Clock %d, BaudRate %d), want %d", c.baud, clock, err, p.Clock, p.BaudRate, c.clock)
+		}
+	}
+}
+
+// recorder keeps every byte sent to the simulated HC05
+type recorder struct {
+	*simulator.Target
+	sent []byte
+}
+
+func (r *recorder) WriteByte(b byte) error {
+	r.sent = append(r.sent, b)
+	return r.Target.WriteByte(b)
+}
+
+// loadPromImage fills the EPROM images from S-records holding the segments
+func loadPromImage(t *testing.T, p *Programmer, segments []srec.Segment) {
+	t.Helper()
+	var records bytes.Buffer
+	if err := srec.Write(&records, segments, 0); err != nil {
+		t.Fatal(err)
+	}
+	p.Images.ClearPromImages(p.Erased)
+	if err := p.ReadImage(&records, "image.s19", FORMAT_SREC, 0, EPROM_ALL); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProgramPromImages(t *testing.T) {
+	segments := []srec.Segment{
+		{Address: 0x0160, Data: []byte{0xA6, 0x55, 0x20, 0xFE}},
+		{Address: hc05.OPTION_ADDRESS, Data: []byte{0x02}},
+		{Address: 0x1FFE, Data: []byte{0x01, 0x60}},
+	}
+
+	target := simulator.New(0)
+	if err := target.RegisterShippedApplets(nil); err != nil {
+		t.Fatal(err)
+	}
+	port := &recorder{Target: target}
+	p := newProgrammer(port)
+	loadPromImage(t, p, segments)
+	startApplet(t, p, applet.MEMPROG)
+	target.Vpp = true
+	port.sent = nil
+	programmed, failures, err := p.ProgramPromImages()
+	if err != nil || programmed != 7 || len(failures) != 0 {
+		t.Fatalf("%d bytes programmed, failures %v, error %v, want 7 and none", programmed, failures, err)
+	}
+
+	// Each byte is an address and data triple, the OPTION register comes last
+	if len(port.sent) != 3*programmed {
+		t.Fatalf("%d bytes sent, want %d", len(port.sent), 3*programmed)
+	}
+	last := port.sent[len(port.sent)-3:]
+	if address := uint16(last[0])<<8 | uint16(last[1]); address != hc05.OPTION_ADDRESS || last[2] != 0x02 {
+		t.Errorf("last byte programmed %02X at %04X, want 02 at %04X", last[2], address, hc05.OPTION_ADDRESS)
+	}
+
+	target.Vpp = false
+	startApplet(t, p, applet.MEMBLOCK)
+	failures, err = p.VerifyPromImages()
+	if err != nil || len(failures) != 0 {
+		t.Fatalf("verify: mismatches %v, error %v, want none", failures, err)
+	}
+
+	// Without Vpp nothing is programmed and every byte fails
+	p, target = newSimulated(t)
+	loadPromImage(t, p, segments)
+	startApplet(t, p, applet.MEMPROG)
+	if _, failures, err = p.ProgramPromImages(); err != nil || len(failures) != 7 {
+		t.Fatalf("no Vpp: failures %v, error %v, want 7", failures, err)
+	}
+	startApplet(t, p, applet.MEMBLOCK)
+	failures, err = p.VerifyPromImages()
+	if err != nil || len(failures) != 7 {
+		t.Fatalf("no Vpp, verify: mismatches %v, error %v, want 7", failures, err)
+	}
+}
+
+func TestVerifyPromImages(t *testing.T) {
+	p, target := newSimulated(t)
+	loadPromImage(t, p, []srec.Segment{{Address: 0x0160, Data: []byte{0xA6, 0x55, 0x20, 0xFE}}})
+	copy(target.Memory[0x0160:], []byte{0xA6, 0x54, 0x20})
+	startApplet(t, p, applet.MEMBLOCK)
+
+	failures, err := p.VerifyPromImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mismatch{{Address: 0x0161, Expected: 0x55, Actual: 0x54}, {Address: 0x0163, Expected: 0xFE, Actual: 0x00}}
+	if !reflect.DeepEqual(failures, want) {
+		t.Fatalf("mismatches %+v, want %+v", failures, want)
+	}
+}
+
+func TestBlankCheck(t *testing.T) {
+	for _, erased := range []byte{0x00, 0xFF} {
+		target := simulator.New(erased)
+		if err := target.RegisterShippedApplets(nil); err != nil {
+			t.Fatal(err)
+		}
+		p := newProgrammer(target)
+		p.Erased = erased
+		// An erased OPTION register of $FF has the security bit set, it is cleared while the applet is loaded
+		target.Memory[hc05.OPTION_ADDRESS] &^= hc05.OPTION_SEC
+		startApplet(t, p, applet.MEMBLOCK)
+		target.Memory[hc05.OPTION_ADDRESS] = erased
+
+		spans, err := p.BlankCheck()
+		if err != nil || len(spans) != 0 {
+			t.Fatalf("erased %02X: spans %v, error %v, want none on an erased part", erased, spans, err)
+		}
+
+		copy(target.Memory[0x0160:], []byte{0xA6, 0x55, 0x20, 0xFE})
+		copy(target.Memory[0x1FFE:], []byte{0x01, 0x60})
+		spans, err = p.BlankCheck()
+		want := []Span{{0x0160, 0x0163}, {0x1FFE, 0x1FFF}}
+		if err != nil || !reflect.DeepEqual(spans, want) {
+			t.Errorf("erased %02X: spans %v, error %v, want %v", erased, spans, err, want)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+// Package simulator models a MC68HC705C8 in bootloader mode so PROG05 can run without hardware
+//
+// The mask ROM loader is modelled byte for byte (length byte, then the code stored from $0051, then execution).
+// Once the code is in, the applet is recognised by its image and its protocol is played against an 8K memory map.
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/sonikku2k/PROG05/applet"
+	"github.com/sonikku2k/PROG05/board"
+	"github.com/sonikku2k/PROG05/bootloader"
+	"github.com/sonikku2k/PROG05/hc05"
+	"github.com/sonikku2k/PROG05/srec"
+)
+
+// Protocol spoken by the code running in the simulated HC05
+type Protocol int
+
+const (
+	UNKNOWN  Protocol = iota // Code that does not talk to the host (DEMO, user programs)
+	MEMREAD                  // Address hi/lo in, byte out
+	MEMWRITE                 // Address hi/lo and data in
+	MEMPROG                  // Address hi/lo and data in, byte read back out
+	GOTEST                   // "HC05" banner out
+	MEMBLOCK                 // Address hi/lo and count in, bytes and checksum out
+)
+
+// States of the simulated target
+const (
+	stateLoaderLength = iota // Bootloader waiting for the length byte
+	stateLoaderCode          // Bootloader storing code from $0051
+	stateRunning             // Execution started at $0051
+	stateReset               // Held in reset through the RESET signal
+	stateUser                // Reset released without the bootloader selected, nothing listens on the SCI
+)
+
+// Delay between the start of the gotest applet and its banner
+const BANNER_DELAY = 20 * time.Millisecond
+
+// Timings checked when the board signals are driven (see WireBoard)
+const MIN_RESET_PULSE = time.Millisecond    // Shortest RESET pulse taken as a reset
+const LOADER_STARTUP = 5 * time.Millisecond // Release of RESET to the bootloader listening (4064 cycles of a 1MHz bus)
+
+// Target is a simulated HC05, it implements bootloader.Transport, bootloader.Resetter and board.Driver
+type Target struct {
+	Memory []byte // Entire HC05 address space
+	Vpp    bool   // Programming voltage applied, MEMPROG only programs while it is set (the VPP signal once wired)
+	Baud   int    // SCI rate of the simulated HC05 (0: the host may use any rate)
+
+	mu      sync.Mutex
+	applets map[string]Protocol
+	state   int
+	length  int
+	loaded  int
+	running Protocol
+	banner  *time.Timer // Sends the gotest banner, stopped by a reset
+	command []byte
+	rx      *bootloader.Receiver
+	host    int // Baud rate set by the host, 0 until SetBaudRate is called
+
+	wired      bool            // Board signals driven through SetLine
+	pins       map[string]bool // Board signals, by name
+	heldAt     time.Time       // RESET switched on
+	listening  time.Time       // Bootloader ready for the length byte
+	violations []string        // Timing rules broken by the host
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: New
+// Function: Create a simulated HC05 sitting in the bootloader, every EPROM byte is erased
+// Parameters: Value of an erased EPROM byte
+// Returns: Pointer to the target
+// -------------------------------------------------------------------------------------------------------------------
+func New(erased byte) *Target {
+
+	t := &Target{
+		Memory:  make([]byte, hc05.MEMORY_SIZE),
+		applets: make(map[string]Protocol),
+		rx:      bootloader.NewReceiver(),
+	}
+	for address := range t.Memory {
+		if hc05.IsPromAddress(uint16(address)) {
+			t.Memory[address] = erased
+		}
+	}
+	return t
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: RegisterApplet
+// Function: Tell the simulator which protocol is spoken by a piece of code once it is uploaded
+// Parameters: Code as sent to the bootloader (from $0051), protocol
+// -------------------------------------------------------------------------------------------------------------------
+func (t *Target) RegisterApplet(code []byte, protocol Protocol) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.applets[string(code)] = protocol
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: RegisterShippedApplets
+// Function: Register the applets shipped with PROG05 (built into the binary, or replaced by an external file)
+// Parameters: External S-record files replacing built-in applets, by applet name (may be nil)
+// Returns: error if any applet could not be read
+// -------------------------------------------------------------------------------------------------------------------
+func (t *Target) RegisterShippedApplets(overrides map[string]string) error {
+
+	shipped := map[string]Protocol{
+		applet.MEMREAD:  MEMREAD,
+		applet.MEMWRITE: MEMWRITE,
+		applet.MEMPROG:  MEMPROG,
+		applet.MEMBLOCK: MEMBLOCK,
+		applet.GOTEST:   GOTEST,
+	}
+	for name, protocol := range shipped {
+		ram := make([]byte, 256)
+		store := func(address uint16, data byte) error {
+			if address > 0xFF {
+				return errors.New("applet does not fit in RAM")
+			}
+			ram[address] = data
+			return nil
+		}
+		var info srec.Info
+		var err error
+		if path, ok := overrides[name]; ok {
+			info, err = srec.Load(path, store)
+		} else {
+			var file fs.File
+			file, err = srec.APPLETS.Open(name)
+			if err != nil {
+				return err
+			}
+			info, err = srec.Read(file, name, store)
+			file.Close()
+		}
+		if err != nil {
+			return err
+		}
+		t.RegisterApplet(ram[info.Lowest:int(info.Highest)+1], protocol)
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Reset
+// Function: Reset the target with the loader enabled, it waits for a new length byte
+// -------------------------------------------------------------------------------------------------------------------
+func (t *Target) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = stateLoaderLength
+	t.running = UNKNOWN
+	t.command = t.command[:0]
+	t.stopBanner()
+}
+
+// ResetTarget implements bootloader.Resetter, it does nothing once the board is wired (RESET is then a signal)
+func (t *Target) ResetTarget() error {
+	t.mu.Lock()
+	wired := t.wired
+	t.mu.Unlock()
+	if !wired {
+		t.Reset()
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: WireBoard
+// Function: Have the board signals driven through SetLine (see board.Direct), as on a board under PROG05 control: the
+// bootloader only starts through the RESET, IRQ and PD5-PD2 sequence and MEMPROG follows the VPP signal. The target
+// waits for a reset.
+// -------------------------------------------------------------------------------------------------------------------
+func (t *Target) WireBoard() {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.wired = true
+	t.pins = make(map[string]bool)
+	for _, signal := range board.SIGNALS {
+		t.pins[signal] = false
+	}
+	for _, signal := range board.MODE_LINES {
+		t.pins[signal] = true // Pulled up
+	}
+	t.state = stateUser
+	t.Vpp = false
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: SetLine
+// Function: Set a board signal of the simulated HC05 (implements board.Driver). The timings of the bootloader are
+// checked, each rule broken is recorded (see Violations).
+// Parameters: Signal (RESET, IRQ, VPP, PD5-PD2), level (true: on)
+// Returns: error if the board is not wired or the signal is unknown
+// -------------------------------------------------------------------------------------------------------------------
+func (t *Target) SetLine(line string, level bool) error {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.wired {
+		return errors.New("the board signals of the simulated HC05 are not wired")
+	}
+	previous, found := t.pins[line]
+	if !found {
+		return fmt.Errorf("the simulated board has no %s signal", line)
+	}
+	t.pins[line] = level
+	now := time.Now()
+	switch {
+	case line == board.RESET && level && !previous:
+		t.heldAt = now
+		t.state = stateReset
+		t.running = UNKNOWN
+		t.command = t.command[:0]
+		t.stopBanner()
+	case line == board.RESET && !level && previous:
+		if held := now.Sub(t.heldAt); held < MIN_RESET_PULSE {
+			t.violate("RESET held %s, a reset needs %s", held, MIN_RESET_PULSE)
+		}
+		if !t.pins[board.IRQ] || t.mode() != board.MODE_SCI_LOADER {
+			t.violate("RESET released without the SCI loader selected (IRQ %t, PD5-PD2 %X)", t.pins[board.IRQ], t.mode())
+			t.state = stateUser
+			break
+		}
+		t.state = stateLoaderLength
+		t.listening = now.Add(LOADER_STARTUP)
+	case line == board.VPP:
+		if level && !previous && (t.state != stateRunning || t.running != MEMPROG) {
+			t.violate("Vpp switched on while the programming applet is not running")
+		}
+		t.Vpp = level
+	}
+	return nil
+}
+
+// Violations returns the timing rules the host broke since the last call, and forgets them
+func (t *Target) Violations() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	violations := t.violations
+	t.violations = nil
+	return violations
+}
+
+// violate records a rule broken by the host, the lock must be held
+func (t *Target) violate(format string, args ...interface{}) {
+	t.violations = append(t.violations, fmt.Sprintf(format, args...))
+}
+
+// mode returns the levels of PD5-PD2, the lock must be held
+func (t *Target) mode() int {
+	mode := 0
+	for _, signal := range board.MODE_LINES {
+		mode <<= 1
+		if t.pins[signal] {
+			mode |= 1
+		}
+	}
+	return mode
+}
+
+// SetBaudRate implements bootloader.BaudRateSetter, bytes sent at a rate the simulated SCI does not match are lost
+func (t *Target) SetBaudRate(baudrate int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.host = baudrate
+	return nil
+}
+
+// WriteByte receives a byte on the simulated SCI
+func (t *Target) WriteByte(b byte) error {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Baud != 0 && t.host != 0 && !hc05.BaudRatesMatch(t.host, t.Baud) {
+		return nil // Framing error, the SCI drops the byte
+	}
+	if t.wired {
+		switch {
+		case t.state == stateReset:
+			t.violate("byte %02X sent while RESET is on", b)
+			return nil
+		case t.state == stateUser:
+			t.violate("byte %02X sent while the bootloader is not running", b)
+			return nil
+		case t.state == stateLoaderLength && time.Now().Before(t.listening):
+			t.violate("byte %02X sent before the bootloader listens, %s after RESET is released", b, LOADER_STARTUP)
+			return nil
+		}
+	}
+	if t.state <= stateLoaderCode && hc05.IsSecured(t.Memory[hc05.OPTION_ADDRESS]) {
+		return nil // The SEC bit disables the bootloader, nothing listens on the SCI
+	}
+	switch t.state {
+	case stateLoaderLength:
+		// The length byte counts itself
+		t.length = int(b) - 1
+		t.loaded = 0
+		t.state = stateLoaderCode
+		if t.length <= 0 {
+			t.start()
+		}
+	case stateLoaderCode:
+		t.Memory[0x51+t.loaded] = b
+		t.loaded++
+		if t.loaded == t.length {
+			t.start()
+		}
+	case stateRunning:
+		t.command = append(t.command, b)
+		t.execute()
+	}
+	return nil
+}
+
+// ReadN waits for bytes sent by the target
+func (t *Target) ReadN(n int, timeout time.Duration) ([]byte, error) {
+	return t.rx.ReadN(n, timeout)
+}
+
+// Flush empties the bytes sent by the target
+func (t *Target) Flush() {
+	t.rx.Flush()
+}
+
+// Close does nothing, it is there to satisfy bootloader.Transport
+func (t *Target) Close() error {
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: start
+// Function: The loader jumps to $0051, recognise the code that was loaded
+// -------------------------------------------------------------------------------------------------------------------
+func (t *Target) start() {
+	t.state = stateRunning
+	t.running = t.applets[string(t.Memory[0x51:0x51+t.loaded])]
+	t.command = t.command[:0]
+	t.stopBanner()
+	if t.running == GOTEST {
+		t.banner = time.AfterFunc(BANNER_DELAY, func() { t.rx.Put([]byte("HC05\r")) })
+	}
+}
+
+// stopBanner cancels a gotest banner not sent yet
+func (t *Target) stopBanner() {
+	if t.banner != nil {
+		t.banner.Stop()
+		t.banner = nil
+	}
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: execute
+// Function: Play the protocol of the running applet once a full command has been received
+// -------------------------------------------------------------------------------------------------------------------
+func (t *Target) execute() {
+
+	switch t.running {
+	case MEMREAD:
+		if len(t.command) < 2 {
+			return
+		}
+		address := uint16(t.command[0])<<8 | uint16(t.command[1])
+		t.rx.Put([]byte{t.read(address)})
+	case MEMBLOCK:
+		if len(t.command) < 3 {
+			return
+		}
+		address := uint16(t.command[0])<<8 | uint16(t.command[1])
+		count := int(t.command[2])
+		if count == 0 {
+			count = 256
+		}
+		checksum := t.command[0] + t.command[1] + t.command[2]
+		block := make([]byte, 0, count+1)
+		for n := 0; n < count; n++ {
+			data := t.read(address + uint16(n))
+			block = append(block, data)
+			checksum += data
+		}
+		t.rx.Put(append(block, checksum))
+	case MEMWRITE:
+		if len(t.command) < 3 {
+			return
+		}
+		address := uint16(t.command[0])<<8 | uint16(t.command[1])
+		// The EPROM can only be changed by the programming sequence
+		if !hc05.IsPromAddress(address & 0x1FFF) {
+			t.Memory[address&0x1FFF] = t.command[2]
+		}
+	case MEMPROG:
+		if len(t.command) < 3 {
+			return
+		}
+		address := uint16(t.command[0])<<8 | uint16(t.command[1])
+		// Programming can only set the bits of an erased ($00) EPROM byte
+		if t.Vpp && hc05.IsPromAddress(address&0x1FFF) {
+			t.Memory[address&0x1FFF] |= t.command[2]
+		}
+		t.rx.Put([]byte{t.read(address)})
+	default:
+		// Nothing is listening on the SCI
+	}
+	t.command = t.command[:0]
+}
+
+// read returns a byte of the memory map, the 8K space repeats over the 16-bit address range
+func (t *Target) read(address uint16) byte {
+	return t.Memory[address&0x1FFF]
+}
@@ -0,0 +1,104 @@
+package simulator
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sonikku2k/PROG05/applet"
+	"github.com/sonikku2k/PROG05/board"
+	"github.com/sonikku2k/PROG05/programmer"
+)
+
+// wired returns a simulated HC05 whose board signals are driven by the programmer, as a hands-free board would
+func wired(t *testing.T) (*Target, *programmer.Programmer) {
+	t.Helper()
+	target := New(0)
+	if err := target.RegisterShippedApplets(nil); err != nil {
+		t.Fatal(err)
+	}
+	target.WireBoard()
+	p := programmer.New(target, 0)
+	p.Out = io.Discard
+	p.UploadPacing = time.Microsecond
+	p.Board = board.Direct(target)
+	return target, p
+}
+
+// upload starts an applet through the board, the bootloader is entered first
+func upload(t *testing.T, p *programmer.Programmer, name string) {
+	t.Helper()
+	if err := p.LoadApplet(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UploadRamBuffer(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoardTimings(t *testing.T) {
+	target, p := wired(t)
+	upload(t, p, applet.GOTEST)
+	if !p.TestTarget() {
+		t.Fatal("no banner from the gotest applet")
+	}
+
+	upload(t, p, applet.MEMPROG)
+	if err := p.Board.SetVpp(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applet.ProgramByte(target, 0x0160, 0x5A); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Board.SetVpp(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Board.Idle(); err != nil {
+		t.Fatal(err)
+	}
+	if violations := target.Violations(); len(violations) != 0 {
+		t.Fatalf("timings broken: %q", violations)
+	}
+	if target.Memory[0x0160] != 0x5A {
+		t.Fatalf("$0160 holds %02X after programming 5A", target.Memory[0x0160])
+	}
+}
+
+func TestBoardTimingViolations(t *testing.T) {
+	cases := []struct {
+		name      string
+		run       func(t *testing.T, target *Target, p *programmer.Programmer)
+		violation string
+	}{
+		{"short RESET pulse", func(t *testing.T, target *Target, p *programmer.Programmer) {
+			p.Board.Pulse = 0
+			upload(t, p, applet.GOTEST)
+		}, "RESET held"},
+		{"upload before the bootloader listens", func(t *testing.T, target *Target, p *programmer.Programmer) {
+			p.Board.Settle = 0
+			upload(t, p, applet.GOTEST)
+		}, "before the bootloader listens"},
+		{"Vpp without the programming applet", func(t *testing.T, target *Target, p *programmer.Programmer) {
+			upload(t, p, applet.MEMREAD)
+			p.Board.SetVpp(true)
+		}, "Vpp switched on while the programming applet is not running"},
+		{"reset without the SCI loader selected", func(t *testing.T, target *Target, p *programmer.Programmer) {
+			target.SetLine(board.RESET, true)
+			time.Sleep(MIN_RESET_PULSE)
+			target.SetLine(board.RESET, false)
+		}, "RESET released without the SCI loader selected"},
+		{"byte sent during reset", func(t *testing.T, target *Target, p *programmer.Programmer) {
+			target.SetLine(board.RESET, true)
+			target.WriteByte(0x10)
+		}, "byte 10 sent while RESET is on"},
+	}
+	for _, c := range cases {
+		target, p := wired(t)
+		c.run(t, target, p)
+		violations := target.Violations()
+		if len(violations) == 0 || !strings.Contains(violations[0], c.violation) {
+			t.Errorf("%s: violations %q, want %q", c.name, violations, c.violation)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package srec
+
+import "embed"
+
+// APPLETS holds the applet S-records of this directory, compiled into the binary so PROG05 runs from any directory
+//
+//go:embed *.s19
+var APPLETS embed.FS
@@ -0,0 +1,208 @@
+// Package srec reads and writes Motorola S-record files for PROG05
+package srec
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Info describes an S-record file once parsed
+type Info struct {
+	Header   string // Text of the S0 record, if any
+	Length   int    // Number of data bytes stored
+	First    uint16 // Address of the first data record
+	Lowest   uint16 // Lowest address stored
+	Highest  uint16 // Highest address stored
+	Start    uint16 // Start address of the S7/S8/S9 record
+	HasStart bool   // true if the termination record holds a start address (0000 is taken as none)
+	Records  int    // Number of data records
+}
+
+// Error is a parse error, located by file name and line number
+type Error struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Size of the address field by record type, 0 for types that are not valid
+var ADDRESS_SIZE = [10]int{2, 2, 3, 4, 0, 2, 3, 4, 3, 2}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Load
+// Function: Load Motorola S-Record file from the disk and parse it, every data byte decoded is handed to the store
+// function which decides where it goes (and may refuse it)
+// Parameters: Full path to the file that shall be opened, function storing one byte at an HC05 address
+// Returns: Description of the file, error if any
+// -------------------------------------------------------------------------------------------------------------------
+func Load(path string, store func(address uint16, data byte) error) (Info, error) {
+
+	srec, err := os.Open(path)
+	if err != nil {
+		return Info{}, fmt.Errorf("error opening file: %w", err)
+	}
+	defer srec.Close()
+	return Read(srec, path, store)
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Read
+// Function: Parse S-records from a reader, every data byte decoded is handed to the store function. Byte counts,
+// checksums and the S5/S6 record count are checked, addresses must fit the 16-bit HC05 address space
+// Parameters: Reader supplying the S-records, name used in error messages, function storing one byte at an HC05 address
+// Returns: Description of the file, error (*Error) if any
+// -------------------------------------------------------------------------------------------------------------------
+func Read(srec io.Reader, name string, store func(address uint16, data byte) error) (Info, error) {
+
+	var info Info
+	var ended = false
+	var number = 0
+
+	srecords := bufio.NewScanner(srec)
+	for srecords.Scan() {
+		number++
+		fail := func(format string, a ...interface{}) (Info, error) {
+			return info, &Error{name, number, fmt.Errorf(format, a...)}
+		}
+		line := strings.TrimSpace(srecords.Text())
+		if line == "" {
+			continue
+		}
+
+		// Each line of the S-record is parsed here: Stnn aaaa dd... cc
+		if len(line) < 4 || line[0] != 'S' && line[0] != 's' || line[1] < '0' || line[1] > '9' {
+			return fail("not an S-record")
+		}
+		recordtype := int(line[1] - '0')
+		addresssize := ADDRESS_SIZE[recordtype]
+		if addresssize == 0 {
+			return fail("record type S%d is not valid", recordtype)
+		}
+		if ended {
+			return fail("S%d record after the termination record", recordtype)
+		}
+		record, err := hex.DecodeString(line[2:])
+		if err != nil {
+			return fail("invalid hexadecimal digits")
+		}
+		if int(record[0]) != len(record)-1 {
+			return fail("byte count %02X does not match the %d bytes of the record", record[0], len(record)-1)
+		}
+		if len(record) < 1+addresssize+1 {
+			return fail("record too short for an S%d record", recordtype)
+		}
+		var checksum byte
+		for _, b := range record[:len(record)-1] {
+			checksum += b
+		}
+		if ^checksum != record[len(record)-1] {
+			return fail("checksum %02X, expected %02X", record[len(record)-1], ^checksum)
+		}
+		var address uint32
+		for _, b := range record[1 : 1+addresssize] {
+			address = address<<8 | uint32(b)
+		}
+		data := record[1+addresssize : len(record)-1]
+
+		switch recordtype {
+		case 0:
+			info.Header = strings.TrimRight(string(data), "\x00 ")
+		case 1, 2, 3:
+			if address+uint32(len(data)) > 0x10000 {
+				return fail("data at %X-%X is beyond the HC05 address space", address, address+uint32(len(data))-1)
+			}
+			// An empty data record stores nothing but still counts for the S5/S6 record
+			info.Records++
+			if len(data) == 0 {
+				continue
+			}
+			if info.Length == 0 {
+				info.First = uint16(address)
+				info.Lowest = uint16(address)
+			}
+			for n, b := range data {
+				err = store(uint16(address)+uint16(n), b)
+				if err != nil {
+					return fail("%w", err)
+				}
+			}
+			if uint16(address) < info.Lowest {
+				info.Lowest = uint16(address)
+			}
+			if last := uint16(address) + uint16(len(data)) - 1; last > info.Highest {
+				info.Highest = last
+			}
+			info.Length += len(data)
+		case 5, 6:
+			if int(address) != info.Records {
+				return fail("record count %d, but %d data records were read", address, info.Records)
+			}
+		case 7, 8, 9:
+			if address > 0xFFFF {
+				return fail("start address %X is beyond the HC05 address space", address)
+			}
+			info.Start = uint16(address)
+			info.HasStart = address != 0
+			ended = true
+		}
+	}
+	if err := srecords.Err(); err != nil {
+		return info, &Error{name, number, err}
+	}
+	return info, nil
+}
+
+// Segment is a run of contiguous bytes starting at an HC05 address
+type Segment struct {
+	Address uint16
+	Data    []byte
+}
+
+// -------------------------------------------------------------------------------------------------------------------
+// Name: Write
+// Function: Write segments as S1 records of up to 16 data bytes, terminated by an S9 record
+// Parameters: Writer, segments, start address placed in the S9 record
+// Returns: error if any
+// -------------------------------------------------------------------------------------------------------------------
+func Write(w io.Writer, segments []Segment, start uint16) error {
+
+	for _, segment := range segments {
+		for offset := 0; offset < len(segment.Data); offset += 16 {
+			end := offset + 16
+			if end > len(segment.Data) {
+				end = len(segment.Data)
+			}
+			err := writeRecord(w, '1', segment.Address+uint16(offset), segment.Data[offset:end])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return writeRecord(w, '9', start, nil)
+}
+
+// writeRecord writes a single record with a 16-bit address, the byte count covers address, data and checksum
+func writeRecord(w io.Writer, recordtype byte, address uint16, data []byte) error {
+
+	count := byte(len(data) + 3)
+	checksum := count + byte(address>>8) + byte(address)
+	line := fmt.Sprintf("S%c%02X%04X", recordtype, count, address)
+	for _, b := range data {
+		line += fmt.Sprintf("%02X", b)
+		checksum += b
+	}
+	_, err := fmt.Fprintf(w, "%s%02X\n", line, ^checksum)
+	return err
+}
@@ -0,0 +1,120 @@
+package srec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// ramStore accepts each address of $0051-$00FF once, the way PROG05 loads a RAM program
+func ramStore() func(address uint16, data byte) error {
+	loaded := make(map[uint16]bool)
+	return func(address uint16, data byte) error {
+		if loaded[address] {
+			return fmt.Errorf("address %04X is loaded twice", address)
+		}
+		if address < 0x0051 || address > 0x00FF {
+			return fmt.Errorf("address %04X falls outside of RAM", address)
+		}
+		loaded[address] = true
+		return nil
+	}
+}
+
+func TestRead(t *testing.T) {
+	info, err := Read(strings.NewReader("S007000054455354B8\r\n"+
+		"S1070051AE043F0EA8\r\n"+
+		"\r\n"+
+		"S1050055A60CF3\r\n"+
+		"S5030002FA\r\n"+
+		"S9030051AB\r\n"), "ok.s19", ramStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Info{Header: "TEST", Length: 6, First: 0x0051, Lowest: 0x0051, Highest: 0x0056, Start: 0x0051, HasStart: true, Records: 2}
+	if info != want {
+		t.Fatalf("info %+v, want %+v", info, want)
+	}
+}
+
+// An S1 record without data stores nothing but counts for the S5 record
+func TestReadEmptyRecord(t *testing.T) {
+	info, err := Read(strings.NewReader("S1030051AB\nS1050055A60CF3\nS5030002FA\n"), "empty.s19", ramStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Records != 2 || info.Length != 2 || info.First != 0x0055 || info.Lowest != 0x0055 {
+		t.Fatalf("info %+v, want 2 records and 2 bytes from 0055", info)
+	}
+}
+
+// The error of the store function is kept in the chain
+func TestReadStoreError(t *testing.T) {
+	refused := errors.New("refused")
+	_, err := Read(strings.NewReader("S1050055A60CF3\n"), "store.s19", func(address uint16, data byte) error {
+		return refused
+	})
+	if !errors.Is(err, refused) {
+		t.Fatalf("error %v does not wrap the error of the store function", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		records string
+		line    int
+		message string
+	}{
+		{"bad checksum", "S1070051AE043F0EA8\nS1050055A60CF4\n", 2, "checksum F4, expected F3"},
+		{"byte count too large", "S1080051AE043F0EA8\n", 1, "byte count 08 does not match the 7 bytes"},
+		{"byte count too small", "S1060051AE043F0EA8\n", 1, "byte count 06 does not match the 7 bytes"},
+		{"record too short", "S10200FD\n", 1, "record too short"},
+		{"S5 count mismatch", "S1070051AE043F0EA8\nS1050055A60CF3\nS5030003F9\n", 3, "record count 3, but 2 data records"},
+		{"S5 before the data", "S5030002FA\nS1070051AE043F0EA8\n", 1, "record count 2, but 0 data records"},
+		{"unknown record type S4", "S4030000FC\n", 1, "record type S4 is not valid"},
+		{"not an S-record", "S1070051AE043F0EA8\n:0100000000\n", 2, "not an S-record"},
+		{"odd hexadecimal digits", "S1070051AE043F0EA\n", 1, "invalid hexadecimal digits"},
+		{"data after the end", "S9030051AB\nS1050055A60CF3\n", 2, "S1 record after the termination record"},
+		{"duplicate address", "S1070051AE043F0EA8\nS10400531197\n", 2, "address 0053 is loaded twice"},
+		{"outside the target area", "S104004001BA\n", 1, "address 0040 falls outside of RAM"},
+		{"beyond 64K", "S20501000001F8\n", 1, "data at 10000-10000 is beyond the HC05 address space"},
+		{"wrapping past FFFF", "S105FFFF0102F9\n", 1, "data at FFFF-10000 is beyond the HC05 address space"},
+		{"start beyond 64K", "S804010000FA\n", 1, "start address 10000 is beyond"},
+	}
+	for _, c := range cases {
+		_, err := Read(strings.NewReader(c.records), "test.s19", ramStore())
+		var located *Error
+		if !errors.As(err, &located) {
+			t.Errorf("%s: error %v, want a *srec.Error", c.name, err)
+			continue
+		}
+		if located.File != "test.s19" || located.Line != c.line {
+			t.Errorf("%s: reported at %s:%d, want test.s19:%d", c.name, located.File, located.Line, c.line)
+		}
+		prefix := fmt.Sprintf("test.s19:%d: ", c.line)
+		if !strings.HasPrefix(err.Error(), prefix) || !strings.Contains(err.Error(), c.message) {
+			t.Errorf("%s: error %q, want %q%s", c.name, err, prefix, c.message)
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	segments := []Segment{{0x0051, []byte{1, 2, 3}}, {0x0100, make([]byte, 40)}}
+	var records strings.Builder
+	if err := Write(&records, segments, 0x0051); err != nil {
+		t.Fatal(err)
+	}
+	stored := make(map[uint16]byte)
+	info, err := Read(strings.NewReader(records.String()), "written", func(address uint16, data byte) error {
+		stored[address] = data
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Length != 43 || len(stored) != 43 || stored[0x0053] != 3 || info.Start != 0x0051 {
+		t.Fatalf("read back %+v, %d bytes stored", info, len(stored))
+	}
+}